@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+)
+
+// bootstrapAdmin makes the first admin, either by promoting an existing user or by creating a new one.
+// Once there is an admin, roles are managed through the /admin/users/{userId}/role route
+func bootstrapAdmin(ctx context.Context, dbQueries *database.Queries, args []string) error {
+	flags := flag.NewFlagSet("bootstrap-admin", flag.ContinueOnError)
+	email := flags.String("email", "", "email of the admin, an existing user is promoted")
+	password := flags.String("password", os.Getenv("ADMIN_PASSWORD"), "password when the user has to be created (defaults to $ADMIN_PASSWORD)")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if *email == "" {
+		return errors.New("the -email flag is required")
+	}
+
+	adminCount, err := dbQueries.CountUsersWithRole(ctx, string(auth.RoleAdmin))
+	if err != nil {
+		return err
+	}
+	if adminCount > 0 {
+		return errors.New("there is already an admin, roles can now be changed with PUT /admin/users/{userId}/role")
+	}
+
+	user, err := dbQueries.GetUserByEmail(ctx, *email)
+	if errors.Is(err, sql.ErrNoRows) {
+		if *password == "" {
+			return fmt.Errorf("no user has the email %v, a -password is needed to create it", *email)
+		}
+		hashedPassword, err := auth.HashPassword(*password)
+		if err != nil {
+			return err
+		}
+		user, err = dbQueries.CreateUser(ctx, database.CreateUserParams{
+			Email:          *email,
+			HashedPassword: hashedPassword,
+		})
+		if err != nil {
+			return err
+		}
+		// whoever runs the command on the server is trusted with the address
+		user, err = dbQueries.VerifyUserEmail(ctx, database.VerifyUserEmailParams{ID: user.ID, Email: user.Email})
+		if err != nil {
+			return err
+		}
+	} else if err != nil {
+		return err
+	}
+	_, err = dbQueries.SetUserRole(ctx, database.SetUserRoleParams{
+		ID:   user.ID,
+		Role: string(auth.RoleAdmin),
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v is now an admin\n", user.Email)
+	return nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"math/rand/v2"
+	"sync"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	outboxDispatchPeriod = 2 * time.Second
+	// a slow mail server can make a batch outlast it, the event claimed again then skips the subscribers done with it
+	outboxLease          = 5 * time.Minute
+	outboxBatchSize      = 50
+	outboxRetryBaseDelay = 5 * time.Second
+	outboxRetryMaxDelay  = time.Hour
+	// the dispatched events are kept a while for the investigations, then pruned
+	outboxRetention        = 7 * 24 * time.Hour
+	outboxPruneCheckPeriod = time.Hour
+)
+
+// the events published on the bus, they are also sent to the webhook subscriptions
+const (
+	eventChirpCreated        = "chirp.created"
+	eventChirpUpdated        = "chirp.updated"   // the author edited the body
+	eventChirpPublished      = "chirp.published" // a moderator published a held chirp
+	eventChirpDeleted        = "chirp.deleted"
+	eventUserCreated         = "user.created"
+	eventUserUpdated         = "user.updated"
+	eventSubscriptionChanged = "subscription.changed"
+)
+
+// the events only the in-process subscribers get, they hold email addresses the webhooks shouldn't see
+const (
+	eventEmailVerificationRequested = "email.verification_requested"
+	eventPasswordResetRequested     = "password.reset_requested"
+	eventLoginLockedOut             = "login.locked_out"
+)
+
+// event is a change that happened in chirpy, as it was saved in the outbox
+type event struct {
+	Id        uuid.UUID
+	Type      string
+	CreatedAt time.Time
+	Data      json.RawMessage
+}
+
+// eventHandler applies an event for a subscriber. The queries belong to the transaction recording that the
+// subscriber handled the event: what is written with them is applied once, even if the event is dispatched again
+type eventHandler func(ctx context.Context, queries *database.Queries, evt event) error
+
+type eventSubscriber struct {
+	name       string // identifies the subscriber in the outbox, it shouldn't change once events were handled
+	eventTypes map[string]bool
+	handle     eventHandler
+}
+
+// eventBus feeds the events of the outbox to the in-process subscribers
+type eventBus struct {
+	db          *sql.DB
+	dbQueries   *database.Queries
+	subscribers []eventSubscriber
+	counts      eventCounts
+}
+
+func newEventBus(db *sql.DB, dbQueries *database.Queries) *eventBus {
+	return &eventBus{db: db, dbQueries: dbQueries}
+}
+
+// subscribe registers the handler for the given event types, or for every event when there is none. It should be
+// called before run
+func (bus *eventBus) subscribe(name string, handle eventHandler, eventTypes ...string) {
+	subscriber := eventSubscriber{name: name, handle: handle}
+	if len(eventTypes) > 0 {
+		subscriber.eventTypes = map[string]bool{}
+		for _, eventType := range eventTypes {
+			subscriber.eventTypes[eventType] = true
+		}
+	}
+	bus.subscribers = append(bus.subscribers, subscriber)
+}
+
+// publishEvent writes the event in the outbox. The queries should be the ones of the transaction making the change,
+// so the event exists if and only if the change is committed
+func publishEvent(ctx context.Context, queries *database.Queries, eventType string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	return queries.CreateOutboxEvent(ctx, database.CreateOutboxEventParams{
+		ID:        uuid.New(),
+		EventType: eventType,
+		Payload:   payload,
+	})
+}
+
+// run dispatches the due events, several instances can run it since each event is claimed
+func (bus *eventBus) run() {
+	ticker := time.NewTicker(outboxDispatchPeriod)
+	defer ticker.Stop()
+	lastPrune := time.Time{}
+	for {
+		for bus.dispatchDueEvents(context.Background()) == outboxBatchSize {
+			// a full batch may have left more due events
+		}
+		if time.Since(lastPrune) > outboxPruneCheckPeriod {
+			bus.pruneDispatchedEvents(context.Background())
+			lastPrune = time.Now()
+		}
+		<-ticker.C
+	}
+}
+
+// dispatchDueEvents hands a batch of due events to the subscribers and tells how many there were. A failed event is
+// retried later, only with the subscribers that didn't handle it yet, so the subscribers can't rely on the order of
+// the events
+func (bus *eventBus) dispatchDueEvents(ctx context.Context) int {
+	outboxEvents, err := bus.dbQueries.ClaimDueOutboxEvents(ctx, database.ClaimDueOutboxEventsParams{
+		LeasedUntil: time.Now().Add(outboxLease),
+		RowLimit:    outboxBatchSize,
+	})
+	if err != nil {
+		log.Printf("error when claiming the due outbox events: %v", err)
+		return 0
+	}
+	for _, outboxEvent := range outboxEvents {
+		evt := event{Id: outboxEvent.ID, Type: outboxEvent.EventType, CreatedAt: outboxEvent.CreatedAt, Data: outboxEvent.Payload}
+		err = bus.dispatchEvent(ctx, evt)
+		if err == nil {
+			err = bus.dbQueries.MarkOutboxEventDispatched(ctx, evt.Id)
+			if err == nil {
+				bus.counts.count(evt.Type)
+			}
+		} else {
+			log.Printf("error when dispatching the %v event %v: %v", evt.Type, evt.Id, err)
+			err = bus.dbQueries.RetryOutboxEventLater(ctx, database.RetryOutboxEventLaterParams{
+				ID:            evt.Id,
+				NextAttemptAt: time.Now().Add(retryDelay(outboxEvent.Attempts+1, outboxRetryBaseDelay, outboxRetryMaxDelay)),
+				LastError:     sql.NullString{String: err.Error(), Valid: true},
+			})
+		}
+		if err != nil { // the lease runs out and the event is dispatched again
+			log.Printf("error when recording the dispatch of the event %v: %v", evt.Id, err)
+		}
+	}
+	return len(outboxEvents)
+}
+
+// dispatchEvent gives the event to every subscriber wanting it, each one in its own transaction so the failure of
+// one doesn't undo the others
+func (bus *eventBus) dispatchEvent(ctx context.Context, evt event) error {
+	var failed []string
+	for _, subscriber := range bus.subscribers {
+		if subscriber.eventTypes != nil && !subscriber.eventTypes[evt.Type] {
+			continue
+		}
+		err := bus.handleEvent(ctx, subscriber, evt)
+		if err != nil {
+			log.Printf("error when the subscriber %v handled the event %v: %v", subscriber.name, evt.Id, err)
+			failed = append(failed, subscriber.name)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("the subscribers %v failed", failed)
+	}
+	return nil
+}
+
+func (bus *eventBus) handleEvent(ctx context.Context, subscriber eventSubscriber, evt event) error {
+	tx, err := bus.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := bus.dbQueries.WithTx(tx)
+
+	firstTime, err := queries.MarkOutboxEventHandled(ctx, database.MarkOutboxEventHandledParams{
+		EventID:    evt.Id,
+		Subscriber: subscriber.name,
+	})
+	if err != nil || firstTime == 0 {
+		return err
+	}
+	err = subscriber.handle(ctx, queries, evt)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (bus *eventBus) pruneDispatchedEvents(ctx context.Context) {
+	pruned, err := bus.dbQueries.DeleteDispatchedOutboxEvents(ctx, sql.NullTime{Time: time.Now().Add(-outboxRetention), Valid: true})
+	if err != nil {
+		log.Printf("error when pruning the outbox: %v", err)
+	} else if pruned > 0 {
+		log.Printf("%v dispatched events pruned from the outbox", pruned)
+	}
+}
+
+// retryDelay doubles the wait after each failed attempt, up to the max. Some jitter keeps what failed together (a
+// subscriber being down) from being all retried at once
+func retryDelay(attempts int32, baseDelay, maxDelay time.Duration) time.Duration {
+	delay := maxDelay
+	if attempts < 20 {
+		delay = min(baseDelay<<(attempts-1), maxDelay)
+	}
+	return delay + rand.N(delay/10)
+}
+
+// eventCounts counts the events this instance dispatched to all their subscribers since it started, it is shown on
+// the metrics page
+type eventCounts struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+func (counts *eventCounts) count(eventType string) {
+	counts.mu.Lock()
+	defer counts.mu.Unlock()
+	if counts.counts == nil {
+		counts.counts = map[string]int64{}
+	}
+	counts.counts[eventType]++
+}
+
+func (counts *eventCounts) snapshot() map[string]int64 {
+	counts.mu.Lock()
+	defer counts.mu.Unlock()
+	return maps.Clone(counts.counts)
+}
+
+type emailVerificationEvent struct {
+	UserId uuid.UUID `json:"user_id"`
+	Email  string    `json:"email"`
+}
+
+type passwordResetEvent struct {
+	Email string `json:"email"`
+}
+
+type loginLockedOutEvent struct {
+	Email       string    `json:"email"`
+	LockedUntil time.Time `json:"locked_until"`
+}
+
+// sendEventEmails is the subscriber of the event bus mailing the users, an email that couldn't be sent is retried
+// with the event
+func (cfg *ApiConfig) sendEventEmails(ctx context.Context, queries *database.Queries, evt event) error {
+	ctx, cancel := context.WithTimeout(ctx, emailSendTimeout)
+	defer cancel()
+	switch evt.Type {
+	case eventEmailVerificationRequested:
+		var verification emailVerificationEvent
+		err := json.Unmarshal(evt.Data, &verification)
+		if err != nil {
+			return err
+		}
+		return cfg.sendEmailVerification(ctx, queries, verification.UserId, verification.Email)
+	case eventPasswordResetRequested:
+		var reset passwordResetEvent
+		err := json.Unmarshal(evt.Data, &reset)
+		if err != nil {
+			return err
+		}
+		return cfg.sendPasswordReset(ctx, queries, reset.Email)
+	case eventLoginLockedOut:
+		var lockout loginLockedOutEvent
+		err := json.Unmarshal(evt.Data, &lockout)
+		if err != nil || cfg.onLockout == nil {
+			return err
+		}
+		return cfg.onLockout(ctx, lockout.Email, lockout.LockedUntil)
+	}
+	return nil
+}
@@ -8,4 +8,7 @@ require (
 	github.com/joho/godotenv v1.5.1
 	github.com/lib/pq v1.10.9
 	golang.org/x/crypto v0.39.0
+	golang.org/x/text v0.26.0
 )
+
+require golang.org/x/sys v0.33.0 // indirect
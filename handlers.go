@@ -1,25 +1,35 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"maps"
+	"math"
 	"net/http"
 	"os"
-	"sort"
+	"slices"
 	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
 	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/moderation"
 	"github.com/google/uuid"
 )
 
+const maxChirpLength = 140
+
 type chirp struct {
-	Body   string `json:"body"`
-	UserId string `json:"user_id"`
+	Body      string `json:"body"`
+	UserId    string `json:"user_id"`
+	InReplyTo string `json:"in_reply_to"`
+	QuoteOf   string `json:"quote_of"`
 }
 
 type userParam struct {
@@ -33,6 +43,23 @@ type chirpResponse struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	UserId    string    `json:"user_id"`
+	InReplyTo string    `json:"in_reply_to,omitempty"`
+	RootId    string    `json:"root_id,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+	Hidden    bool      `json:"hidden,omitempty"`
+
+	QuoteOf      string         `json:"quote_of,omitempty"`
+	QuotedChirp  *chirpResponse `json:"quoted_chirp,omitempty"`
+	LikeCount    int32          `json:"like_count"`
+	RechirpCount int32          `json:"rechirp_count"`
+	ReplyCount   int32          `json:"reply_count"`
+	Liked        *bool          `json:"liked,omitempty"` // only set when the request comes from a logged in user
+	Rechirped    *bool          `json:"rechirped,omitempty"`
+}
+
+type chirpsPage struct {
+	Chirps     []chirpResponse `json:"chirps"`
+	NextCursor string          `json:"next_cursor,omitempty"`
 }
 
 type userResponse struct {
@@ -43,13 +70,75 @@ type userResponse struct {
 	Token        string    `json:"token"`
 	RefreshToken string    `json:"refresh_token"`
 	IsChirpyRed  bool      `json:"is_chirpy_red"`
+	Role         string    `json:"role"`
+	// the email only changes once the new address is verified, until then it is pending
+	EmailVerified bool   `json:"email_verified"`
+	PendingEmail  string `json:"pending_email,omitempty"`
+	// only set when logging in
+	ExpiresAt             *time.Time `json:"expires_at,omitempty"`
+	RefreshTokenExpiresAt *time.Time `json:"refresh_token_expires_at,omitempty"`
+}
+
+func newUserResponse(user database.User) userResponse {
+	return userResponse{
+		Id:            user.ID.String(),
+		Email:         user.Email,
+		Created_at:    user.CreatedAt,
+		Updated_at:    user.UpdatedAt,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+}
+
+// loginOptions are sent along the credentials, both to POST /api/login and to POST /api/login/2fa
+type loginOptions struct {
+	Device           string `json:"device"` // a name the user gives to this session, like "work laptop"
+	Client           string `json:"client"` // the kind of client, its tokens get the lifetimes configured for it
+	ExpiresIn        int    `json:"expires_in_seconds"`
+	RefreshExpiresIn int    `json:"refresh_expires_in_seconds"`
+}
+
+// lifetimes are the ones requested, bounded by the token policy
+func (options loginOptions) lifetimes(policy auth.TokenPolicy) (auth.TokenLifetimes, error) {
+	return policy.Lifetimes(options.Client, auth.TokenLifetimes{
+		Access:  time.Duration(options.ExpiresIn) * time.Second,
+		Refresh: time.Duration(options.RefreshExpiresIn) * time.Second,
+	})
 }
 
-type polkaWebhookBody struct {
-	Event string `json:"event"`
-	Data  struct {
-		UserId string `json:"user_id"`
-	} `json:"data"`
+func newChirpResponse(chirp database.Chirp, viewerId uuid.NullUUID) chirpResponse {
+	response := chirpResponse{
+		Id:        chirp.ID.String(),
+		Body:      chirp.Body,
+		CreatedAt: chirp.CreatedAt,
+		UpdatedAt: chirp.UpdatedAt,
+		UserId:    chirp.UserID.String(),
+
+		LikeCount:    chirp.LikeCount,
+		RechirpCount: chirp.RechirpCount,
+		ReplyCount:   chirp.ReplyCount,
+	}
+	if chirp.InReplyTo.Valid {
+		response.InReplyTo = chirp.InReplyTo.UUID.String()
+	}
+	if chirp.RootID.Valid {
+		response.RootId = chirp.RootID.UUID.String()
+	}
+	if chirp.QuoteOf.Valid {
+		response.QuoteOf = chirp.QuoteOf.UUID.String()
+	}
+	if chirp.DeletedAt.Valid { // a tombstone keeps its place in the thread but nothing about its content or author
+		response.Body = ""
+		response.UserId = ""
+		response.QuoteOf = ""
+		response.Deleted = true
+	} else if !isChirpVisible(chirp, viewerId) { // held or hidden by moderation, only its author still sees it
+		response.Body = ""
+		response.QuoteOf = ""
+		response.Hidden = true
+	}
+	return response
 }
 
 func handleReadiness(w http.ResponseWriter, r *http.Request) {
@@ -59,11 +148,43 @@ func handleReadiness(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
 
+// checkVerifiedEmail writes the 403 when REQUIRE_VERIFIED_EMAIL keeps the author from posting or editing chirps
+func (cfg *ApiConfig) checkVerifiedEmail(w http.ResponseWriter, r *http.Request, authorId uuid.UUID) bool {
+	if !cfg.requireVerifiedEmail {
+		return true
+	}
+	author, err := cfg.dbQueries.GetUserById(r.Context(), authorId)
+	if err != nil {
+		log.Printf("error when getting the author of the chirp: %v", err)
+		w.WriteHeader(500)
+		return false
+	}
+	if !author.EmailVerifiedAt.Valid {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(403)
+		w.Write([]byte("verify your email before posting chirps"))
+		return false
+	}
+	return true
+}
+
 func handlePostChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, currentUserId uuid.UUID) {
 	header := w.Header()
-	parameters := unmarshalRequestBody[chirp](w, r)
+	parameters, err := unmarshalRequestBody[chirp](w, r)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(parameters.Body) == "" {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("the chirp can't be empty"))
+		return
+	}
 	parameters.UserId = currentUserId.String()
-	if len(parameters.Body) > 140 {
+	if !cfg.checkVerifiedEmail(w, r, currentUserId) {
+		return
+	}
+	if len(parameters.Body) > maxChirpLength {
 		w.WriteHeader(400)
 	} else {
 		header.Add("Content-Type", "application/json")
@@ -74,18 +195,14 @@ func handlePostChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, cur
 
 func handleProfane(w http.ResponseWriter, r *http.Request, reqBody chirp, cfg *ApiConfig) {
 	header := w.Header()
-	type returnCleanedBody struct {
-		CleanBody string `json:"cleaned_body"`
-	}
-	splittedBody := strings.Split(reqBody.Body, " ")
-	for i, word := range splittedBody {
-		uWord := strings.ToUpper(word)
-		if uWord == "FORNAX" || uWord == "SHARBERT" || uWord == "KERFUFFLE" {
-			splittedBody[i] = "****"
-		}
+	verdict := cfg.moderation.Moderate(reqBody.Body)
+	if verdict.Action == moderation.Reject {
+		rejectChirp(w, verdict)
+		return
 	}
-	cleanBody := returnCleanedBody{
-		CleanBody: strings.Join(splittedBody, " "),
+	status := chirpPublished
+	if verdict.Action == moderation.Hold {
+		status = chirpHeld
 	}
 	userId, err := uuid.Parse(reqBody.UserId)
 	if err != nil {
@@ -94,22 +211,90 @@ func handleProfane(w http.ResponseWriter, r *http.Request, reqBody chirp, cfg *A
 		w.Write([]byte("Provided user_id is not valid"))
 		return
 	}
-	createdChirp, err := cfg.dbQueries.CreateChirp(r.Context(), database.CreateChirpParams{
-		Body:   cleanBody.CleanBody,
-		UserID: userId,
+	inReplyTo := uuid.NullUUID{}
+	rootId := uuid.NullUUID{}
+	if reqBody.InReplyTo != "" {
+		parentId, err := uuid.Parse(reqBody.InReplyTo)
+		if err != nil {
+			w.WriteHeader(400)
+			header.Add("Content-Type", "text/plain")
+			w.Write([]byte("Provided in_reply_to is not valid"))
+			return
+		}
+		parent, err := cfg.dbQueries.GetChirpById(r.Context(), parentId)
+		if err != nil || !isChirpVisible(parent, uuid.NullUUID{}) {
+			w.WriteHeader(404)
+			header.Add("Content-Type", "text/plain")
+			w.Write([]byte("The chirp to reply to doesn't exist"))
+			return
+		}
+		inReplyTo = uuid.NullUUID{UUID: parent.ID, Valid: true}
+		rootId = parent.RootID
+		if !rootId.Valid { // the parent starts the conversation
+			rootId = inReplyTo
+		}
+	}
+	quoteOf := uuid.NullUUID{}
+	if reqBody.QuoteOf != "" {
+		quotedId, err := uuid.Parse(reqBody.QuoteOf)
+		if err != nil {
+			w.WriteHeader(400)
+			header.Add("Content-Type", "text/plain")
+			w.Write([]byte("Provided quote_of is not valid"))
+			return
+		}
+		quoted, err := cfg.dbQueries.GetChirpById(r.Context(), quotedId)
+		if err != nil || !isChirpVisible(quoted, uuid.NullUUID{}) {
+			w.WriteHeader(404)
+			header.Add("Content-Type", "text/plain")
+			w.Write([]byte("The chirp to quote doesn't exist"))
+			return
+		}
+		quoteOf = uuid.NullUUID{UUID: quoted.ID, Valid: true}
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the chirp transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	createdChirp, err := queries.CreateChirp(r.Context(), database.CreateChirpParams{
+		Body:      verdict.Text,
+		UserID:    userId,
+		InReplyTo: inReplyTo,
+		RootID:    rootId,
+		QuoteOf:   quoteOf,
+		Status:    status,
 	})
+	if err == nil {
+		// the subscribers see the body of a held chirp too, the status tells them it isn't published yet
+		err = publishEvent(r.Context(), queries, eventChirpCreated, chirpEvent{
+			chirpResponse: newChirpResponse(createdChirp, uuid.NullUUID{UUID: userId, Valid: true}),
+			Status:        status,
+		})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
+		log.Printf("error when inserting the chirp: %v", err)
 		w.WriteHeader(500)
 		header.Add("Content-Type", "text/plain")
 		w.Write([]byte("Server Unable to insert chirp in DB"))
 		return
 	}
-	jsonResBody, err := json.Marshal(&chirpResponse{
-		Id:        createdChirp.ID.String(),
-		Body:      createdChirp.Body,
-		CreatedAt: createdChirp.CreatedAt,
-		UpdatedAt: createdChirp.UpdatedAt,
-		UserId:    createdChirp.UserID.String(),
+	responses, err := cfg.chirpResponses(r.Context(), []database.Chirp{createdChirp}, uuid.NullUUID{UUID: userId, Valid: true})
+	if err != nil {
+		log.Printf("error when building the chirp response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonResBody, err := json.Marshal(moderatedChirpResponse{
+		chirpResponse: responses[0],
+		Moderation:    newModerationResponse(verdict),
 	})
 	if err != nil {
 		w.WriteHeader(500)
@@ -117,36 +302,62 @@ func handleProfane(w http.ResponseWriter, r *http.Request, reqBody chirp, cfg *A
 		w.Write([]byte("Server unable to parse response into JSON"))
 		return
 	}
-	w.WriteHeader(201)
+	if status == chirpHeld {
+		w.WriteHeader(202) // accepted, but only published once a moderator reviewed it
+	} else {
+		w.WriteHeader(201)
+	}
 	w.Write(jsonResBody)
 }
 
 func (cfg *ApiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 	header := w.Header()
-	reqBody := unmarshalRequestBody[userParam](w, r)
+	reqBody, err := unmarshalRequestBody[userParam](w, r)
+	if err != nil {
+		return
+	}
+	email, err := validateEmail(reqBody.Email)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
 	hashed_password, err := auth.HashPassword(reqBody.Password)
 	if err != nil {
 		log.Printf("Unable to hash password %v", err.Error())
 		w.WriteHeader(500)
 		return
 	}
-	response, err := cfg.dbQueries.CreateUser(r.Context(), database.CreateUserParams{
-		Email:          reqBody.Email,
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the signup transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	response, err := queries.CreateUser(r.Context(), database.CreateUserParams{
+		Email:          email,
 		HashedPassword: hashed_password,
 	}) // we pass the request's context so that our db request stops (or timeouts) with the cancellation of the http request if it does
+	if err == nil {
+		err = publishEvent(r.Context(), queries, eventUserCreated, newUserEvent(response))
+	}
+	if err == nil {
+		err = publishEvent(r.Context(), queries, eventEmailVerificationRequested, emailVerificationEvent{UserId: response.ID, Email: response.Email})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		w.WriteHeader(500)
 		header.Add("Content-Type", "text/plain")
 		w.Write([]byte(err.Error()))
 		return
 	}
-	uResponse := userResponse{
-		Id:          response.ID.String(),
-		Email:       response.Email,
-		Created_at:  response.CreatedAt,
-		Updated_at:  response.UpdatedAt,
-		IsChirpyRed: response.IsChirpyRed.Bool,
-	}
+	uResponse := newUserResponse(response)
 	jsonResponse, err := json.Marshal(&uResponse)
 	if err != nil {
 		w.WriteHeader(500)
@@ -160,41 +371,84 @@ func (cfg *ApiConfig) handleCreateUser(w http.ResponseWriter, r *http.Request) {
 }
 
 func (cfg *ApiConfig) handleListChirps(w http.ResponseWriter, r *http.Request) {
-	queryAuthorId := r.URL.Query().Get("author_id")
-	orderQuery := r.URL.Query().Get("order")
-	chirpList := []database.Chirp{}
-	var err error
+	query := r.URL.Query()
 	header := w.Header()
-	if queryAuthorId != "" {
-		authorId, err := uuid.Parse(queryAuthorId)
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[chirpCursor](query.Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	authorId := uuid.NullUUID{}
+	if queryAuthorId := query.Get("author_id"); queryAuthorId != "" {
+		authorId.UUID, err = uuid.Parse(queryAuthorId)
 		if err != nil {
 			w.WriteHeader(400)
 			return
 		}
-		chirpList, err = cfg.dbQueries.GetAllChirpsFromAuthor(r.Context(), authorId)
-	} else {
-		chirpList, err = cfg.dbQueries.GetAllChirps(r.Context())
+		authorId.Valid = true
+	}
+	afterCreatedAt := sql.NullTime{}
+	afterId := uuid.NullUUID{}
+	if cursor != nil {
+		afterCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		afterId = uuid.NullUUID{UUID: cursor.Id, Valid: true}
 	}
-	if orderQuery != "" && orderQuery == "desc" {
-		chirpList = orderChirpsDesc(chirpList)
+
+	var chirpList []database.Chirp
+	switch query.Get("order") {
+	case "", "asc":
+		chirpList, err = cfg.dbQueries.ListChirpsAsc(r.Context(), database.ListChirpsAscParams{
+			AuthorID:       authorId,
+			AfterCreatedAt: afterCreatedAt,
+			AfterID:        afterId,
+			RowLimit:       int32(limit + 1), // one more than asked so we know if there is a next page
+		})
+	case "desc":
+		chirpList, err = cfg.dbQueries.ListChirpsDesc(r.Context(), database.ListChirpsDescParams{
+			AuthorID:       authorId,
+			AfterCreatedAt: afterCreatedAt,
+			AfterID:        afterId,
+			RowLimit:       int32(limit + 1),
+		})
+	default:
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("order should be either 'asc' or 'desc'"))
+		return
 	}
 	if err != nil {
+		log.Printf("error when listing chirps: %v", err)
 		w.WriteHeader(500)
 		header.Add("Content-Type", "text/plain")
 		w.Write([]byte("server unable to list chirps"))
 		return
 	}
-	chirps := make([]chirpResponse, len(chirpList))
-	for i, chirp := range chirpList {
-		chirps[i] = chirpResponse{
-			Id:        chirp.ID.String(),
-			Body:      chirp.Body,
-			CreatedAt: chirp.CreatedAt,
-			UpdatedAt: chirp.UpdatedAt,
-			UserId:    chirp.UserID.String(),
+	page := chirpsPage{}
+	if len(chirpList) > limit {
+		chirpList = chirpList[:limit]
+		lastChirp := chirpList[len(chirpList)-1]
+		page.NextCursor, err = encodeCursor(chirpCursor{CreatedAt: lastChirp.CreatedAt, Id: lastChirp.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
 		}
 	}
-	jsonChirps, err := json.Marshal(&chirps)
+	page.Chirps, err = cfg.chirpResponses(r.Context(), chirpList, cfg.optionalUserId(r))
+	if err != nil {
+		log.Printf("error when building the chirps response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonPage, err := json.Marshal(&page)
 	if err != nil {
 		w.WriteHeader(500)
 		header.Add("Content-Type", "text/plain")
@@ -203,7 +457,7 @@ func (cfg *ApiConfig) handleListChirps(w http.ResponseWriter, r *http.Request) {
 	}
 	header.Add("Content-Type", "application/json")
 	w.WriteHeader(200)
-	w.Write(jsonChirps)
+	w.Write(jsonPage)
 }
 
 func (cfg *ApiConfig) handleGetChirpById(w http.ResponseWriter, r *http.Request) {
@@ -216,63 +470,229 @@ func (cfg *ApiConfig) handleGetChirpById(w http.ResponseWriter, r *http.Request)
 		w.Write([]byte("provided chirpId non-valid"))
 		return
 	}
+	viewerId := cfg.optionalUserId(r)
 	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpId)
-	if err != nil {
+	if err != nil || !isChirpVisible(chirp, viewerId) {
 		header.Add("Content-Type", "text/plain")
 		w.WriteHeader(404)
 		w.Write([]byte("provided chirpId non-valid"))
 		return
 	}
-	response, err := json.Marshal(&chirpResponse{
-		Id:        chirp.ID.String(),
-		Body:      chirp.Body,
-		CreatedAt: chirp.CreatedAt,
-		UpdatedAt: chirp.UpdatedAt,
-		UserId:    chirp.UserID.String(),
-	})
-	header.Add("Content-Type", "text/plain")
+	responses, err := cfg.chirpResponses(r.Context(), []database.Chirp{chirp}, viewerId)
+	if err != nil {
+		log.Printf("error when building the chirp response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response, err := json.Marshal(responses[0])
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
 	w.WriteHeader(200)
 	w.Write(response)
 }
 
 func (cfg *ApiConfig) handleLogin(w http.ResponseWriter, r *http.Request) {
 	type loginParams struct {
-		Email     string `json:"email"`
-		Password  string `json:"password"`
-		ExpiresIn int    `json:"expires_in_seconds"`
+		Email    string `json:"email"`
+		Password string `json:"password"`
+		loginOptions
 	}
-	reqBody := unmarshalRequestBody[loginParams](w, r)
-	queriedUser, err := cfg.dbQueries.GetUserByEmail(r.Context(), reqBody.Email)
-	err = auth.CheckPasswordHash(reqBody.Password, queriedUser.HashedPassword)
+	reqBody, err := unmarshalRequestBody[loginParams](w, r)
 	if err != nil {
-		log.Printf("error when authenticating the user: %v", err)
-		w.WriteHeader(401)
 		return
 	}
-	token, err := auth.MakeJWT(queriedUser.ID, cfg.secretKey, 1*time.Hour)
+	lifetimes, err := reqBody.lifetimes(cfg.tokenPolicy)
 	if err != nil {
-		log.Printf("error generating the JWT: %v", err)
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	queriedUser, refusal, err := cfg.authenticateUser(r.Context(), clientIp(r), loginCredentials{
+		email:    reqBody.Email,
+		password: reqBody.Password,
+	})
+	if err != nil {
+		log.Printf("error when authenticating the user: %v", err)
 		w.WriteHeader(500)
 		return
 	}
+	if refusal != nil && refusal.secondFactorRequired {
+		sendTwoFactorChallenge(w, cfg, queriedUser.ID)
+		return
+	}
+	if refusal != nil {
+		refuseLogin(w, refusal)
+		return
+	}
+	completeLogin(w, r, cfg, queriedUser, reqBody.loginOptions, lifetimes)
+}
+
+// loginCredentials are what a client gives to log in, the code being the one of the second factor
+type loginCredentials struct {
+	email    string
+	password string
+	code     string
+	// the password was checked by a previous step, the 2FA challenge proves it
+	passwordChecked bool
+}
+
+// loginRefusal tells why a login was refused, the message is meant for the user
+type loginRefusal struct {
+	status     int
+	message    string
+	blockedFor time.Duration // set when the throttle refused the attempt
+	// the password was right but the second factor is needed, it can be asked for in another step
+	secondFactorRequired bool
+}
 
-	refreshToken, err := createRefreshToken(queriedUser.ID, r, cfg)
+// authenticateUser checks the password and the second factor of a login under the throttle of the account and the
+// IP, for POST /api/login, POST /api/login/2fa and the OAuth consent page. The codes are throttled like the passwords,
+// the 2FA challenge alone would leave minutes to guess them, and only a passed second factor forgets the failures of
+// the account. The user is given back with secondFactorRequired when the code is missing
+func (cfg *ApiConfig) authenticateUser(ctx context.Context, ip string, credentials loginCredentials) (database.User, *loginRefusal, error) {
+	attempt, blockedFor, err := cfg.beginLoginAttempt(ctx, credentials.email, ip)
+	if err != nil {
+		return database.User{}, nil, err
+	}
+	if blockedFor > 0 {
+		message := fmt.Sprintf("Too many failed logins, try again in %d seconds.", int(math.Ceil(blockedFor.Seconds())))
+		return database.User{}, &loginRefusal{status: 429, message: message, blockedFor: blockedFor}, nil
+	}
+	defer attempt.end(ctx)
+	user, err := cfg.dbQueries.GetUserByEmail(ctx, credentials.email)
+	accountExists := err == nil
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return database.User{}, nil, err
+	}
+	if !credentials.passwordChecked {
+		// an unknown email costs a password comparison too, so it answers like a wrong password and as slowly
+		hashedPassword := user.HashedPassword
+		if !accountExists {
+			hashedPassword = auth.DummyPasswordHash()
+		}
+		if auth.CheckPasswordHash(credentials.password, hashedPassword) != nil || !accountExists {
+			return database.User{}, &loginRefusal{status: 401, message: "Wrong email or password."}, attempt.settle(ctx, loginFailed, accountExists)
+		}
+		cfg.upgradePasswordHash(ctx, user, credentials.password)
+	} else if !accountExists {
+		return database.User{}, &loginRefusal{status: 401, message: "Wrong email or password."}, nil
+	}
+	if user.SuspendedAt.Valid {
+		return database.User{}, &loginRefusal{status: 403, message: "This account is suspended."}, attempt.settle(ctx, loginSucceeded, true)
+	}
+	twoFactorEnabled, err := cfg.isTwoFactorEnabled(ctx, user.ID)
+	if err != nil {
+		return database.User{}, nil, err
+	}
+	if !twoFactorEnabled {
+		return user, nil, attempt.settle(ctx, loginSucceeded, true)
+	}
+	code := strings.TrimSpace(credentials.code)
+	verified := false
+	if code != "" {
+		totp, err := cfg.dbQueries.GetUserTotp(ctx, user.ID)
+		if err != nil {
+			return database.User{}, nil, err
+		}
+		verified, err = verifySecondFactor(ctx, cfg.dbQueries, totp, code)
+		if err != nil {
+			return database.User{}, nil, err
+		}
+	}
+	outcome := secondFactorOutcome(code, verified)
+	switch outcome {
+	case loginAbandoned:
+		refusal := &loginRefusal{status: 401, message: "Enter the code of your authenticator app, or a recovery code.", secondFactorRequired: !credentials.passwordChecked}
+		return user, refusal, attempt.settle(ctx, outcome, true)
+	case loginFailed:
+		return database.User{}, &loginRefusal{status: 401, message: "Wrong two-factor code."}, attempt.settle(ctx, outcome, true)
+	}
+	return user, nil, attempt.settle(ctx, outcome, true)
+}
+
+// secondFactorOutcome is the outcome of an attempt giving the right password of an account with 2FA. A missing code
+// is neither a failure nor a success: it would let whoever knows the password forget the failures of the wrong codes
+func secondFactorOutcome(code string, verified bool) loginOutcome {
+	if code == "" {
+		return loginAbandoned
+	}
+	if verified {
+		return loginSucceeded
+	}
+	return loginFailed
+}
+
+// confirmPassword checks the current password of a logged in user under the login throttle. The right password only
+// gives the attempt back, it doesn't prove the second factor so the failures of the account stay
+func (cfg *ApiConfig) confirmPassword(ctx context.Context, ip string, user database.User, password string) (*loginRefusal, error) {
+	attempt, blockedFor, err := cfg.beginLoginAttempt(ctx, user.Email, ip)
+	if err != nil {
+		return nil, err
+	}
+	if blockedFor > 0 {
+		return &loginRefusal{status: 429, blockedFor: blockedFor}, nil
+	}
+	defer attempt.end(ctx)
+	if auth.CheckPasswordHash(password, user.HashedPassword) != nil {
+		return &loginRefusal{status: 401, message: "Wrong current password."}, attempt.settle(ctx, loginFailed, true)
+	}
+	return nil, attempt.settle(ctx, loginAbandoned, true)
+}
+
+func refuseLogin(w http.ResponseWriter, refusal *loginRefusal) {
+	if refusal.blockedFor > 0 {
+		tooManyLoginAttempts(w, refusal.blockedFor)
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(refusal.status)
+	w.Write([]byte(refusal.message))
+}
+
+// upgradePasswordHash hashes the password again when its hash is a bcrypt one or uses outdated parameters. The login
+// goes on when it fails, the hash will be upgraded at the next one
+func (cfg *ApiConfig) upgradePasswordHash(ctx context.Context, user database.User, password string) {
+	if !auth.NeedsRehash(user.HashedPassword) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err == nil {
+		err = cfg.dbQueries.UpdateUserPassword(ctx, database.UpdateUserPasswordParams{ID: user.ID, HashedPassword: hashedPassword})
+	}
+	if err != nil {
+		log.Printf("error when upgrading the password hash of user %v: %v", user.ID, err)
+	}
+}
+
+// completeLogin opens a session for a user who passed every factor and answers with their tokens
+func completeLogin(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, queriedUser database.User, options loginOptions, lifetimes auth.TokenLifetimes) {
+	now := time.Now()
+	expiresAt := now.Add(lifetimes.Access)
+	refreshTokenExpiresAt := now.Add(lifetimes.Refresh)
+	refreshToken, sessionId, err := createRefreshToken(queriedUser.ID, options, lifetimes, refreshTokenExpiresAt, r, cfg)
 	if err != nil {
 		log.Printf("error when creating refresh token: %v", err)
 		w.WriteHeader(500)
 		w.Write([]byte("error when creating refresh token"))
 		return
 	}
+	token, err := auth.MakeJWT(queriedUser.ID, cfg.keyring, lifetimes.Access, auth.WithRole(auth.Role(queriedUser.Role)), auth.WithSessionId(sessionId))
+	if err != nil {
+		log.Printf("error generating the JWT: %v", err)
+		w.WriteHeader(500)
+		return
+	}
 
-	response, err := json.Marshal(userResponse{
-		Id:           queriedUser.ID.String(),
-		Email:        queriedUser.Email,
-		Created_at:   queriedUser.CreatedAt,
-		Updated_at:   queriedUser.UpdatedAt,
-		IsChirpyRed:  queriedUser.IsChirpyRed.Bool,
-		Token:        token,
-		RefreshToken: refreshToken,
-	})
+	loginResponse := newUserResponse(queriedUser)
+	loginResponse.Token = token
+	loginResponse.RefreshToken = refreshToken
+	loginResponse.ExpiresAt = &expiresAt
+	loginResponse.RefreshTokenExpiresAt = &refreshTokenExpiresAt
+	response, err := json.Marshal(loginResponse)
 	if err != nil {
 		log.Printf("error parsing the response to JSON: %v", err)
 		w.WriteHeader(401)
@@ -290,7 +710,8 @@ func (cfg *ApiConfig) handlerMetrics(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte(numHits))
 }
 
-func (cfg *ApiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
+// even admins can only wipe the users of a dev platform
+func handlerReset(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
 	platform := os.Getenv("PLATFORM")
 	if platform != "dev" {
 		w.WriteHeader(403)
@@ -306,24 +727,37 @@ func (cfg *ApiConfig) handlerReset(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 }
 
-func (cfg *ApiConfig) handlerAdminMetrics(w http.ResponseWriter, r *http.Request) {
+func handlerAdminMetrics(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
 	header := w.Header()
+	counts := cfg.events.counts.snapshot()
+	var eventLines strings.Builder
+	for _, eventType := range slices.Sorted(maps.Keys(counts)) {
+		fmt.Fprintf(&eventLines, "\t\t\t\t<li>%v: %d</li>\n", eventType, counts[eventType])
+	}
 	result := fmt.Sprintf(`
 		<html>
 		<body>
 			<h1>Welcome, Chirpy Admin</h1>
 			<p>Chirpy has been visited %d times!</p>
+			<p>Events dispatched by this instance since it started:</p>
+			<ul>
+%v			</ul>
 		</body>
 		</html>
-	`, cfg.fileserverHits.Load())
+	`, cfg.fileserverHits.Load(), eventLines.String())
 	header.Add("Content-Type", "text/html")
 	w.WriteHeader(200)
 	w.Write([]byte(result))
 }
 
+// every refresh rotates the refresh token: the presented one can't be used again and a new one of the same family
+// is returned. A rotated token coming back means two parties hold the family, so all of it is revoked
 func (cfg *ApiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request) {
 	type refreshTokenResponse struct {
-		Token string `json:"token"`
+		Token                 string    `json:"token"`
+		RefreshToken          string    `json:"refresh_token"`
+		ExpiresAt             time.Time `json:"expires_at"`
+		RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
 	}
 	header := w.Header()
 	bearerToken, err := auth.GetBearerToken(r.Header)
@@ -332,32 +766,113 @@ func (cfg *ApiConfig) handlerRefreshToken(w http.ResponseWriter, r *http.Request
 		w.WriteHeader(401)
 		return
 	}
-	queriedRefreshToken, err := cfg.dbQueries.GetRefreshTokenById(r.Context(), bearerToken)
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the refresh transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// locking the row makes two concurrent refreshes with the same token look like a reuse, only one of them rotates it
+	queriedRefreshToken, err := queries.GetRefreshTokenByHashForUpdate(r.Context(), auth.HashRefreshToken(bearerToken))
 	if err != nil {
 		log.Printf("error when querrying the refresh token %v", err)
 		w.WriteHeader(401)
 		return
 	}
+	// the tokens of an OAuth client are refreshed on /oauth/token, here they would give an unscoped access token
+	if queriedRefreshToken.OauthClientID.Valid {
+		log.Printf("the refresh token of the OAuth client %v can't be used on /api/refresh", queriedRefreshToken.OauthClientID.UUID)
+		w.WriteHeader(401)
+		return
+	}
 	if queriedRefreshToken.RevokedAt.Valid {
 		log.Printf("the token has already been revoked")
 		w.WriteHeader(401)
 		return
 	}
-	newToken, err := auth.MakeJWT(queriedRefreshToken.UserID, cfg.secretKey, 1*time.Hour)
+	if queriedRefreshToken.RotatedAt.Valid {
+		log.Printf("refresh token reuse detected, revoking the family %v of user %v", queriedRefreshToken.FamilyID, queriedRefreshToken.UserID)
+		err = queries.RevokeRefreshTokenFamily(r.Context(), queriedRefreshToken.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			log.Printf("error when revoking the refresh token family: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		w.WriteHeader(401)
+		return
+	}
+	if time.Now().After(queriedRefreshToken.ExpiresAt) {
+		log.Printf("the token has expired")
+		w.WriteHeader(401)
+		return
+	}
+	tokenUser, err := queries.GetUserById(r.Context(), queriedRefreshToken.UserID)
+	if err != nil || tokenUser.SuspendedAt.Valid {
+		log.Printf("the user of the refresh token can't log in: %v", err)
+		w.WriteHeader(401)
+		return
+	}
+	err = queries.RotateRefreshToken(r.Context(), queriedRefreshToken.TokenHash)
+	if err != nil {
+		log.Printf("error when rotating the refresh token: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	// the new token keeps the expiry, the device label and the client of the family, rotating doesn't make a session last longer
+	newRefreshToken, err := issueRefreshToken(r.Context(), queries, r, database.CreateRefreshTokenParams{
+		UserID:                tokenUser.ID,
+		ExpiresAt:             queriedRefreshToken.ExpiresAt,
+		FamilyID:              queriedRefreshToken.FamilyID,
+		DeviceLabel:           queriedRefreshToken.DeviceLabel,
+		Client:                queriedRefreshToken.Client,
+		AccessTokenTtlSeconds: queriedRefreshToken.AccessTokenTtlSeconds,
+	})
+	if err != nil {
+		log.Printf("error when creating the rotated refresh token: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	// the lifetime is computed again so a lowered maximum also applies to the sessions opened before
+	requestedLifetimes := auth.TokenLifetimes{Access: time.Duration(queriedRefreshToken.AccessTokenTtlSeconds) * time.Second}
+	lifetimes, err := cfg.tokenPolicy.Lifetimes(queriedRefreshToken.Client, requestedLifetimes)
+	if err != nil {
+		log.Printf("the client of the session %v is not configured anymore, using the defaults: %v", queriedRefreshToken.FamilyID, err)
+		lifetimes, _ = cfg.tokenPolicy.Lifetimes("", requestedLifetimes)
+	}
+	expiresAt := time.Now().Add(lifetimes.Access)
+	// the role is read again so the new token follows role changes made since the login
+	newToken, err := auth.MakeJWT(tokenUser.ID, cfg.keyring, lifetimes.Access, auth.WithRole(auth.Role(tokenUser.Role)), auth.WithSessionId(queriedRefreshToken.FamilyID))
 	if err != nil {
 		log.Printf("error when creating a token from the refresh token: %v", err)
 		w.WriteHeader(500)
 		return
 	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error when committing the refresh token rotation: %v", err)
+		w.WriteHeader(500)
+		return
+	}
 
-	jsonResponse, err := json.Marshal(&refreshTokenResponse{Token: newToken})
+	jsonResponse, err := json.Marshal(&refreshTokenResponse{
+		Token:                 newToken,
+		RefreshToken:          newRefreshToken,
+		ExpiresAt:             expiresAt,
+		RefreshTokenExpiresAt: queriedRefreshToken.ExpiresAt,
+	})
 	if err != nil {
 		log.Printf("error when parsing the new token to JSON: %v", err)
 		w.WriteHeader(500)
 		return
 	}
-	w.WriteHeader(200)
 	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
 	w.Write(jsonResponse)
 }
 
@@ -369,7 +884,7 @@ func (cfg *ApiConfig) handlerRevokeRefreshToken(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	err = cfg.dbQueries.RevokeToken(r.Context(), providedToken)
+	err = cfg.dbQueries.RevokeToken(r.Context(), auth.HashRefreshToken(providedToken))
 	if err != nil {
 		log.Printf("error when revoking token: %v", err)
 		w.WriteHeader(401)
@@ -378,22 +893,44 @@ func (cfg *ApiConfig) handlerRevokeRefreshToken(w http.ResponseWriter, r *http.R
 	w.WriteHeader(204)
 }
 
+// handlerEditUser changes the credentials of the user, so only a logged in user can call it and the current password
+// is asked again: a stolen access token alone can't take the account over
 func handlerEditUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type editUserParams struct {
+		Email           string `json:"email"`
+		Password        string `json:"password"`
+		CurrentPassword string `json:"current_password"`
+	}
 	type editUserResponse struct {
-		Id          string    `json:"id"`
-		Email       string    `json:"email"`
-		CreatedAt   time.Time `json:"created_at"`
-		UpdatedAt   time.Time `json:"updated_at"`
-		IsChirpyRed bool      `json:"is_chirpy_red"`
+		Id            string    `json:"id"`
+		Email         string    `json:"email"`
+		CreatedAt     time.Time `json:"created_at"`
+		UpdatedAt     time.Time `json:"updated_at"`
+		IsChirpyRed   bool      `json:"is_chirpy_red"`
+		EmailVerified bool      `json:"email_verified"`
+		PendingEmail  string    `json:"pending_email,omitempty"`
 	}
 
-	parameters := unmarshalRequestBody[userParam](w, r)
+	parameters, err := unmarshalRequestBody[editUserParams](w, r)
+	if err != nil {
+		return
+	}
 	header := w.Header()
 	logedInUser, err := cfg.dbQueries.GetUserById(r.Context(), curUserId)
 	if err != nil {
 		w.WriteHeader(401)
 		return
 	}
+	refusal, err := cfg.confirmPassword(r.Context(), clientIp(r), logedInUser, parameters.CurrentPassword)
+	if err != nil {
+		log.Printf("error when confirming the password of the user: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if refusal != nil {
+		refuseLogin(w, refusal)
+		return
+	}
 	/*
 			WRONG check
 		if logedInUser.Email != parameters.Email {
@@ -404,29 +941,84 @@ func handlerEditUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, cur
 			return
 		}
 	*/
-	hashedPassword, err := auth.HashPassword(parameters.Password)
+	// a new email is only pending until the link sent to it is opened, the account keeps its address meanwhile
+	var pendingEmail string
+	if parameters.Email != "" && parameters.Email != logedInUser.Email {
+		pendingEmail, err = validateEmail(parameters.Email)
+		if err != nil {
+			header.Add("Content-Type", "text/plain")
+			w.WriteHeader(400)
+			w.Write([]byte(err.Error()))
+			return
+		}
+	}
+	if parameters.Password == "" && pendingEmail == "" {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a new email or a new password is expected"))
+		return
+	}
+	// the password is only changed when a new one is given, an email change alone keeps it
+	passwordChanged := parameters.Password != "" && auth.CheckPasswordHash(parameters.Password, logedInUser.HashedPassword) != nil
+	var hashedPassword string
+	if passwordChanged {
+		hashedPassword, err = auth.HashPassword(parameters.Password)
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
+		log.Printf("error when starting the user update transaction: %v", err)
 		w.WriteHeader(500)
 		return
 	}
-	editedUser, err := cfg.dbQueries.UpdateUser(r.Context(), database.UpdateUserParams{
-		ID:             logedInUser.ID,
-		Email:          parameters.Email,
-		HashedPassword: hashedPassword,
-	})
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	if passwordChanged {
+		err = queries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{ID: logedInUser.ID, HashedPassword: hashedPassword})
+	}
+	var editedUser database.User
+	if err == nil {
+		editedUser, err = queries.UpdateUser(r.Context(), logedInUser.ID)
+	}
 	if err != nil {
 		w.WriteHeader(401)
-		log.Printf("user to update id: %v")
+		log.Printf("user to update id: %v", logedInUser.ID)
 		log.Printf("error when updating the user %v", err)
 		return
 	}
+	if passwordChanged { // whoever knew the old password is logged out, only the session making the change stays
+		_, err = queries.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+			UserID:   logedInUser.ID,
+			FamilyID: sessionIdFromContext(r.Context()).UUID,
+		})
+	}
+	if err == nil {
+		err = publishEvent(r.Context(), queries, eventUserUpdated, newUserEvent(editedUser))
+	}
+	if err == nil && pendingEmail != "" {
+		err = publishEvent(r.Context(), queries, eventEmailVerificationRequested, emailVerificationEvent{UserId: editedUser.ID, Email: pendingEmail})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("error when saving the user update: %v", err)
+		w.WriteHeader(500)
+		return
+	}
 
 	jsonUser, err := json.Marshal(&editUserResponse{
-		Id:          editedUser.ID.String(),
-		Email:       editedUser.Email,
-		CreatedAt:   editedUser.CreatedAt,
-		UpdatedAt:   editedUser.UpdatedAt,
-		IsChirpyRed: editedUser.IsChirpyRed.Bool,
+		Id:            editedUser.ID.String(),
+		Email:         editedUser.Email,
+		CreatedAt:     editedUser.CreatedAt,
+		UpdatedAt:     editedUser.UpdatedAt,
+		IsChirpyRed:   editedUser.IsChirpyRed.Bool,
+		EmailVerified: editedUser.EmailVerifiedAt.Valid,
+		PendingEmail:  pendingEmail,
 	})
 	if err != nil {
 		w.WriteHeader(500)
@@ -438,40 +1030,6 @@ func handlerEditUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, cur
 	w.Write(jsonUser)
 }
 
-
-func (cfg *ApiConfig) handlerUpgradeUserToChirpRed(w http.ResponseWriter, r *http.Request) {
-	parameters := unmarshalRequestBody[polkaWebhookBody](w, r)
-	providedApiKey, err := auth.GetApiKey(r.Header)
-	if err != nil {
-		w.WriteHeader(401)
-		return
-	}
-	if providedApiKey != cfg.polkaKey {
-		w.WriteHeader(401)
-		return
-	}
-	if parameters.Event == "user.upgraded" {
-		userId, err := uuid.Parse(parameters.Data.UserId)
-		if err != nil {
-			w.WriteHeader(404)
-			return
-		}
-		err = cfg.dbQueries.UpgradeToChirpyRed(r.Context(), userId)
-		if err != nil {
-			log.Printf("error when upgrading user to chirpy red : %v", err)
-			w.WriteHeader(404)
-			return
-		}
-		w.WriteHeader(204)
-		return
-	}
-	w.WriteHeader(204)
-}
-
-func orderChirpsDesc(chirps []database.Chirp) []database.Chirp {
-	sort.Slice(chirps, func(i, j int) bool { return chirps[i].CreatedAt.After(chirps[j].CreatedAt) })
-	return chirps // already mutated but still...
-}
 func handlerDeleteChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
 	chirpId := r.PathValue("chirpId")
 	if chirpId == "" {
@@ -485,8 +1043,18 @@ func handlerDeleteChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig,
 		return
 	}
 
-	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpUuid)
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
 	if err != nil {
+		w.WriteHeader(500)
+		log.Printf("error when starting the chirp deletion transaction: %v", err)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// a new reply updates the reply count of its parent, the lock makes it wait until the chirp is deleted or tombstoned
+	chirp, err := queries.GetChirpByIdForUpdate(r.Context(), chirpUuid)
+	if err != nil || chirp.DeletedAt.Valid {
 		log.Printf("error when getting the chirp by Id: %v", err)
 		w.WriteHeader(404)
 		return
@@ -497,7 +1065,23 @@ func handlerDeleteChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig,
 		return
 	}
 
-	err = cfg.dbQueries.DeleteChirpWithId(r.Context(), chirp.ID)
+	hasReplies, err := queries.ChirpHasReplies(r.Context(), uuid.NullUUID{UUID: chirp.ID, Valid: true})
+	if err != nil {
+		w.WriteHeader(500)
+		log.Printf("error when checking the chirp replies: %v", err)
+		return
+	}
+	if hasReplies { // we keep a tombstone so the replies still belong to their thread
+		err = queries.TombstoneChirp(r.Context(), chirp.ID)
+	} else {
+		err = queries.DeleteChirpWithId(r.Context(), chirp.ID)
+	}
+	if err == nil {
+		err = publishEvent(r.Context(), queries, eventChirpDeleted, chirpDeletedEvent{Id: chirp.ID, UserId: chirp.UserID, Tombstoned: hasReplies, Status: chirp.Status})
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
 	if err != nil {
 		w.WriteHeader(500)
 		log.Printf("error when deleting the chirp: %v", err)
@@ -505,34 +1089,56 @@ func handlerDeleteChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig,
 	}
 
 	w.WriteHeader(204)
-  
 }
 
-func createRefreshToken(userId uuid.UUID, r *http.Request, cfg *ApiConfig) (string, error) {
+// createRefreshToken starts a new token family when the user logs in, the family id is the id of the session
+func createRefreshToken(userId uuid.UUID, options loginOptions, lifetimes auth.TokenLifetimes, expiresAt time.Time, r *http.Request, cfg *ApiConfig) (string, uuid.UUID, error) {
+	sessionId := uuid.New()
+	// only an access token lifetime asked by the client is kept, otherwise the refreshes follow the configured default
+	var accessTokenTtl int32
+	if options.ExpiresIn > 0 {
+		accessTokenTtl = int32(lifetimes.Access / time.Second)
+	}
+	refreshToken, err := issueRefreshToken(r.Context(), cfg.dbQueries, r, database.CreateRefreshTokenParams{
+		UserID:                userId,
+		ExpiresAt:             expiresAt,
+		FamilyID:              sessionId,
+		DeviceLabel:           options.Device,
+		Client:                options.Client,
+		AccessTokenTtlSeconds: accessTokenTtl,
+	})
+	return refreshToken, sessionId, err
+}
+
+// issueRefreshToken returns the token to send to the client, only its hash is saved along with where it was requested from
+func issueRefreshToken(ctx context.Context, queries *database.Queries, r *http.Request, token database.CreateRefreshTokenParams) (string, error) {
 	newRefreshToken, err := auth.MakeRefreshToken()
 	if err != nil {
 		return "", err
 	}
-	_, err = cfg.dbQueries.CreateRefreshToken(r.Context(), database.CreateRefreshTokenParams{
-		Token:     newRefreshToken,
-		ExpiresAt: time.Now().Add((60 * 24) * time.Hour),
-		UserID:    userId,
-	})
+	token.TokenHash = auth.HashRefreshToken(newRefreshToken)
+	token.UserAgent = r.UserAgent()
+	token.IpAddress = clientIp(r)
+	_, err = queries.CreateRefreshToken(ctx, token)
 	if err != nil {
 		return "", err
 	}
 	return newRefreshToken, nil
 }
 
-func unmarshalRequestBody[T any](w http.ResponseWriter, r *http.Request) *T { // using generics is the way to go for functions to handle many types
+// unmarshalRequestBody writes the 400 itself when the body can't be read, the handler only has to stop on the error
+func unmarshalRequestBody[T any](w http.ResponseWriter, r *http.Request) (*T, error) { // using generics is the way to go for functions to handle many types
 	var unmarshalledReqBody T
 	reqBody, err := io.ReadAll(r.Body)
-	err = json.Unmarshal(reqBody, &unmarshalledReqBody)
-	header := w.Header()
+	if err == nil {
+		err = json.Unmarshal(reqBody, &unmarshalledReqBody)
+	}
 	if err != nil {
+		header := w.Header()
 		header.Add("Content-Type", "text/plain")
 		w.WriteHeader(400)
 		w.Write([]byte("Server unable to read request body"))
+		return nil, err
 	}
-	return &unmarshalledReqBody
+	return &unmarshalledReqBody, nil
 }
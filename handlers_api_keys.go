@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	apiKeyDefaultTTL     = 90 * 24 * time.Hour
+	apiKeyMaxTTL         = 365 * 24 * time.Hour
+	maxApiKeyNameLength  = 100
+	apiKeyUsagePrecision = time.Minute // last_used_at is only written once a minute for a busy bot
+)
+
+type apiKeyResponse struct {
+	Id         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	Key        string     `json:"key,omitempty"` // only given when the key is created
+}
+
+func newApiKeyResponse(apiKey database.ApiKey) apiKeyResponse {
+	response := apiKeyResponse{
+		Id:        apiKey.ID,
+		Name:      apiKey.Name,
+		Prefix:    apiKey.Prefix,
+		Scopes:    apiKey.Scopes,
+		CreatedAt: apiKey.CreatedAt,
+		ExpiresAt: apiKey.ExpiresAt,
+	}
+	if apiKey.LastUsedAt.Valid {
+		response.LastUsedAt = &apiKey.LastUsedAt.Time
+	}
+	return response
+}
+
+// findApiKey gives the active key of the "Authorization: ApiKey <key>" header and records that it was used
+func (cfg *ApiConfig) findApiKey(ctx context.Context, header http.Header) (database.ApiKey, error) {
+	receivedKey, err := auth.GetApiKey(header)
+	if err != nil {
+		return database.ApiKey{}, err
+	}
+	apiKey, err := cfg.dbQueries.GetActiveApiKeyByHash(ctx, auth.HashApiKey(receivedKey))
+	if err != nil {
+		return database.ApiKey{}, err
+	}
+	if !apiKey.LastUsedAt.Valid || time.Since(apiKey.LastUsedAt.Time) > apiKeyUsagePrecision {
+		err = cfg.dbQueries.TouchApiKey(ctx, apiKey.ID)
+		if err != nil {
+			log.Printf("error when recording the use of the API key %v: %v", apiKey.ID, err)
+		}
+	}
+	return apiKey, nil
+}
+
+func handlerCreateApiKey(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type createApiKeyParams struct {
+		Name      string   `json:"name"`
+		Scopes    []string `json:"scopes"`
+		ExpiresIn int      `json:"expires_in_seconds"` // 90 days by default, a year at most
+	}
+	header := w.Header()
+	parameters, err := unmarshalRequestBody[createApiKeyParams](w, r)
+	if err != nil {
+		return
+	}
+	name := strings.TrimSpace(parameters.Name)
+	if name == "" || len(name) > maxApiKeyNameLength {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a name of at most 100 characters is expected"))
+		return
+	}
+	scopes, err := auth.ParseScopes(parameters.Scopes)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	ttl := apiKeyDefaultTTL
+	if parameters.ExpiresIn > 0 {
+		ttl = min(time.Duration(parameters.ExpiresIn)*time.Second, apiKeyMaxTTL)
+	}
+	key, prefix, err := auth.MakeApiKey()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	grantedScopes := make([]string, len(scopes))
+	for i, scope := range scopes {
+		grantedScopes[i] = string(scope)
+	}
+	apiKey, err := cfg.dbQueries.CreateApiKey(r.Context(), database.CreateApiKeyParams{
+		UserID:    curUserId,
+		Name:      name,
+		KeyHash:   auth.HashApiKey(key),
+		Prefix:    prefix,
+		Scopes:    grantedScopes,
+		ExpiresAt: time.Now().Add(ttl),
+	})
+	if err != nil {
+		log.Printf("error when creating the API key: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := newApiKeyResponse(apiKey)
+	response.Key = key
+	jsonApiKey, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(jsonApiKey)
+}
+
+func handlerListApiKeys(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	apiKeys, err := cfg.dbQueries.ListUserApiKeys(r.Context(), curUserId)
+	if err != nil {
+		log.Printf("error when listing the API keys: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := make([]apiKeyResponse, len(apiKeys))
+	for i, apiKey := range apiKeys {
+		response[i] = newApiKeyResponse(apiKey)
+	}
+	jsonApiKeys, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonApiKeys)
+}
+
+func handlerRevokeApiKey(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	keyId, err := uuid.Parse(r.PathValue("keyId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	revoked, err := cfg.dbQueries.RevokeApiKey(r.Context(), database.RevokeApiKeyParams{ID: keyId, UserID: curUserId})
+	if err != nil {
+		log.Printf("error when revoking the API key: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if revoked == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
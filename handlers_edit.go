@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/moderation"
+	"github.com/google/uuid"
+)
+
+type chirpRevisionResponse struct {
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"created_at"`
+	ReplacedAt time.Time `json:"replaced_at"`
+}
+
+type chirpRevisionsResponse struct {
+	ChirpId   string                  `json:"chirp_id"`
+	Revisions []chirpRevisionResponse `json:"revisions"`
+}
+
+func handlerEditChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	chirpId, err := uuid.Parse(r.PathValue("chirpId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	parameters, err := unmarshalRequestBody[chirp](w, r)
+	if err != nil {
+		return
+	}
+	if strings.TrimSpace(parameters.Body) == "" {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("the chirp can't be empty"))
+		return
+	}
+	if len(parameters.Body) > maxChirpLength {
+		w.WriteHeader(400)
+		return
+	}
+	if !cfg.checkVerifiedEmail(w, r, curUserId) {
+		return
+	}
+	verdict := cfg.moderation.Moderate(parameters.Body)
+	if verdict.Action == moderation.Reject {
+		rejectChirp(w, verdict)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the edit transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	// the row stays locked until the commit, so two concurrent edits can't both save the same previous body
+	currentChirp, err := queries.GetChirpByIdForUpdate(r.Context(), chirpId)
+	if err != nil || currentChirp.DeletedAt.Valid {
+		w.WriteHeader(404)
+		return
+	}
+	if currentChirp.UserID != curUserId || currentChirp.Status == chirpHidden {
+		w.WriteHeader(403)
+		return
+	}
+	if time.Since(currentChirp.CreatedAt) > cfg.chirpEditWindow {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(403)
+		w.Write([]byte("the chirp can't be edited anymore"))
+		return
+	}
+	status := currentChirp.Status // an edit can put a chirp on hold, but only a moderator publishes a held chirp
+	if verdict.Action == moderation.Hold {
+		status = chirpHeld
+	}
+	editedChirp := currentChirp
+	if verdict.Text != currentChirp.Body || status != currentChirp.Status {
+		err = queries.CreateChirpRevision(r.Context(), database.CreateChirpRevisionParams{
+			ChirpID:   currentChirp.ID,
+			Body:      currentChirp.Body,
+			CreatedAt: currentChirp.UpdatedAt,
+		})
+		if err != nil {
+			log.Printf("error when saving the chirp revision: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		editedChirp, err = queries.UpdateChirpBody(r.Context(), database.UpdateChirpBodyParams{
+			ID:     currentChirp.ID,
+			Body:   verdict.Text,
+			Status: status,
+		})
+		if err == nil {
+			// like for chirp.created, the status tells the subscribers when the edit put the chirp on hold
+			err = publishEvent(r.Context(), queries, eventChirpUpdated, chirpEvent{
+				chirpResponse: newChirpResponse(editedChirp, uuid.NullUUID{UUID: curUserId, Valid: true}),
+				Status:        editedChirp.Status,
+			})
+		}
+		if err != nil {
+			log.Printf("error when updating the chirp: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error when committing the chirp edit: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	responses, err := cfg.chirpResponses(r.Context(), []database.Chirp{editedChirp}, uuid.NullUUID{UUID: curUserId, Valid: true})
+	if err != nil {
+		log.Printf("error when building the chirp response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonChirp, err := json.Marshal(moderatedChirpResponse{
+		chirpResponse: responses[0],
+		Moderation:    newModerationResponse(verdict),
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonChirp)
+}
+
+func (cfg *ApiConfig) handleListChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpId, err := uuid.Parse(r.PathValue("chirpId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpId)
+	if err != nil || !isChirpVisible(chirp, cfg.optionalUserId(r)) {
+		w.WriteHeader(404)
+		return
+	}
+	revisions, err := cfg.dbQueries.ListChirpRevisions(r.Context(), chirp.ID)
+	if err != nil {
+		log.Printf("error when listing the chirp revisions: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := chirpRevisionsResponse{
+		ChirpId:   chirp.ID.String(),
+		Revisions: make([]chirpRevisionResponse, len(revisions)),
+	}
+	for i, revision := range revisions {
+		response.Revisions[i] = chirpRevisionResponse{
+			Body:       revision.Body,
+			CreatedAt:  revision.CreatedAt,
+			ReplacedAt: revision.ReplacedAt,
+		}
+	}
+	jsonRevisions, err := json.Marshal(&response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonRevisions)
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const emailVerificationTTL = 24 * time.Hour
+
+var errInvalidEmail = errors.New("a valid email address like 'walt@breakingbad.com' is expected")
+
+// validateEmail only accepts a bare address, without a display name like "Walt <walt@breakingbad.com>"
+func validateEmail(email string) (string, error) {
+	email = strings.TrimSpace(email)
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Name != "" || address.Address != email {
+		return "", errInvalidEmail
+	}
+	return email, nil
+}
+
+// sendEmailVerification mails a token proving the user owns the address, the tokens sent before are invalidated. It
+// runs as a subscriber of the email.verification_requested events, after the signup or the change was answered
+func (cfg *ApiConfig) sendEmailVerification(ctx context.Context, queries *database.Queries, userId uuid.UUID, email string) error {
+	err := queries.InvalidateUserEmailVerificationTokens(ctx, userId)
+	if err != nil {
+		return err
+	}
+	verificationToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = queries.CreateEmailVerificationToken(ctx, database.CreateEmailVerificationTokenParams{
+		TokenHash: auth.HashRefreshToken(verificationToken),
+		UserID:    userId,
+		Email:     email,
+		ExpiresAt: time.Now().Add(emailVerificationTTL),
+	})
+	if err != nil {
+		return err
+	}
+	verificationLink := fmt.Sprintf("%v/app/verify-email?token=%v", cfg.publicUrl, url.QueryEscape(verificationToken))
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Verify your email for Chirpy",
+		Body: fmt.Sprintf("Open this link within %v to confirm this address for your Chirpy account:\n%v\n\n"+
+			"If you didn't sign up or change your email on Chirpy, ignore this email.\n", emailVerificationTTL, verificationLink),
+	})
+}
+
+// handleVerifyEmail confirms the address of a new account, or applies a pending email change
+func (cfg *ApiConfig) handleVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	type verifyEmailParams struct {
+		Token string `json:"token"`
+	}
+	header := w.Header()
+	parameters, err := unmarshalRequestBody[verifyEmailParams](w, r)
+	if err != nil {
+		return
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the email verification transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	verification, err := queries.UseEmailVerificationToken(r.Context(), auth.HashRefreshToken(parameters.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("this verification link is invalid or has expired"))
+		return
+	}
+	if err != nil {
+		log.Printf("error when using the email verification token: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	verifiedUser, err := queries.VerifyUserEmail(r.Context(), database.VerifyUserEmailParams{
+		ID:    verification.UserID,
+		Email: verification.Email,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(409)
+		w.Write([]byte("this email is already used by another account"))
+		return
+	}
+	if err == nil {
+		err = publishEvent(r.Context(), queries, eventUserUpdated, newUserEvent(verifiedUser))
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("error when verifying the email: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonUser, err := json.Marshal(newUserResponse(verifiedUser))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonUser)
+}
+
+// handlerResendEmailVerification sends a new link to the pending address, or to the account address if it isn't verified
+func handlerResendEmailVerification(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	user, err := cfg.dbQueries.GetUserById(r.Context(), curUserId)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	email, err := cfg.dbQueries.GetPendingEmail(r.Context(), curUserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		if user.EmailVerifiedAt.Valid {
+			w.Header().Add("Content-Type", "text/plain")
+			w.WriteHeader(409)
+			w.Write([]byte("the email of this account is already verified"))
+			return
+		}
+		email, err = user.Email, nil
+	}
+	if err == nil {
+		err = publishEvent(r.Context(), cfg.dbQueries, eventEmailVerificationRequested, emailVerificationEvent{UserId: curUserId, Email: email})
+	}
+	if err != nil {
+		log.Printf("error when requesting the verification email: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(202)
+}
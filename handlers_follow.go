@@ -0,0 +1,229 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type followResponse struct { // we don't expose emails of other users, only their public information
+	Id          string    `json:"id"`
+	CreatedAt   time.Time `json:"created_at"`
+	IsChirpyRed bool      `json:"is_chirpy_red"`
+	FollowedAt  time.Time `json:"followed_at"`
+}
+
+type followsPage struct {
+	Users      []followResponse `json:"users"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type followCursor struct {
+	FollowedAt time.Time `json:"followed_at"`
+	Id         uuid.UUID `json:"id"`
+}
+
+func handlerFollowUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	followeeId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	if followeeId == curUserId {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a user can't follow themselves"))
+		return
+	}
+	_, err = cfg.dbQueries.GetUserById(r.Context(), followeeId)
+	if err != nil {
+		log.Printf("error when getting the user to follow: %v", err)
+		w.WriteHeader(404)
+		return
+	}
+	err = cfg.dbQueries.FollowUser(r.Context(), database.FollowUserParams{
+		FollowerID: curUserId,
+		FolloweeID: followeeId,
+	})
+	if err != nil {
+		log.Printf("error when following user: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func handlerUnfollowUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	followeeId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	err = cfg.dbQueries.UnfollowUser(r.Context(), database.UnfollowUserParams{
+		FollowerID: curUserId,
+		FolloweeID: followeeId,
+	})
+	if err != nil {
+		log.Printf("error when unfollowing user: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) handleListFollowers(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, func(userId uuid.UUID, after sql.NullTime, afterId uuid.NullUUID, limit int32) ([]followResponse, error) {
+		followers, err := cfg.dbQueries.ListFollowers(r.Context(), database.ListFollowersParams{
+			UserID:          userId,
+			AfterFollowedAt: after,
+			AfterID:         afterId,
+			RowLimit:        limit,
+		})
+		users := make([]followResponse, len(followers))
+		for i, follower := range followers {
+			users[i] = followResponse{
+				Id:          follower.ID.String(),
+				CreatedAt:   follower.CreatedAt,
+				IsChirpyRed: follower.IsChirpyRed.Bool,
+				FollowedAt:  follower.FollowedAt,
+			}
+		}
+		return users, err
+	})
+}
+
+func (cfg *ApiConfig) handleListFollowing(w http.ResponseWriter, r *http.Request) {
+	listFollows(w, r, func(userId uuid.UUID, after sql.NullTime, afterId uuid.NullUUID, limit int32) ([]followResponse, error) {
+		followees, err := cfg.dbQueries.ListFollowing(r.Context(), database.ListFollowingParams{
+			UserID:          userId,
+			AfterFollowedAt: after,
+			AfterID:         afterId,
+			RowLimit:        limit,
+		})
+		users := make([]followResponse, len(followees))
+		for i, followee := range followees {
+			users[i] = followResponse{
+				Id:          followee.ID.String(),
+				CreatedAt:   followee.CreatedAt,
+				IsChirpyRed: followee.IsChirpyRed.Bool,
+				FollowedAt:  followee.FollowedAt,
+			}
+		}
+		return users, err
+	})
+}
+
+// followers and followings are paginated the same way, only the query changes
+func listFollows(w http.ResponseWriter, r *http.Request, list func(uuid.UUID, sql.NullTime, uuid.NullUUID, int32) ([]followResponse, error)) {
+	header := w.Header()
+	userId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	limit, err := parsePageLimit(r.URL.Query())
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[followCursor](r.URL.Query().Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	after := sql.NullTime{}
+	afterId := uuid.NullUUID{}
+	if cursor != nil {
+		after = sql.NullTime{Time: cursor.FollowedAt, Valid: true}
+		afterId = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	users, err := list(userId, after, afterId, int32(limit+1))
+	if err != nil {
+		log.Printf("error when listing follows: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := followsPage{Users: users}
+	if len(users) > limit {
+		page.Users = users[:limit]
+		lastUser := page.Users[limit-1]
+		page.NextCursor, err = encodeCursor(followCursor{FollowedAt: lastUser.FollowedAt, Id: uuid.MustParse(lastUser.Id)})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+func handlerTimeline(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	limit, err := parsePageLimit(r.URL.Query())
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[chirpCursor](r.URL.Query().Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.GetTimelineParams{
+		UserID:   curUserId,
+		RowLimit: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.AfterCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	chirpList, err := cfg.dbQueries.GetTimeline(r.Context(), params)
+	if err != nil {
+		log.Printf("error when getting the timeline: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := chirpsPage{}
+	if len(chirpList) > limit {
+		chirpList = chirpList[:limit]
+		lastChirp := chirpList[len(chirpList)-1]
+		page.NextCursor, err = encodeCursor(chirpCursor{CreatedAt: lastChirp.CreatedAt, Id: lastChirp.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	page.Chirps, err = cfg.chirpResponses(r.Context(), chirpList, uuid.NullUUID{UUID: curUserId, Valid: true})
+	if err != nil {
+		log.Printf("error when building the timeline response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+// chirpResponses builds the responses of a page of chirps: the quoted chirps and the viewer's likes/rechirps are
+// loaded with one query each for the whole page. The counters are already on the chirps rows
+func (cfg *ApiConfig) chirpResponses(ctx context.Context, chirps []database.Chirp, viewerId uuid.NullUUID) ([]chirpResponse, error) {
+	responses := make([]chirpResponse, len(chirps))
+	chirpIds := make([]uuid.UUID, len(chirps))
+	quotedIds := []uuid.UUID{}
+	for i, chirp := range chirps {
+		responses[i] = newChirpResponse(chirp, viewerId)
+		chirpIds[i] = chirp.ID
+		if chirp.QuoteOf.Valid && !responses[i].Deleted && !responses[i].Hidden {
+			quotedIds = append(quotedIds, chirp.QuoteOf.UUID)
+		}
+	}
+
+	if len(quotedIds) > 0 {
+		quotedChirps, err := cfg.dbQueries.GetChirpsByIds(ctx, quotedIds)
+		if err != nil {
+			return nil, err
+		}
+		quotedById := map[uuid.UUID]chirpResponse{}
+		for _, quoted := range quotedChirps {
+			quotedById[quoted.ID] = newChirpResponse(quoted, viewerId)
+		}
+		for i, chirp := range chirps {
+			quoted, ok := quotedById[chirp.QuoteOf.UUID]
+			if chirp.QuoteOf.Valid && responses[i].QuoteOf != "" && ok {
+				responses[i].QuotedChirp = &quoted
+			}
+		}
+	}
+
+	if !viewerId.Valid || len(chirps) == 0 {
+		return responses, nil
+	}
+	likedIds, err := cfg.dbQueries.GetLikedChirpIds(ctx, database.GetLikedChirpIdsParams{
+		UserID:   viewerId.UUID,
+		ChirpIds: chirpIds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	rechirpedIds, err := cfg.dbQueries.GetRechirpedChirpIds(ctx, database.GetRechirpedChirpIdsParams{
+		UserID:   viewerId.UUID,
+		ChirpIds: chirpIds,
+	})
+	if err != nil {
+		return nil, err
+	}
+	liked := map[uuid.UUID]bool{}
+	for _, id := range likedIds {
+		liked[id] = true
+	}
+	rechirped := map[uuid.UUID]bool{}
+	for _, id := range rechirpedIds {
+		rechirped[id] = true
+	}
+	for i, chirp := range chirps {
+		isLiked, isRechirped := liked[chirp.ID], rechirped[chirp.ID]
+		responses[i].Liked = &isLiked
+		responses[i].Rechirped = &isRechirped
+	}
+	return responses, nil
+}
+
+func handlerLikeChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	chirpInteraction(w, r, cfg, func(chirpId uuid.UUID) error {
+		return cfg.dbQueries.LikeChirp(r.Context(), database.LikeChirpParams{UserID: curUserId, ChirpID: chirpId})
+	})
+}
+
+func handlerUnlikeChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	chirpInteraction(w, r, cfg, func(chirpId uuid.UUID) error {
+		return cfg.dbQueries.UnlikeChirp(r.Context(), database.UnlikeChirpParams{UserID: curUserId, ChirpID: chirpId})
+	})
+}
+
+func handlerRechirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	chirpInteraction(w, r, cfg, func(chirpId uuid.UUID) error {
+		return cfg.dbQueries.Rechirp(r.Context(), database.RechirpParams{UserID: curUserId, ChirpID: chirpId})
+	})
+}
+
+func handlerUndoRechirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	chirpInteraction(w, r, cfg, func(chirpId uuid.UUID) error {
+		return cfg.dbQueries.UndoRechirp(r.Context(), database.UndoRechirpParams{UserID: curUserId, ChirpID: chirpId})
+	})
+}
+
+// likes and rechirps are idempotent: liking twice or unliking a chirp that was not liked still answers 204
+func chirpInteraction(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, interact func(uuid.UUID) error) {
+	chirpId, err := uuid.Parse(r.PathValue("chirpId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpId)
+	if err != nil || !isChirpVisible(chirp, uuid.NullUUID{}) {
+		w.WriteHeader(404)
+		return
+	}
+	err = interact(chirp.ID)
+	if err != nil {
+		log.Printf("error when updating the chirp interaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/mailer"
+	"github.com/google/uuid"
+)
+
+const (
+	throttleAccount = "account"
+	throttleIp      = "ip"
+	// the password reset requests, each one sends an email
+	throttleResetEmail = "reset_email"
+	throttleResetIp    = "reset_ip"
+
+	loginThrottleCleanupPeriod = time.Hour
+)
+
+var throttlePolicies = map[string]auth.ThrottlePolicy{
+	throttleAccount:    auth.AccountThrottle,
+	throttleIp:         auth.IpThrottle,
+	throttleResetEmail: auth.ResetEmailThrottle,
+	throttleResetIp:    auth.ResetIpThrottle,
+}
+
+// lockoutHook is called when the failed logins of an existing account lock it out, it is retried when it fails
+type lockoutHook func(ctx context.Context, email string, lockedUntil time.Time) error
+
+type loginThrottleResponse struct {
+	Kind          string    `json:"kind"`
+	Subject       string    `json:"subject"`
+	Failures      int32     `json:"failures"`
+	LastFailureAt time.Time `json:"last_failure_at"`
+	BlockedUntil  time.Time `json:"blocked_until"`
+	LockedOut     bool      `json:"locked_out"`
+}
+
+// the accounts are tracked by email, so an email without an account is throttled like any other
+func accountThrottleSubject(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+type loginThrottleSubject struct {
+	kind    string
+	subject string
+}
+
+// loginThrottleSubjects always gives the account first, so two attempts sharing a subject lock their rows in the same
+// order
+func loginThrottleSubjects(email, ip string) []loginThrottleSubject {
+	return []loginThrottleSubject{
+		{kind: throttleAccount, subject: accountThrottleSubject(email)},
+		{kind: throttleIp, subject: ip},
+	}
+}
+
+// loginAttempt counts as a failure of the account and the IP from its start, so the concurrent attempts of a client
+// can't all pass the throttles before any failure is recorded. Nothing stays locked while the password is checked, the
+// attempt is settled once its outcome is known
+type loginAttempt struct {
+	queries   *database.Queries
+	email     string
+	throttles []reservedThrottle
+	settled   bool
+}
+
+// reservedThrottle is a throttle the attempt counted itself in
+type reservedThrottle struct {
+	loginThrottleSubject
+	failures      int32     // with the attempt
+	previousBlock time.Time // restored when the attempt is given back
+	reservedBlock time.Time // the block the failures with the attempt called for
+}
+
+// beginLoginAttempt counts the attempt in the throttles, or tells how long the client has to wait before its next
+// attempt. The attempt is given back by end when it wasn't settled
+func (cfg *ApiConfig) beginLoginAttempt(ctx context.Context, email, ip string) (*loginAttempt, time.Duration, error) {
+	return cfg.beginThrottledAttempt(ctx, email, loginThrottleSubjects(email, ip))
+}
+
+// beginThrottledAttempt is beginLoginAttempt for any throttled subjects, they should always be given in the same order
+func (cfg *ApiConfig) beginThrottledAttempt(ctx context.Context, email string, subjects []loginThrottleSubject) (*loginAttempt, time.Duration, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+	attempt := &loginAttempt{queries: cfg.dbQueries, email: email}
+	var blockedFor time.Duration
+	for _, subject := range subjects {
+		reserved, err := queries.ReserveLoginAttempt(ctx, database.ReserveLoginAttemptParams{Kind: subject.kind, Subject: subject.subject})
+		if err != nil {
+			return nil, 0, err
+		}
+		blockedFor = max(blockedFor, time.Until(reserved.BlockedUntil))
+		throttle := reservedThrottle{
+			loginThrottleSubject: subject,
+			failures:             reserved.Failures,
+			previousBlock:        reserved.BlockedUntil,
+			reservedBlock:        reserved.BlockedUntil,
+		}
+		if delay := throttlePolicies[subject.kind].BlockedFor(int(reserved.Failures)); delay > 0 {
+			throttle.reservedBlock, err = queries.BlockLogin(ctx, database.BlockLoginParams{
+				Kind:         subject.kind,
+				Subject:      subject.subject,
+				BlockedUntil: time.Now().Add(delay),
+			})
+			if err != nil {
+				return nil, 0, err
+			}
+		}
+		attempt.throttles = append(attempt.throttles, throttle)
+	}
+	if blockedFor > 0 { // rolled back, a refused attempt isn't counted
+		return nil, blockedFor, nil
+	}
+	err = tx.Commit()
+	if err != nil {
+		return nil, 0, err
+	}
+	return attempt, 0, nil
+}
+
+// loginOutcome is how an attempt ended, it decides what becomes of the failure the attempt was counted as
+type loginOutcome int
+
+const (
+	loginAbandoned loginOutcome = iota // nothing was found wrong but nothing was proven either, like a missing 2FA code
+	loginFailed
+	loginSucceeded
+)
+
+type throttleSettlement int
+
+const (
+	refundAttempt throttleSettlement = iota
+	keepFailure
+	forgetFailures
+)
+
+// settlementFor only forgets the failures of the account when the attempt passed every factor, the ones of the IP
+// stay so a valid account can't be used to reset them
+func settlementFor(kind string, outcome loginOutcome) throttleSettlement {
+	switch {
+	case outcome == loginFailed:
+		return keepFailure
+	case outcome == loginSucceeded && kind == throttleAccount:
+		return forgetFailures
+	}
+	return refundAttempt
+}
+
+// settle applies the outcome to the throttles, the owner of an existing account is told when its failures lock it out
+func (attempt *loginAttempt) settle(ctx context.Context, outcome loginOutcome, accountExists bool) error {
+	attempt.settled = true
+	for _, throttle := range attempt.throttles {
+		var err error
+		switch settlementFor(throttle.kind, outcome) {
+		case keepFailure:
+			err = attempt.keepFailure(ctx, throttle, accountExists)
+		case forgetFailures:
+			_, err = attempt.queries.ClearLoginThrottle(ctx, database.ClearLoginThrottleParams{Kind: throttle.kind, Subject: throttle.subject})
+		default:
+			err = attempt.queries.RefundLoginAttempt(ctx, database.RefundLoginAttemptParams{
+				ReservedBlock: throttle.reservedBlock,
+				PreviousBlock: throttle.previousBlock,
+				Kind:          throttle.kind,
+				Subject:       throttle.subject,
+			})
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// end gives the attempt back when it wasn't settled, like when it stopped on an error
+func (attempt *loginAttempt) end(ctx context.Context) {
+	if attempt.settled {
+		return
+	}
+	err := attempt.settle(context.WithoutCancel(ctx), loginAbandoned, false)
+	if err != nil {
+		log.Printf("error when giving back the login attempt of %v: %v", attempt.email, err)
+	}
+}
+
+func (attempt *loginAttempt) keepFailure(ctx context.Context, throttle reservedThrottle, accountExists bool) error {
+	err := attempt.queries.RecordLoginFailure(ctx, database.RecordLoginFailureParams{Kind: throttle.kind, Subject: throttle.subject})
+	if err != nil || !throttlePolicies[throttle.kind].IsLockout(int(throttle.failures)) {
+		return err
+	}
+	log.Printf("too many failed attempts, the %v %v is locked out until %v", throttle.kind, throttle.subject, throttle.reservedBlock)
+	if throttle.kind != throttleAccount || !accountExists {
+		return nil
+	}
+	return publishEvent(ctx, attempt.queries, eventLoginLockedOut, loginLockedOutEvent{Email: attempt.email, LockedUntil: throttle.reservedBlock})
+}
+
+// deleteStaleLoginThrottlesPeriodically forgets the subjects without any recent failure, every IP that ever tried to
+// log in would be kept otherwise
+func (cfg *ApiConfig) deleteStaleLoginThrottlesPeriodically() {
+	ticker := time.NewTicker(loginThrottleCleanupPeriod)
+	defer ticker.Stop()
+	for {
+		deleted, err := cfg.dbQueries.DeleteStaleLoginThrottles(context.Background())
+		if err != nil {
+			log.Printf("error when deleting the stale login throttles: %v", err)
+		} else if deleted > 0 {
+			log.Printf("%v stale login throttles deleted", deleted)
+		}
+		<-ticker.C
+	}
+}
+
+func tooManyLoginAttempts(w http.ResponseWriter, blockedFor time.Duration) {
+	tooManyAttempts(w, blockedFor, "too many failed logins, try again later")
+}
+
+func tooManyAttempts(w http.ResponseWriter, blockedFor time.Duration, message string) {
+	header := w.Header()
+	header.Add("Retry-After", fmt.Sprintf("%d", int(math.Ceil(blockedFor.Seconds()))))
+	header.Add("Content-Type", "text/plain")
+	w.WriteHeader(429)
+	w.Write([]byte(message))
+}
+
+// emailLockoutNotice is the default lockoutHook
+func (cfg *ApiConfig) emailLockoutNotice(ctx context.Context, email string, lockedUntil time.Time) error {
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      email,
+		Subject: "Your Chirpy account is temporarily locked",
+		Body: fmt.Sprintf("There were too many failed logins on your Chirpy account, logging in is blocked until %v.\n\n"+
+			"If it wasn't you, someone may be trying to guess your password: consider changing it.\n",
+			lockedUntil.UTC().Format(time.RFC1123)),
+	})
+}
+
+func handlerListLoginLockouts(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	throttles, err := cfg.dbQueries.ListBlockedLogins(r.Context())
+	if err != nil {
+		log.Printf("error when listing the login lockouts: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := make([]loginThrottleResponse, len(throttles))
+	for i, throttle := range throttles {
+		response[i] = loginThrottleResponse{
+			Kind:          throttle.Kind,
+			Subject:       throttle.Subject,
+			Failures:      throttle.Failures,
+			LastFailureAt: throttle.LastFailureAt,
+			BlockedUntil:  throttle.BlockedUntil,
+			LockedOut:     int(throttle.Failures) >= throttlePolicies[throttle.Kind].LockoutAfter,
+		}
+	}
+	jsonThrottles, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonThrottles)
+}
+
+// handlerClearLoginLockout forgets all the failures of the account or the IP, not only the current block
+func handlerClearLoginLockout(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	kind := r.PathValue("kind")
+	if _, ok := throttlePolicies[kind]; !ok {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("the kind should be account, ip, reset_email or reset_ip"))
+		return
+	}
+	subject := r.PathValue("subject")
+	if kind == throttleAccount || kind == throttleResetEmail {
+		subject = accountThrottleSubject(subject)
+	}
+	cleared, err := cfg.dbQueries.ClearLoginThrottle(r.Context(), database.ClearLoginThrottleParams{Kind: kind, Subject: subject})
+	if err != nil {
+		log.Printf("error when clearing the login lockout: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if cleared == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	log.Printf("user %v cleared the login failures of the %v %v", curUserId, kind, subject)
+	w.WriteHeader(204)
+}
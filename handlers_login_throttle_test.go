@@ -0,0 +1,54 @@
+package main
+
+import "testing"
+
+// settleFailures applies an attempt to the failures of a subject like beginLoginAttempt and settle do in the database
+func settleFailures(failures int, kind string, outcome loginOutcome) int {
+	failures++ // counted when the attempt begins
+	switch settlementFor(kind, outcome) {
+	case refundAttempt:
+		failures--
+	case forgetFailures:
+		failures = 0
+	}
+	return failures
+}
+
+func TestMissingCodesDontResetTheWrongCodes(t *testing.T) {
+	policy := throttlePolicies[throttleAccount]
+	failures := 0
+	// whoever knows the password alternates a request without a code with a wrong code
+	for i := 0; i < 2*policy.LockoutAfter; i++ {
+		code := ""
+		if i%2 == 1 {
+			code = "123456"
+		}
+		failures = settleFailures(failures, throttleAccount, secondFactorOutcome(code, false))
+	}
+	if failures < policy.LockoutAfter {
+		t.Errorf("the account has %v failures after %v wrong codes, it should be locked out after %v", failures, policy.LockoutAfter, policy.LockoutAfter)
+	}
+}
+
+func TestSettlementFor(t *testing.T) {
+	cases := []struct {
+		kind     string
+		outcome  loginOutcome
+		expected throttleSettlement
+	}{
+		{kind: throttleAccount, outcome: loginFailed, expected: keepFailure},
+		{kind: throttleIp, outcome: loginFailed, expected: keepFailure},
+		{kind: throttleAccount, outcome: loginSucceeded, expected: forgetFailures},
+		{kind: throttleIp, outcome: loginSucceeded, expected: refundAttempt},
+		{kind: throttleAccount, outcome: loginAbandoned, expected: refundAttempt},
+		{kind: throttleIp, outcome: loginAbandoned, expected: refundAttempt},
+	}
+	for _, c := range cases {
+		if settlement := settlementFor(c.kind, c.outcome); settlement != c.expected {
+			t.Errorf("the %v outcome %v is settled as %v, expected %v", c.kind, c.outcome, settlement, c.expected)
+		}
+	}
+	if outcome := secondFactorOutcome("123456", true); outcome != loginSucceeded {
+		t.Errorf("a verified code gives the outcome %v, expected %v", outcome, loginSucceeded)
+	}
+}
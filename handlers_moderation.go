@@ -0,0 +1,214 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/moderation"
+	"github.com/google/uuid"
+)
+
+const (
+	chirpPublished = "published"
+	chirpHeld      = "held"   // waiting for a moderator, only its author sees it
+	chirpHidden    = "hidden" // taken down by a moderator
+)
+
+// the other instances only see the words edited by the admins on their next reload
+const moderationWordsReloadPeriod = time.Minute
+
+type moderationResponse struct {
+	Action  string   `json:"action"`
+	Filters []string `json:"filters"`
+}
+
+type moderatedChirpResponse struct {
+	chirpResponse
+	Moderation moderationResponse `json:"moderation"`
+}
+
+type moderationWordResponse struct {
+	Word      string    `json:"word"`
+	Action    string    `json:"action"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newModerationResponse(verdict moderation.Verdict) moderationResponse {
+	return moderationResponse{
+		Action:  verdict.Action.String(),
+		Filters: verdict.ActedBy,
+	}
+}
+
+// a deleted chirp is never visible, the threads only keep a tombstone in its place. Moderation hides unpublished chirps from other users
+func isChirpVisible(chirp database.Chirp, viewerId uuid.NullUUID) bool {
+	if chirp.DeletedAt.Valid {
+		return false
+	}
+	return chirp.Status == chirpPublished || (viewerId.Valid && viewerId.UUID == chirp.UserID)
+}
+
+func rejectChirp(w http.ResponseWriter, verdict moderation.Verdict) {
+	type rejectedChirp struct {
+		Error      string             `json:"error"`
+		Moderation moderationResponse `json:"moderation"`
+	}
+	jsonResBody, err := json.Marshal(rejectedChirp{
+		Error:      "the chirp was rejected by moderation",
+		Moderation: newModerationResponse(verdict),
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(400)
+	w.Write(jsonResBody)
+}
+
+// the words of the MODERATION_WORDS_FILE are the base list, the ones edited by the admins in the database override them
+func (cfg *ApiConfig) reloadModerationWords(ctx context.Context) error {
+	words := map[string]moderation.Action{}
+	if cfg.moderationWordsFile != "" {
+		fileWords, err := moderation.LoadWordListFile(cfg.moderationWordsFile)
+		if err != nil {
+			return err
+		}
+		words = fileWords
+	}
+	dbWords, err := cfg.dbQueries.ListModerationWords(ctx)
+	if err != nil {
+		return err
+	}
+	for _, dbWord := range dbWords {
+		action, err := moderation.ParseAction(dbWord.Action)
+		if err != nil {
+			return err
+		}
+		words[dbWord.Word] = action
+	}
+	cfg.moderationWords.Replace(words)
+	return nil
+}
+
+func (cfg *ApiConfig) reloadModerationWordsPeriodically() {
+	ticker := time.NewTicker(moderationWordsReloadPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := cfg.reloadModerationWords(context.Background())
+		if err != nil {
+			log.Printf("error when reloading the moderation words: %v", err)
+		}
+	}
+}
+
+func newModerationChain(cfg *ApiConfig) (*moderation.Chain, error) {
+	cfg.moderationWords = moderation.NewWordList("wordlist", nil)
+	cfg.moderationWordsFile = os.Getenv("MODERATION_WORDS_FILE")
+	err := cfg.reloadModerationWords(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	filters := []moderation.Filter{cfg.moderationWords}
+	if rulesFile := os.Getenv("MODERATION_RULES_FILE"); rulesFile != "" {
+		rules, err := moderation.LoadRegexRulesFile(rulesFile)
+		if err != nil {
+			return nil, err
+		}
+		filters = append(filters, moderation.NewRegexFilter("regex", rules))
+	}
+	return moderation.NewChain(filters...), nil
+}
+
+func handlerListModerationWords(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	words, err := cfg.dbQueries.ListModerationWords(r.Context())
+	if err != nil {
+		log.Printf("error when listing the moderation words: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := make([]moderationWordResponse, len(words))
+	for i, word := range words {
+		response[i] = moderationWordResponse{
+			Word:      word.Word,
+			Action:    word.Action,
+			UpdatedAt: word.UpdatedAt,
+		}
+	}
+	jsonWords, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonWords)
+}
+
+func handlerPutModerationWord(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type moderationWordParameters struct {
+		Action string `json:"action"`
+	}
+	header := w.Header()
+	word := strings.TrimSpace(r.PathValue("word"))
+	parameters, err := unmarshalRequestBody[moderationWordParameters](w, r)
+	if err != nil {
+		return
+	}
+	action, err := moderation.ParseAction(parameters.Action)
+	if err != nil || word == "" || strings.ContainsAny(word, " \t") {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a single word and an action among allow, mask, hold or reject are expected"))
+		return
+	}
+	savedWord, err := cfg.dbQueries.UpsertModerationWord(r.Context(), database.UpsertModerationWordParams{
+		Word:   word,
+		Action: action.String(),
+	})
+	if err != nil {
+		log.Printf("error when saving the moderation word: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.reloadModerationWords(r.Context())
+	if err != nil {
+		log.Printf("error when reloading the moderation words: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonWord, err := json.Marshal(moderationWordResponse{
+		Word:      savedWord.Word,
+		Action:    savedWord.Action,
+		UpdatedAt: savedWord.UpdatedAt,
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonWord)
+}
+
+func handlerDeleteModerationWord(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	err := cfg.dbQueries.DeleteModerationWord(r.Context(), r.PathValue("word"))
+	if err != nil {
+		log.Printf("error when deleting the moderation word: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.reloadModerationWords(r.Context())
+	if err != nil {
+		log.Printf("error when reloading the moderation words: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
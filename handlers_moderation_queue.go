@@ -0,0 +1,447 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	actionDismiss       = "dismiss"
+	actionPublishChirp  = "publish_chirp"
+	actionHideChirp     = "hide_chirp"
+	actionDeleteChirp   = "delete_chirp"
+	actionSuspendUser   = "suspend_user"
+	actionUnsuspendUser = "unsuspend_user"
+)
+
+// moderators see the chirps as they are stored, whatever their status
+type moderatedChirp struct {
+	Id        string    `json:"id"`
+	Body      string    `json:"body"`
+	UserId    string    `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	Status    string    `json:"status"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+type queuedReport struct {
+	reportResponse
+	Chirp *moderatedChirp `json:"chirp,omitempty"`
+}
+
+type reportsPage struct {
+	Reports    []queuedReport `json:"reports"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+type heldChirpsPage struct {
+	Chirps     []moderatedChirp `json:"chirps"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+type moderationActionResponse struct {
+	Id              string    `json:"id"`
+	CreatedAt       time.Time `json:"created_at"`
+	ModeratorId     string    `json:"moderator_id,omitempty"`
+	Action          string    `json:"action"`
+	ChirpId         string    `json:"chirp_id,omitempty"`
+	UserId          string    `json:"user_id,omitempty"`
+	Notes           string    `json:"notes"`
+	ResolvedReports int64     `json:"resolved_reports,omitempty"`
+}
+
+type moderationActionsPage struct {
+	Actions    []moderationActionResponse `json:"actions"`
+	NextCursor string                     `json:"next_cursor,omitempty"`
+}
+
+func newModeratedChirp(chirp database.Chirp) moderatedChirp {
+	return moderatedChirp{
+		Id:        chirp.ID.String(),
+		Body:      chirp.Body,
+		UserId:    chirp.UserID.String(),
+		CreatedAt: chirp.CreatedAt,
+		Status:    chirp.Status,
+		Deleted:   chirp.DeletedAt.Valid,
+	}
+}
+
+func newModerationActionResponse(action database.ModerationAction) moderationActionResponse {
+	response := moderationActionResponse{
+		Id:        action.ID.String(),
+		CreatedAt: action.CreatedAt,
+		Action:    action.Action,
+		Notes:     action.Notes,
+	}
+	if action.ModeratorID.Valid {
+		response.ModeratorId = action.ModeratorID.UUID.String()
+	}
+	if action.ChirpID.Valid {
+		response.ChirpId = action.ChirpID.UUID.String()
+	}
+	if action.UserID.Valid {
+		response.UserId = action.UserID.UUID.String()
+	}
+	return response
+}
+
+func handlerListReports(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	query := r.URL.Query()
+	resolved := false
+	switch query.Get("status") {
+	case "", "open":
+	case "resolved":
+		resolved = true
+	default:
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("status should be 'open' or 'resolved'"))
+		return
+	}
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[chirpCursor](query.Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.ListReportsParams{
+		Resolved: resolved,
+		RowLimit: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.AfterCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	reports, err := cfg.dbQueries.ListReports(r.Context(), params)
+	if err != nil {
+		log.Printf("error when listing the reports: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := reportsPage{}
+	if len(reports) > limit {
+		reports = reports[:limit]
+		lastReport := reports[limit-1]
+		page.NextCursor, err = encodeCursor(chirpCursor{CreatedAt: lastReport.CreatedAt, Id: lastReport.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+
+	chirpIds := []uuid.UUID{}
+	for _, report := range reports {
+		if report.ChirpID.Valid {
+			chirpIds = append(chirpIds, report.ChirpID.UUID)
+		}
+	}
+	chirpsById := map[uuid.UUID]moderatedChirp{}
+	if len(chirpIds) > 0 {
+		chirps, err := cfg.dbQueries.GetChirpsByIds(r.Context(), chirpIds)
+		if err != nil {
+			log.Printf("error when getting the reported chirps: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		for _, chirp := range chirps {
+			chirpsById[chirp.ID] = newModeratedChirp(chirp)
+		}
+	}
+	page.Reports = make([]queuedReport, len(reports))
+	for i, report := range reports {
+		page.Reports[i] = queuedReport{reportResponse: newReportResponse(report)}
+		if chirp, ok := chirpsById[report.ChirpID.UUID]; report.ChirpID.Valid && ok {
+			page.Reports[i].Chirp = &chirp
+		}
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+func handlerListHeldChirps(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	limit, err := parsePageLimit(r.URL.Query())
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[chirpCursor](r.URL.Query().Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.ListHeldChirpsParams{RowLimit: int32(limit + 1)}
+	if cursor != nil {
+		params.AfterCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	chirps, err := cfg.dbQueries.ListHeldChirps(r.Context(), params)
+	if err != nil {
+		log.Printf("error when listing the held chirps: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := heldChirpsPage{}
+	if len(chirps) > limit {
+		chirps = chirps[:limit]
+		lastChirp := chirps[limit-1]
+		page.NextCursor, err = encodeCursor(chirpCursor{CreatedAt: lastChirp.CreatedAt, Id: lastChirp.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	page.Chirps = make([]moderatedChirp, len(chirps))
+	for i, chirp := range chirps {
+		page.Chirps[i] = newModeratedChirp(chirp)
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+// handlerModerationAction records a moderator decision, applies it and resolves the reports it answers:
+// the listed ones, plus every open report about the chirp or the user the action was taken on
+func handlerModerationAction(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type moderationActionParams struct {
+		Action    string   `json:"action"`
+		ChirpId   string   `json:"chirp_id"`
+		UserId    string   `json:"user_id"`
+		ReportIds []string `json:"report_ids"`
+		Notes     string   `json:"notes"`
+	}
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	parameters, err := unmarshalRequestBody[moderationActionParams](w, r)
+	if err != nil {
+		return
+	}
+	reportIds := make([]uuid.UUID, len(parameters.ReportIds))
+	for i, rawReportId := range parameters.ReportIds {
+		reportId, err := uuid.Parse(rawReportId)
+		if err != nil {
+			badRequest("report_ids should only contain report ids")
+			return
+		}
+		reportIds[i] = reportId
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the moderation transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	action := database.CreateModerationActionParams{
+		ModeratorID: uuid.NullUUID{UUID: curUserId, Valid: true},
+		Action:      parameters.Action,
+		Notes:       parameters.Notes,
+	}
+	resolved := database.ResolveReportsParams{Ids: reportIds}
+	switch parameters.Action {
+	case actionDismiss:
+		if len(reportIds) == 0 {
+			badRequest("report_ids are needed to dismiss reports")
+			return
+		}
+	case actionPublishChirp, actionHideChirp, actionDeleteChirp:
+		chirpId, err := uuid.Parse(parameters.ChirpId)
+		if err != nil {
+			badRequest("chirp_id is needed for this action")
+			return
+		}
+		chirp, err := queries.GetChirpByIdForUpdate(r.Context(), chirpId)
+		if err != nil || chirp.DeletedAt.Valid {
+			w.WriteHeader(404)
+			return
+		}
+		switch parameters.Action {
+		case actionPublishChirp:
+			_, err = queries.SetChirpStatus(r.Context(), database.SetChirpStatusParams{ID: chirp.ID, Status: chirpPublished})
+			if err == nil && chirp.Status != chirpPublished { // the chirp only shows up now, for the streams too
+				err = publishEvent(r.Context(), queries, eventChirpPublished, chirpEvent{
+					chirpResponse: newChirpResponse(chirp, uuid.NullUUID{UUID: chirp.UserID, Valid: true}),
+					Status:        chirpPublished,
+				})
+			}
+		case actionHideChirp:
+			_, err = queries.SetChirpStatus(r.Context(), database.SetChirpStatusParams{ID: chirp.ID, Status: chirpHidden})
+			if err == nil && chirp.Status != chirpHidden { // gone for everyone but its author, like a deleted chirp
+				err = publishEvent(r.Context(), queries, eventChirpDeleted, chirpDeletedEvent{Id: chirp.ID, UserId: chirp.UserID, Status: chirp.Status})
+			}
+		case actionDeleteChirp: // always a tombstone, the replies and the reports keep pointing to it
+			err = queries.TombstoneChirp(r.Context(), chirp.ID)
+			if err == nil {
+				err = publishEvent(r.Context(), queries, eventChirpDeleted, chirpDeletedEvent{Id: chirp.ID, UserId: chirp.UserID, Tombstoned: true, Status: chirp.Status})
+			}
+		}
+		if err != nil {
+			log.Printf("error when moderating the chirp: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		action.ChirpID = uuid.NullUUID{UUID: chirp.ID, Valid: true}
+		action.UserID = uuid.NullUUID{UUID: chirp.UserID, Valid: true}
+		resolved.ChirpID = action.ChirpID
+	case actionSuspendUser, actionUnsuspendUser:
+		userId, err := uuid.Parse(parameters.UserId)
+		if err != nil {
+			badRequest("user_id is needed for this action")
+			return
+		}
+		if userId == curUserId {
+			badRequest("a moderator can't suspend themselves")
+			return
+		}
+		targetUser, err := queries.GetUserById(r.Context(), userId)
+		if err != nil {
+			w.WriteHeader(404)
+			return
+		}
+		if auth.Role(targetUser.Role) != auth.RoleUser { // moderators can't suspend each other, only the admins who manage roles can
+			moderator, err := queries.GetUserById(r.Context(), curUserId)
+			if err != nil || !auth.Role(moderator.Role).Can(auth.PermManageRoles) {
+				w.WriteHeader(403)
+				return
+			}
+		}
+		if parameters.Action == actionSuspendUser {
+			_, err = queries.SuspendUser(r.Context(), userId)
+			if err == nil { // logged in sessions can't be refreshed anymore, the access tokens are refused by middlewareCheckAuth
+				err = queries.RevokeUserRefreshTokens(r.Context(), userId)
+			}
+			resolved.ReportedUserID = uuid.NullUUID{UUID: userId, Valid: true}
+		} else {
+			_, err = queries.UnsuspendUser(r.Context(), userId)
+		}
+		if err != nil {
+			log.Printf("error when moderating the user: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		action.UserID = uuid.NullUUID{UUID: userId, Valid: true}
+	default:
+		badRequest("action should be one of dismiss, publish_chirp, hide_chirp, delete_chirp, suspend_user or unsuspend_user")
+		return
+	}
+
+	createdAction, err := queries.CreateModerationAction(r.Context(), action)
+	if err != nil {
+		log.Printf("error when recording the moderation action: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	resolved.ActionID = uuid.NullUUID{UUID: createdAction.ID, Valid: true}
+	resolvedReports, err := queries.ResolveReports(r.Context(), resolved)
+	if err != nil {
+		log.Printf("error when resolving the reports: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error when committing the moderation action: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	response := newModerationActionResponse(createdAction)
+	response.ResolvedReports = resolvedReports
+	jsonAction, err := json.Marshal(&response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(jsonAction)
+}
+
+func handlerListModerationActions(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	limit, err := parsePageLimit(r.URL.Query())
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[chirpCursor](r.URL.Query().Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.ListModerationActionsParams{RowLimit: int32(limit + 1)}
+	if cursor != nil {
+		params.BeforeCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	actions, err := cfg.dbQueries.ListModerationActions(r.Context(), params)
+	if err != nil {
+		log.Printf("error when listing the moderation actions: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := moderationActionsPage{}
+	if len(actions) > limit {
+		actions = actions[:limit]
+		lastAction := actions[limit-1]
+		page.NextCursor, err = encodeCursor(chirpCursor{CreatedAt: lastAction.CreatedAt, Id: lastAction.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	page.Actions = make([]moderationActionResponse, len(actions))
+	for i, action := range actions {
+		page.Actions[i] = newModerationActionResponse(action)
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
@@ -0,0 +1,720 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	oauthCodeTTL     = 5 * time.Minute
+	oauthTokenClient = "oauth" // the kind of client to configure in TOKEN_CLIENT_TTLS for the tokens of the OAuth clients
+)
+
+// the scopes an application can be granted, the credentials of the user (their email and their password) are never
+// handed to one
+var scopeDescriptions = map[auth.Scope]string{
+	auth.ScopeChirpsRead:  "See your timeline and your held chirps",
+	auth.ScopeChirpsWrite: "Post, edit and delete chirps for you",
+	auth.ScopeSocialWrite: "Like, rechirp, follow and report for you",
+}
+
+// the parameters of the authorization request the consent form sends back
+var authorizationParams = []string{"client_id", "redirect_uri", "response_type", "scope", "state", "code_challenge", "code_challenge_method"}
+
+var consentTemplate = template.Must(template.New("consent").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>Authorize {{.ClientName}} - Chirpy</title>
+</head>
+<body>
+  <h1>{{.ClientName}} wants to access your Chirpy account</h1>
+  <p>If you allow it, it will be able to:</p>
+  <ul>
+    {{range .Scopes}}<li>{{.}}</li>
+    {{end}}
+  </ul>
+  <p>You will then be sent back to {{.RedirectHost}}.</p>
+  {{if .Problem}}<p role="alert">{{.Problem}}</p>{{end}}
+  <form method="POST" action="/oauth/authorize">
+    {{range .Fields}}<input type="hidden" name="{{.Name}}" value="{{.Value}}">
+    {{end}}
+    <label>Email <input type="email" name="email" value="{{.Email}}" required></label>
+    <label>Password <input type="password" name="password" required></label>
+    <label>Two-factor code, if you enabled it <input type="text" name="code" autocomplete="one-time-code"></label>
+    <button type="submit" name="decision" value="allow">Allow</button>
+    <button type="submit" name="decision" value="deny" formnovalidate>Deny</button>
+  </form>
+</body>
+</html>
+`))
+
+// oauthError is an error of RFC 6749, sent back in the redirect of the authorization request or in the body of the
+// token endpoint
+type oauthError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func (e *oauthError) Error() string {
+	return fmt.Sprintf("%v: %v", e.Code, e.Description)
+}
+
+type authorizationRequest struct {
+	client        database.OauthClient
+	redirectUri   string // empty until the redirect URI is known to belong to the client
+	scopes        []string
+	state         string
+	codeChallenge string
+}
+
+// parseAuthorizationRequest checks the parameters of an authorization request. While the client and the redirect URI
+// are not known to be valid, the request has no redirect URI and its error can only be shown to the user
+func (cfg *ApiConfig) parseAuthorizationRequest(ctx context.Context, values url.Values) (authorizationRequest, error) {
+	var request authorizationRequest
+	clientId, err := uuid.Parse(values.Get("client_id"))
+	if err != nil {
+		return request, &oauthError{Code: "invalid_request", Description: "unknown client_id"}
+	}
+	request.client, err = cfg.dbQueries.GetOAuthClient(ctx, clientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return request, &oauthError{Code: "invalid_request", Description: "unknown client_id"}
+	}
+	if err != nil {
+		return request, err
+	}
+	// an exact match, a prefix would let an open redirect of the client's site steal the codes
+	redirectUri := values.Get("redirect_uri")
+	if !slices.Contains(request.client.RedirectUris, redirectUri) {
+		return request, &oauthError{Code: "invalid_request", Description: "the redirect_uri is not registered for this client"}
+	}
+	request.redirectUri = redirectUri
+	request.state = values.Get("state")
+	if values.Get("response_type") != "code" {
+		return request, &oauthError{Code: "unsupported_response_type", Description: "only the code response type is supported"}
+	}
+	request.codeChallenge = values.Get("code_challenge")
+	if request.codeChallenge == "" || values.Get("code_challenge_method") != auth.PKCEMethodS256 {
+		return request, &oauthError{Code: "invalid_request", Description: "a code_challenge with the S256 code_challenge_method is required"}
+	}
+	scopes, err := auth.ParseScopeParam(values.Get("scope"))
+	if err != nil {
+		return request, &oauthError{Code: "invalid_scope", Description: err.Error()}
+	}
+	if scopes == nil {
+		// a client registered before a scope stopped being grantable keeps it in its list, it is left out
+		for _, scope := range request.client.Scopes {
+			if _, ok := scopeDescriptions[auth.Scope(scope)]; ok {
+				request.scopes = append(request.scopes, scope)
+			}
+		}
+		if len(request.scopes) == 0 {
+			return request, &oauthError{Code: "invalid_scope", Description: "the client has no scope it can ask for"}
+		}
+		return request, nil
+	}
+	for _, scope := range scopes {
+		if _, ok := scopeDescriptions[scope]; !ok || !auth.HasScope(request.client.Scopes, scope) {
+			return request, &oauthError{Code: "invalid_scope", Description: fmt.Sprintf("the client can't ask for the '%v' scope", scope)}
+		}
+		request.scopes = append(request.scopes, string(scope))
+	}
+	return request, nil
+}
+
+// redirectAuthorization sends the user back to the client with the code or the error
+func redirectAuthorization(w http.ResponseWriter, r *http.Request, request authorizationRequest, params url.Values) {
+	redirectUrl, err := url.Parse(request.redirectUri)
+	if err != nil {
+		log.Printf("error when parsing the redirect URI of the OAuth client %v: %v", request.client.ID, err)
+		w.WriteHeader(500)
+		return
+	}
+	if request.state != "" {
+		params.Set("state", request.state)
+	}
+	query := redirectUrl.Query()
+	for name, values := range params {
+		query[name] = values
+	}
+	redirectUrl.RawQuery = query.Encode()
+	http.Redirect(w, r, redirectUrl.String(), http.StatusSeeOther)
+}
+
+func authorizationRequestFailed(w http.ResponseWriter, r *http.Request, request authorizationRequest, err error) {
+	var requestErr *oauthError
+	if !errors.As(err, &requestErr) {
+		log.Printf("error when reading the authorization request: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if request.redirectUri == "" {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(requestErr.Description))
+		return
+	}
+	redirectAuthorization(w, r, request, url.Values{"error": {requestErr.Code}, "error_description": {requestErr.Description}})
+}
+
+// renderConsentPage shows what the client asks for along with a login form, the page can't be framed so another site
+// can't trick the user into clicking "Allow"
+func renderConsentPage(w http.ResponseWriter, status int, request authorizationRequest, email, problem string) {
+	type hiddenField struct {
+		Name  string
+		Value string
+	}
+	type consentPage struct {
+		ClientName   string
+		Scopes       []string
+		RedirectHost string
+		Fields       []hiddenField
+		Email        string
+		Problem      string
+	}
+	values := map[string]string{
+		"client_id":             request.client.ID.String(),
+		"redirect_uri":          request.redirectUri,
+		"response_type":         "code",
+		"scope":                 strings.Join(request.scopes, " "),
+		"state":                 request.state,
+		"code_challenge":        request.codeChallenge,
+		"code_challenge_method": auth.PKCEMethodS256,
+	}
+	page := consentPage{ClientName: request.client.Name, Email: email, Problem: problem}
+	for _, scope := range request.scopes {
+		page.Scopes = append(page.Scopes, scopeDescriptions[auth.Scope(scope)])
+	}
+	if redirectUrl, err := url.Parse(request.redirectUri); err == nil {
+		page.RedirectHost = redirectUrl.Host
+	}
+	for _, name := range authorizationParams {
+		page.Fields = append(page.Fields, hiddenField{Name: name, Value: values[name]})
+	}
+	var html bytes.Buffer
+	err := consentTemplate.Execute(&html, page)
+	if err != nil {
+		log.Printf("error when rendering the consent page: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	header := w.Header()
+	header.Add("Content-Type", "text/html; charset=utf-8")
+	header.Add("Cache-Control", "no-store")
+	header.Add("X-Frame-Options", "DENY")
+	header.Add("Content-Security-Policy", "frame-ancestors 'none'")
+	w.WriteHeader(status)
+	w.Write(html.Bytes())
+}
+
+func (cfg *ApiConfig) handleOAuthAuthorize(w http.ResponseWriter, r *http.Request) {
+	request, err := cfg.parseAuthorizationRequest(r.Context(), r.URL.Query())
+	if err != nil {
+		authorizationRequestFailed(w, r, request, err)
+		return
+	}
+	renderConsentPage(w, 200, request, "", "")
+}
+
+// handleOAuthConsent logs the user in with the consent form and sends them back to the client with a code
+func (cfg *ApiConfig) handleOAuthConsent(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	request, err := cfg.parseAuthorizationRequest(r.Context(), r.PostForm)
+	if err != nil {
+		authorizationRequestFailed(w, r, request, err)
+		return
+	}
+	if r.PostForm.Get("decision") != "allow" {
+		redirectAuthorization(w, r, request, url.Values{"error": {"access_denied"}, "error_description": {"the user denied the access"}})
+		return
+	}
+	user, status, problem, err := cfg.consentLogin(r.Context(), r.PostForm, clientIp(r))
+	if err != nil {
+		log.Printf("error when logging in on the consent page: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if problem != "" {
+		renderConsentPage(w, status, request, r.PostForm.Get("email"), problem)
+		return
+	}
+	code, err := auth.MakeRefreshToken()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.dbQueries.CreateOAuthAuthorizationCode(r.Context(), database.CreateOAuthAuthorizationCodeParams{
+		CodeHash:      auth.HashRefreshToken(code),
+		ClientID:      request.client.ID,
+		UserID:        user.ID,
+		RedirectUri:   request.redirectUri,
+		Scopes:        request.scopes,
+		CodeChallenge: request.codeChallenge,
+		ExpiresAt:     time.Now().Add(oauthCodeTTL),
+		FamilyID:      uuid.New(),
+	})
+	if err != nil {
+		log.Printf("error when creating the authorization code: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	redirectAuthorization(w, r, request, url.Values{"code": {code}})
+}
+
+// consentLogin checks the credentials of the consent form like POST /api/login and POST /api/login/2fa do. A refused
+// login gives the status and the problem to show on the page
+func (cfg *ApiConfig) consentLogin(ctx context.Context, form url.Values, ip string) (user database.User, status int, problem string, err error) {
+	user, refusal, err := cfg.authenticateUser(ctx, ip, loginCredentials{
+		email:    form.Get("email"),
+		password: form.Get("password"),
+		code:     form.Get("code"),
+	})
+	if err != nil {
+		return database.User{}, 0, "", err
+	}
+	if refusal != nil {
+		return database.User{}, refusal.status, refusal.message, nil
+	}
+	return user, 0, "", nil
+}
+
+// oauthFailed writes the error of RFC 6749 section 5.2 for the token, revocation and introspection endpoints
+func oauthFailed(w http.ResponseWriter, err error) {
+	var requestErr *oauthError
+	if !errors.As(err, &requestErr) {
+		log.Printf("error when handling the OAuth request: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonError, err := json.Marshal(requestErr)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header := w.Header()
+	header.Add("Content-Type", "application/json")
+	header.Add("Cache-Control", "no-store")
+	status := 400
+	if requestErr.Code == "invalid_client" {
+		header.Add("WWW-Authenticate", `Basic realm="chirpy"`)
+		status = 401
+	}
+	w.WriteHeader(status)
+	w.Write(jsonError)
+}
+
+// authenticateOAuthClient reads the client from HTTP Basic authentication or from the client_id and client_secret
+// of the form. A public client only gives its id
+func (cfg *ApiConfig) authenticateOAuthClient(r *http.Request) (database.OauthClient, error) {
+	invalidClient := &oauthError{Code: "invalid_client", Description: "unknown client or wrong client secret"}
+	rawClientId, secret, hasBasicAuth := r.BasicAuth()
+	if hasBasicAuth {
+		// the credentials are form encoded before being put in the header (RFC 6749 section 2.3.1)
+		rawClientId, _ = url.QueryUnescape(rawClientId)
+		secret, _ = url.QueryUnescape(secret)
+	} else {
+		rawClientId, secret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	clientId, err := uuid.Parse(rawClientId)
+	if err != nil {
+		return database.OauthClient{}, invalidClient
+	}
+	client, err := cfg.dbQueries.GetOAuthClient(r.Context(), clientId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return database.OauthClient{}, invalidClient
+	}
+	if err != nil {
+		return database.OauthClient{}, err
+	}
+	if !client.SecretHash.Valid {
+		if secret != "" {
+			return database.OauthClient{}, invalidClient
+		}
+		return client, nil
+	}
+	if subtle.ConstantTimeCompare([]byte(auth.HashRefreshToken(secret)), []byte(client.SecretHash.String)) != 1 {
+		return database.OauthClient{}, invalidClient
+	}
+	return client, nil
+}
+
+// oauthLifetimes are the lifetimes configured for the "oauth" kind of client, or the defaults
+func (cfg *ApiConfig) oauthLifetimes() auth.TokenLifetimes {
+	lifetimes, err := cfg.tokenPolicy.Lifetimes(oauthTokenClient, auth.TokenLifetimes{})
+	if err != nil {
+		lifetimes, _ = cfg.tokenPolicy.Lifetimes("", auth.TokenLifetimes{})
+	}
+	return lifetimes
+}
+
+func (cfg *ApiConfig) handleOAuthToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		oauthFailed(w, &oauthError{Code: "invalid_request", Description: "a form encoded body is expected"})
+		return
+	}
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	switch r.PostForm.Get("grant_type") {
+	case "authorization_code":
+		cfg.exchangeAuthorizationCode(w, r, client)
+	case "refresh_token":
+		cfg.refreshOAuthToken(w, r, client)
+	default:
+		oauthFailed(w, &oauthError{Code: "unsupported_grant_type", Description: "the grant_type should be authorization_code or refresh_token"})
+	}
+}
+
+// exchangeAuthorizationCode starts a token family for the client. A code is only good once: when it comes back, it
+// was stolen, so the tokens it was exchanged for are revoked (RFC 6749 section 4.1.2)
+func (cfg *ApiConfig) exchangeAuthorizationCode(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "the code is invalid, expired or was issued to another client"}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	code, err := queries.GetOAuthAuthorizationCodeForUpdate(r.Context(), auth.HashRefreshToken(r.PostForm.Get("code")))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && code.ClientID != client.ID {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	if code.UsedAt.Valid {
+		log.Printf("authorization code reuse detected, revoking the family %v of user %v", code.FamilyID, code.UserID)
+		err = queries.RevokeRefreshTokenFamily(r.Context(), code.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			oauthFailed(w, err)
+			return
+		}
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	if time.Now().After(code.ExpiresAt) || r.PostForm.Get("redirect_uri") != code.RedirectUri {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	err = auth.VerifyPKCE(r.PostForm.Get("code_verifier"), code.CodeChallenge)
+	if err != nil {
+		oauthFailed(w, &oauthError{Code: "invalid_grant", Description: err.Error()})
+		return
+	}
+	user, err := queries.GetUserById(r.Context(), code.UserID)
+	if err != nil || user.SuspendedAt.Valid {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	err = queries.UseOAuthAuthorizationCode(r.Context(), code.CodeHash)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	lifetimes := cfg.oauthLifetimes()
+	// the family shows up in the sessions of the user under the name of the client, where it can be revoked
+	refreshToken, err := issueRefreshToken(r.Context(), queries, r, database.CreateRefreshTokenParams{
+		UserID:        user.ID,
+		ExpiresAt:     time.Now().Add(lifetimes.Refresh),
+		FamilyID:      code.FamilyID,
+		DeviceLabel:   client.Name,
+		Client:        oauthTokenClient,
+		OauthClientID: uuid.NullUUID{UUID: client.ID, Valid: true},
+		Scopes:        code.Scopes,
+	})
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	cfg.sendOAuthTokens(w, tx, client, user.ID, code.FamilyID, code.Scopes, refreshToken, lifetimes.Access)
+}
+
+// refreshOAuthToken rotates the refresh token like POST /api/refresh does. The client can ask for an access token
+// with fewer scopes, the refresh token keeps the ones the user consented to
+func (cfg *ApiConfig) refreshOAuthToken(w http.ResponseWriter, r *http.Request, client database.OauthClient) {
+	invalidGrant := &oauthError{Code: "invalid_grant", Description: "the refresh token is invalid, expired or was issued to another client"}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	refreshToken, err := queries.GetRefreshTokenByHashForUpdate(r.Context(), auth.HashRefreshToken(r.PostForm.Get("refresh_token")))
+	if errors.Is(err, sql.ErrNoRows) || err == nil && refreshToken.OauthClientID.UUID != client.ID {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	if refreshToken.RevokedAt.Valid {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	if refreshToken.RotatedAt.Valid {
+		log.Printf("refresh token reuse detected, revoking the family %v of user %v", refreshToken.FamilyID, refreshToken.UserID)
+		err = queries.RevokeRefreshTokenFamily(r.Context(), refreshToken.FamilyID)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			oauthFailed(w, err)
+			return
+		}
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	if time.Now().After(refreshToken.ExpiresAt) {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	user, err := queries.GetUserById(r.Context(), refreshToken.UserID)
+	if err != nil || user.SuspendedAt.Valid {
+		oauthFailed(w, invalidGrant)
+		return
+	}
+	scopes := refreshToken.Scopes
+	requestedScopes, err := auth.ParseScopeParam(r.PostForm.Get("scope"))
+	if err != nil {
+		oauthFailed(w, &oauthError{Code: "invalid_scope", Description: err.Error()})
+		return
+	}
+	if requestedScopes != nil {
+		scopes = nil
+		for _, scope := range requestedScopes {
+			if !auth.HasScope(refreshToken.Scopes, scope) {
+				oauthFailed(w, &oauthError{Code: "invalid_scope", Description: fmt.Sprintf("the '%v' scope was not granted", scope)})
+				return
+			}
+			scopes = append(scopes, string(scope))
+		}
+	}
+	err = queries.RotateRefreshToken(r.Context(), refreshToken.TokenHash)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	newRefreshToken, err := issueRefreshToken(r.Context(), queries, r, database.CreateRefreshTokenParams{
+		UserID:        user.ID,
+		ExpiresAt:     refreshToken.ExpiresAt,
+		FamilyID:      refreshToken.FamilyID,
+		DeviceLabel:   refreshToken.DeviceLabel,
+		Client:        refreshToken.Client,
+		OauthClientID: refreshToken.OauthClientID,
+		Scopes:        refreshToken.Scopes,
+	})
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	cfg.sendOAuthTokens(w, tx, client, user.ID, refreshToken.FamilyID, scopes, newRefreshToken, cfg.oauthLifetimes().Access)
+}
+
+// sendOAuthTokens signs the access token of the family and commits the transaction that issued the refresh token.
+// The access token carries no role, the routes that need one are not open to the clients
+func (cfg *ApiConfig) sendOAuthTokens(w http.ResponseWriter, tx *sql.Tx, client database.OauthClient, userId, familyId uuid.UUID, scopes []string, refreshToken string, accessTTL time.Duration) {
+	type oauthTokenResponse struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int    `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+		Scope        string `json:"scope"`
+	}
+	accessToken, err := auth.MakeJWT(userId, cfg.keyring, accessTTL, auth.WithSessionId(familyId), auth.WithClient(client.ID, scopes))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	jsonTokens, err := json.Marshal(oauthTokenResponse{
+		AccessToken:  accessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int(accessTTL.Seconds()),
+		RefreshToken: refreshToken,
+		Scope:        strings.Join(scopes, " "),
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header := w.Header()
+	header.Add("Content-Type", "application/json")
+	header.Add("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(jsonTokens)
+}
+
+// oauthTokenInfo describes an access token or a refresh token issued to a client
+type oauthTokenInfo struct {
+	active    bool
+	tokenType string
+	familyId  uuid.UUID
+	userId    uuid.UUID
+	scope     string
+	issuedAt  time.Time
+	expiresAt time.Time
+}
+
+// findClientToken looks the token up among the refresh tokens, then reads it as an access token. The tokens of the
+// other clients are not found, a client can't learn anything about them
+func (cfg *ApiConfig) findClientToken(ctx context.Context, client database.OauthClient, token string) (oauthTokenInfo, bool, error) {
+	refreshToken, err := cfg.dbQueries.GetRefreshTokenByHash(ctx, auth.HashRefreshToken(token))
+	if err == nil {
+		if refreshToken.OauthClientID.UUID != client.ID {
+			return oauthTokenInfo{}, false, nil
+		}
+		return oauthTokenInfo{
+			active:    !refreshToken.RevokedAt.Valid && !refreshToken.RotatedAt.Valid && time.Now().Before(refreshToken.ExpiresAt),
+			tokenType: "refresh_token",
+			familyId:  refreshToken.FamilyID,
+			userId:    refreshToken.UserID,
+			scope:     strings.Join(refreshToken.Scopes, " "),
+			issuedAt:  refreshToken.CreatedAt,
+			expiresAt: refreshToken.ExpiresAt,
+		}, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return oauthTokenInfo{}, false, err
+	}
+	claims, err := auth.ParseJWT(token, cfg.keyring)
+	if err != nil || claims.ClientId != client.ID.String() {
+		return oauthTokenInfo{}, false, nil
+	}
+	familyId, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		return oauthTokenInfo{}, false, nil
+	}
+	userId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return oauthTokenInfo{}, false, nil
+	}
+	active, err := cfg.dbQueries.IsSessionActive(ctx, familyId)
+	if err != nil {
+		return oauthTokenInfo{}, false, err
+	}
+	return oauthTokenInfo{
+		active:    active,
+		tokenType: "access_token",
+		familyId:  familyId,
+		userId:    userId,
+		scope:     claims.Scope,
+		issuedAt:  claims.IssuedAt.Time,
+		expiresAt: claims.ExpiresAt.Time,
+	}, true, nil
+}
+
+// handleOAuthRevoke revokes the whole family of the token (RFC 7009). An unknown token is answered like a revoked
+// one, the client only needs to know it can forget it
+func (cfg *ApiConfig) handleOAuthRevoke(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		oauthFailed(w, &oauthError{Code: "invalid_request", Description: "a form encoded body is expected"})
+		return
+	}
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	tokenInfo, found, err := cfg.findClientToken(r.Context(), client, r.PostForm.Get("token"))
+	if err == nil && found {
+		err = cfg.dbQueries.RevokeRefreshTokenFamily(r.Context(), tokenInfo.familyId)
+	}
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	w.WriteHeader(200)
+}
+
+// handleOAuthIntrospect tells a confidential client whether one of its tokens is active (RFC 7662)
+func (cfg *ApiConfig) handleOAuthIntrospect(w http.ResponseWriter, r *http.Request) {
+	type introspectionResponse struct {
+		Active    bool   `json:"active"`
+		Scope     string `json:"scope,omitempty"`
+		ClientId  string `json:"client_id,omitempty"`
+		Subject   string `json:"sub,omitempty"`
+		TokenType string `json:"token_type,omitempty"`
+		IssuedAt  int64  `json:"iat,omitempty"`
+		ExpiresAt int64  `json:"exp,omitempty"`
+	}
+	err := r.ParseForm()
+	if err != nil {
+		oauthFailed(w, &oauthError{Code: "invalid_request", Description: "a form encoded body is expected"})
+		return
+	}
+	client, err := cfg.authenticateOAuthClient(r)
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	if !client.SecretHash.Valid {
+		oauthFailed(w, &oauthError{Code: "unauthorized_client", Description: "only a confidential client can introspect its tokens"})
+		return
+	}
+	tokenInfo, found, err := cfg.findClientToken(r.Context(), client, r.PostForm.Get("token"))
+	if err != nil {
+		oauthFailed(w, err)
+		return
+	}
+	response := introspectionResponse{}
+	if found && tokenInfo.active {
+		user, err := cfg.dbQueries.GetUserById(r.Context(), tokenInfo.userId)
+		if err == nil && !user.SuspendedAt.Valid {
+			response = introspectionResponse{
+				Active:    true,
+				Scope:     tokenInfo.scope,
+				ClientId:  client.ID.String(),
+				Subject:   tokenInfo.userId.String(),
+				TokenType: tokenInfo.tokenType,
+				IssuedAt:  tokenInfo.issuedAt.Unix(),
+				ExpiresAt: tokenInfo.expiresAt.Unix(),
+			}
+		}
+	}
+	jsonResponse, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header := w.Header()
+	header.Add("Content-Type", "application/json")
+	header.Add("Cache-Control", "no-store")
+	w.WriteHeader(200)
+	w.Write(jsonResponse)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	maxOAuthClientNameLength = 100
+	maxOAuthRedirectUris     = 10
+)
+
+type oauthClientResponse struct {
+	Id           uuid.UUID `json:"client_id"`
+	Name         string    `json:"name"`
+	RedirectUris []string  `json:"redirect_uris"`
+	Scopes       []string  `json:"scopes"`
+	Public       bool      `json:"public"`
+	CreatedAt    time.Time `json:"created_at"`
+	Secret       string    `json:"client_secret,omitempty"` // only given when the client is registered
+}
+
+func newOAuthClientResponse(client database.OauthClient) oauthClientResponse {
+	return oauthClientResponse{
+		Id:           client.ID,
+		Name:         client.Name,
+		RedirectUris: client.RedirectUris,
+		Scopes:       client.Scopes,
+		Public:       !client.SecretHash.Valid,
+		CreatedAt:    client.CreatedAt,
+	}
+}
+
+// handlerCreateOAuthClient registers an application of the user, the scopes are the most it can ask the users for.
+// A public client (a single page or mobile app) can't keep a secret, so it gets none
+func handlerCreateOAuthClient(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type createOAuthClientParams struct {
+		Name         string   `json:"name"`
+		RedirectUris []string `json:"redirect_uris"`
+		Scopes       []string `json:"scopes"`
+		Public       bool     `json:"public"`
+	}
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	parameters, err := unmarshalRequestBody[createOAuthClientParams](w, r)
+	if err != nil {
+		return
+	}
+	name := strings.TrimSpace(parameters.Name)
+	if name == "" || len(name) > maxOAuthClientNameLength {
+		badRequest("a name of at most 100 characters is expected")
+		return
+	}
+	if len(parameters.RedirectUris) == 0 || len(parameters.RedirectUris) > maxOAuthRedirectUris {
+		badRequest("between 1 and 10 redirect URIs are expected")
+		return
+	}
+	for _, redirectUri := range parameters.RedirectUris {
+		if err := auth.ValidateRedirectURI(redirectUri); err != nil {
+			badRequest(err.Error())
+			return
+		}
+	}
+	scopes, err := auth.ParseScopes(parameters.Scopes)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	for _, scope := range scopes {
+		if _, ok := scopeDescriptions[scope]; !ok {
+			badRequest(fmt.Sprintf("an application can't be granted the '%v' scope", scope))
+			return
+		}
+	}
+	var secret string
+	var secretHash sql.NullString
+	if !parameters.Public {
+		secret, err = auth.MakeRefreshToken()
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+		secretHash = sql.NullString{String: auth.HashRefreshToken(secret), Valid: true}
+	}
+	grantedScopes := make([]string, len(scopes))
+	for i, scope := range scopes {
+		grantedScopes[i] = string(scope)
+	}
+	client, err := cfg.dbQueries.CreateOAuthClient(r.Context(), database.CreateOAuthClientParams{
+		OwnerID:      curUserId,
+		Name:         name,
+		SecretHash:   secretHash,
+		RedirectUris: parameters.RedirectUris,
+		Scopes:       grantedScopes,
+	})
+	if err != nil {
+		log.Printf("error when registering the OAuth client: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := newOAuthClientResponse(client)
+	response.Secret = secret
+	jsonClient, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(jsonClient)
+}
+
+func handlerListOAuthClients(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	clients, err := cfg.dbQueries.ListUserOAuthClients(r.Context(), curUserId)
+	if err != nil {
+		log.Printf("error when listing the OAuth clients: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := make([]oauthClientResponse, len(clients))
+	for i, client := range clients {
+		response[i] = newOAuthClientResponse(client)
+	}
+	jsonClients, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonClients)
+}
+
+// deleting a client also deletes its codes and refresh tokens, so the sessions of all its users end
+func handlerDeleteOAuthClient(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	clientId, err := uuid.Parse(r.PathValue("clientId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	deleted, err := cfg.dbQueries.DeleteOAuthClient(r.Context(), database.DeleteOAuthClientParams{ID: clientId, OwnerID: curUserId})
+	if err != nil {
+		log.Printf("error when deleting the OAuth client: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
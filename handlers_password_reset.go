@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/mailer"
+)
+
+const (
+	passwordResetTTL = time.Hour
+	emailSendTimeout = 30 * time.Second
+)
+
+// the answer is the same whether the email belongs to a user or not, and the email is sent after answering so
+// the response time doesn't tell either
+func (cfg *ApiConfig) handleForgotPassword(w http.ResponseWriter, r *http.Request) {
+	type forgotPasswordParams struct {
+		Email string `json:"email"`
+	}
+	parameters, err := unmarshalRequestBody[forgotPasswordParams](w, r)
+	if err != nil {
+		return
+	}
+	email := strings.TrimSpace(parameters.Email)
+	if email == "" {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("an email is expected"))
+		return
+	}
+	// every request counts, whether the email belongs to an account or not, so nobody can flood an inbox with them
+	attempt, blockedFor, err := cfg.beginThrottledAttempt(r.Context(), email, []loginThrottleSubject{
+		{kind: throttleResetEmail, subject: accountThrottleSubject(email)},
+		{kind: throttleResetIp, subject: clientIp(r)},
+	})
+	if err != nil {
+		log.Printf("error when throttling the password reset request: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if blockedFor > 0 {
+		tooManyAttempts(w, blockedFor, "too many password reset requests, try again later")
+		return
+	}
+	err = attempt.settle(r.Context(), loginFailed, false)
+	if err == nil {
+		// the event is published for any email, whether the account exists is only looked at when the email is sent
+		err = publishEvent(r.Context(), cfg.dbQueries, eventPasswordResetRequested, passwordResetEvent{Email: email})
+	}
+	if err != nil {
+		log.Printf("error when requesting the password reset email: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "text/plain")
+	w.WriteHeader(202)
+	w.Write([]byte("if this email belongs to an account, a link to reset its password was sent to it"))
+}
+
+// sendPasswordReset runs as a subscriber of the password.reset_requested events
+func (cfg *ApiConfig) sendPasswordReset(ctx context.Context, queries *database.Queries, email string) error {
+	user, err := queries.GetUserByEmail(ctx, email)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resetToken, err := auth.MakeRefreshToken()
+	if err != nil {
+		return err
+	}
+	err = queries.CreatePasswordResetToken(ctx, database.CreatePasswordResetTokenParams{
+		TokenHash: auth.HashRefreshToken(resetToken),
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(passwordResetTTL),
+	})
+	if err != nil {
+		return err
+	}
+	resetLink := fmt.Sprintf("%v/app/reset-password?token=%v", cfg.publicUrl, url.QueryEscape(resetToken))
+	return cfg.mailer.Send(ctx, mailer.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Someone asked to reset the password of your Chirpy account.\n\n"+
+			"Open this link within %v to choose a new password:\n%v\n\n"+
+			"If it wasn't you, ignore this email, your password stays the same.\n", passwordResetTTL, resetLink),
+	})
+}
+
+// a successful reset logs the user out everywhere, whoever knew the old password loses access
+func (cfg *ApiConfig) handleResetPassword(w http.ResponseWriter, r *http.Request) {
+	type resetPasswordParams struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	header := w.Header()
+	parameters, err := unmarshalRequestBody[resetPasswordParams](w, r)
+	if err != nil {
+		return
+	}
+	if parameters.Token == "" || parameters.Password == "" {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a token and a new password are expected"))
+		return
+	}
+	hashedPassword, err := auth.HashPassword(parameters.Password)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the password reset transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	userId, err := queries.UsePasswordResetToken(r.Context(), auth.HashRefreshToken(parameters.Token))
+	if errors.Is(err, sql.ErrNoRows) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("this reset link is invalid or has expired"))
+		return
+	}
+	if err == nil {
+		err = queries.UpdateUserPassword(r.Context(), database.UpdateUserPasswordParams{
+			ID:             userId,
+			HashedPassword: hashedPassword,
+		})
+	}
+	if err == nil {
+		err = queries.InvalidateUserPasswordResetTokens(r.Context(), userId)
+	}
+	if err == nil {
+		err = queries.RevokeUserRefreshTokens(r.Context(), userId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("error when resetting the password: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const maxReportDetailsLength = 500
+
+// the reasons a user can pick from when reporting, they must match the CHECK constraint of the reports table
+var reportReasons = map[string]bool{
+	"spam":           true,
+	"harassment":     true,
+	"hate_speech":    true,
+	"violence":       true,
+	"sexual_content": true,
+	"misinformation": true,
+	"self_harm":      true,
+	"impersonation":  true,
+	"other":          true,
+}
+
+type reportParams struct {
+	Reason  string `json:"reason"`
+	Details string `json:"details"`
+}
+
+type reportResponse struct {
+	Id             string     `json:"id"`
+	CreatedAt      time.Time  `json:"created_at"`
+	ReporterId     string     `json:"reporter_id"`
+	ChirpId        string     `json:"chirp_id,omitempty"`
+	ReportedUserId string     `json:"reported_user_id"`
+	Reason         string     `json:"reason"`
+	Details        string     `json:"details"`
+	ResolvedAt     *time.Time `json:"resolved_at,omitempty"`
+	ActionId       string     `json:"action_id,omitempty"`
+}
+
+func newReportResponse(report database.Report) reportResponse {
+	response := reportResponse{
+		Id:             report.ID.String(),
+		CreatedAt:      report.CreatedAt,
+		ReporterId:     report.ReporterID.String(),
+		ReportedUserId: report.ReportedUserID.String(),
+		Reason:         report.Reason,
+		Details:        report.Details,
+	}
+	if report.ChirpID.Valid {
+		response.ChirpId = report.ChirpID.UUID.String()
+	}
+	if report.ResolvedAt.Valid {
+		response.ResolvedAt = &report.ResolvedAt.Time
+	}
+	if report.ActionID.Valid {
+		response.ActionId = report.ActionID.UUID.String()
+	}
+	return response
+}
+
+func handlerReportChirp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	chirpId, err := uuid.Parse(r.PathValue("chirpId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpId)
+	if err != nil || !isChirpVisible(chirp, uuid.NullUUID{UUID: curUserId, Valid: true}) {
+		w.WriteHeader(404)
+		return
+	}
+	createReport(w, r, cfg, database.CreateReportParams{
+		ReporterID:     curUserId,
+		ChirpID:        uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		ReportedUserID: chirp.UserID,
+	})
+}
+
+func handlerReportUser(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	userId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	_, err = cfg.dbQueries.GetUserById(r.Context(), userId)
+	if err != nil {
+		log.Printf("error when getting the user to report: %v", err)
+		w.WriteHeader(404)
+		return
+	}
+	createReport(w, r, cfg, database.CreateReportParams{
+		ReporterID:     curUserId,
+		ReportedUserID: userId,
+	})
+}
+
+// the target of the report is already resolved by the caller, the reason and details come from the request body
+func createReport(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, report database.CreateReportParams) {
+	header := w.Header()
+	if report.ReporterID == report.ReportedUserID {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("a user can't report themselves"))
+		return
+	}
+	parameters, err := unmarshalRequestBody[reportParams](w, r)
+	if err != nil {
+		return
+	}
+	if !reportReasons[parameters.Reason] {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("the reason should be one of spam, harassment, hate_speech, violence, sexual_content, misinformation, self_harm, impersonation or other"))
+		return
+	}
+	if len(parameters.Details) > maxReportDetailsLength || (parameters.Reason == "other" && parameters.Details == "") {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("details are required for the 'other' reason and can't be longer than 500 characters"))
+		return
+	}
+	report.Reason = parameters.Reason
+	report.Details = parameters.Details
+	createdReport, err := cfg.dbQueries.CreateReport(r.Context(), report)
+	if errors.Is(err, sql.ErrNoRows) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(409)
+		w.Write([]byte("this has already been reported and is waiting for a moderator"))
+		return
+	}
+	if err != nil {
+		log.Printf("error when creating the report: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonReport, err := json.Marshal(newReportResponse(createdReport))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(jsonReport)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+func handlerSetUserRole(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type roleParams struct {
+		Role string `json:"role"`
+	}
+	type roleResponse struct {
+		Id   string `json:"id"`
+		Role string `json:"role"`
+	}
+	header := w.Header()
+	userId, err := uuid.Parse(r.PathValue("userId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	parameters, err := unmarshalRequestBody[roleParams](w, r)
+	if err != nil {
+		return
+	}
+	role, err := auth.ParseRole(parameters.Role)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if userId == curUserId && role != auth.RoleAdmin { // there would be no way back if the last admin demoted themselves
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("an admin can't demote themselves"))
+		return
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the role transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	updatedUser, err := queries.SetUserRole(r.Context(), database.SetUserRoleParams{
+		ID:   userId,
+		Role: string(role),
+	})
+	if err != nil {
+		log.Printf("error when setting the user role: %v", err)
+		w.WriteHeader(404)
+		return
+	}
+	err = publishEvent(r.Context(), queries, eventUserUpdated, newUserEvent(updatedUser))
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("error when saving the user role: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonUser, err := json.Marshal(roleResponse{
+		Id:   updatedUser.ID.String(),
+		Role: updatedUser.Role,
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonUser)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/search"
+	"github.com/google/uuid"
+)
+
+type searchCursor struct {
+	Rank float32   `json:"rank"`
+	Id   uuid.UUID `json:"id"`
+}
+
+type searchResult struct {
+	chirpResponse
+	Rank float32 `json:"rank"`
+}
+
+type searchPage struct {
+	Chirps     []searchResult `json:"chirps"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+func (cfg *ApiConfig) handleSearchChirps(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	tsQuery, err := search.ParseQuery(query.Get("q"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	cursor, err := decodeCursor[searchCursor](query.Get("cursor"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	params := database.SearchChirpsParams{
+		Query:    tsQuery,
+		RowLimit: int32(limit + 1),
+	}
+	if cursor != nil {
+		params.AfterRank = sql.NullFloat64{Float64: float64(cursor.Rank), Valid: true}
+		params.AfterID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	if queryAuthorId := query.Get("author_id"); queryAuthorId != "" {
+		authorId, err := uuid.Parse(queryAuthorId)
+		if err != nil {
+			badRequest("author_id is not a valid id")
+			return
+		}
+		params.AuthorID = uuid.NullUUID{UUID: authorId, Valid: true}
+	}
+	params.Since, err = parseDateParam(query.Get("since"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	params.Until, err = parseDateParam(query.Get("until"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+
+	results, err := cfg.dbQueries.SearchChirps(r.Context(), params)
+	if err != nil {
+		log.Printf("error when searching chirps: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := searchPage{Chirps: []searchResult{}}
+	if len(results) > limit {
+		results = results[:limit]
+		lastResult := results[len(results)-1]
+		page.NextCursor, err = encodeCursor(searchCursor{Rank: lastResult.Rank, Id: lastResult.Chirp.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	chirpList := make([]database.Chirp, len(results))
+	for i, result := range results {
+		chirpList[i] = result.Chirp
+	}
+	responses, err := cfg.chirpResponses(r.Context(), chirpList, cfg.optionalUserId(r))
+	if err != nil {
+		log.Printf("error when building the search response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	for i, result := range results {
+		page.Chirps = append(page.Chirps, searchResult{chirpResponse: responses[i], Rank: result.Rank})
+	}
+	jsonPage, err := json.Marshal(&page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+func parseDateParam(rawDate string) (sql.NullTime, error) { // accepts a full RFC3339 timestamp or just a day
+	if rawDate == "" {
+		return sql.NullTime{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, time.DateOnly} {
+		date, err := time.Parse(layout, rawDate)
+		if err == nil {
+			return sql.NullTime{Time: date.UTC(), Valid: true}, nil
+		}
+	}
+	return sql.NullTime{}, fmt.Errorf("'%v' is not a valid date, use RFC3339 or YYYY-MM-DD", rawDate)
+}
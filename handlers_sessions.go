@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type sessionResponse struct {
+	Id         string    `json:"id"`
+	Device     string    `json:"device"`
+	UserAgent  string    `json:"user_agent"`
+	IpAddress  string    `json:"ip_address"`
+	StartedAt  time.Time `json:"started_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"`
+}
+
+// clientIp is the address of the peer, the proxies in front of the server are not trusted to forward the real one
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func handlerListSessions(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	sessions, err := cfg.dbQueries.ListUserSessions(r.Context(), curUserId)
+	if err != nil {
+		log.Printf("error when listing the sessions: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	currentSessionId := sessionIdFromContext(r.Context())
+	response := make([]sessionResponse, len(sessions))
+	for i, session := range sessions {
+		response[i] = sessionResponse{
+			Id:         session.FamilyID.String(),
+			Device:     session.DeviceLabel,
+			UserAgent:  session.UserAgent,
+			IpAddress:  session.IpAddress,
+			StartedAt:  session.StartedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    currentSessionId.Valid && currentSessionId.UUID == session.FamilyID,
+		}
+	}
+	jsonSessions, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonSessions)
+}
+
+func handlerRevokeSession(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	sessionId, err := uuid.Parse(r.PathValue("sessionId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	revoked, err := cfg.dbQueries.RevokeUserSession(r.Context(), database.RevokeUserSessionParams{
+		UserID:   curUserId,
+		FamilyID: sessionId,
+	})
+	if err != nil {
+		log.Printf("error when revoking the session: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if revoked == 0 { // someone else's session or one that is already over
+		w.WriteHeader(404)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// handlerRevokeOtherSessions logs the user out everywhere but on the device making the request
+func handlerRevokeOtherSessions(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	currentSessionId := sessionIdFromContext(r.Context())
+	if !currentSessionId.Valid {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("this access token doesn't belong to a session, log in again first"))
+		return
+	}
+	_, err := cfg.dbQueries.RevokeOtherUserSessions(r.Context(), database.RevokeOtherUserSessionsParams{
+		UserID:   curUserId,
+		FamilyID: currentSessionId.UUID,
+	})
+	if err != nil {
+		log.Printf("error when revoking the other sessions: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+)
+
+const (
+	jwtIssuer = "chirpy"
+	// a key keeps validating the tokens it signed for a while after it stops signing, as long as an access token can live
+	signingKeyGracePeriod = auth.MaxAccessTokenLifetime
+	// the successor of a key is created (and published in the JWKS) this long before it starts signing,
+	// so the services caching our JWKS have it before they see a token signed with it
+	signingKeyPublishLead = 24 * time.Hour
+	signingKeyCheckPeriod = time.Hour
+)
+
+// newKeyring reads JWT_SIGNING_ALG, JWT_KEY_ROTATION and JWT_AUDIENCE, then loads the signing keys, creating the
+// first one when the database has none
+func newKeyring(cfg *ApiConfig) (*auth.Keyring, error) {
+	cfg.signingAlgorithm = auth.AlgEdDSA
+	if algorithm := os.Getenv("JWT_SIGNING_ALG"); algorithm != "" {
+		if algorithm != auth.AlgEdDSA && algorithm != auth.AlgRS256 {
+			return nil, fmt.Errorf("JWT_SIGNING_ALG should be EdDSA or RS256, not '%v'", algorithm)
+		}
+		cfg.signingAlgorithm = algorithm
+	}
+	cfg.keyRotationPeriod = 30 * 24 * time.Hour
+	if rawRotation := os.Getenv("JWT_KEY_ROTATION"); rawRotation != "" {
+		rotation, err := time.ParseDuration(rawRotation)
+		if err != nil {
+			return nil, fmt.Errorf("JWT_KEY_ROTATION should be a duration like '720h': %w", err)
+		}
+		if rotation < 2*signingKeyPublishLead {
+			return nil, fmt.Errorf("JWT_KEY_ROTATION should be at least %v", 2*signingKeyPublishLead)
+		}
+		cfg.keyRotationPeriod = rotation
+	}
+	audience := os.Getenv("JWT_AUDIENCE")
+	if audience == "" {
+		audience = "chirpy"
+	}
+	cfg.keyring = auth.NewKeyring(jwtIssuer, audience)
+	err := cfg.rotateSigningKeys(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	return cfg.keyring, nil
+}
+
+// rotateSigningKeys creates the successor of the newest key when it stops signing soon, then reloads the keyring from
+// the database, which also picks up the keys created by the other instances. Two instances rotating at the same time
+// both create a key, they are both published and valid so it does no harm
+func (cfg *ApiConfig) rotateSigningKeys(ctx context.Context) error {
+	dbKeys, err := cfg.dbQueries.ListSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if len(dbKeys) == 0 || dbKeys[0].SignsUntil.Before(now.Add(signingKeyPublishLead)) {
+		signsFrom := now
+		if len(dbKeys) > 0 && dbKeys[0].SignsUntil.After(now) {
+			signsFrom = dbKeys[0].SignsUntil
+		}
+		signsUntil := signsFrom.Add(cfg.keyRotationPeriod)
+		key, err := auth.GenerateSigningKey(cfg.signingAlgorithm, signsFrom, signsUntil, signsUntil.Add(signingKeyGracePeriod))
+		if err != nil {
+			return err
+		}
+		encodedKey, err := auth.EncodePrivateKey(key.PrivateKey)
+		if err != nil {
+			return err
+		}
+		err = cfg.dbQueries.CreateSigningKey(ctx, database.CreateSigningKeyParams{
+			ID:         key.Id,
+			Algorithm:  key.Algorithm,
+			PrivateKey: encodedKey,
+			SignsFrom:  key.SignsFrom,
+			SignsUntil: key.SignsUntil,
+			ValidUntil: key.ValidUntil,
+		})
+		if err != nil {
+			return err
+		}
+		log.Printf("created the signing key %v, it signs from %v", key.Id, key.SignsFrom)
+		dbKeys, err = cfg.dbQueries.ListSigningKeys(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	err = cfg.dbQueries.DeleteExpiredSigningKeys(ctx)
+	if err != nil {
+		return err
+	}
+	keys := make([]auth.SigningKey, 0, len(dbKeys))
+	for _, dbKey := range dbKeys {
+		privateKey, err := auth.ParsePrivateKey(dbKey.PrivateKey)
+		if err != nil {
+			return fmt.Errorf("unable to read the signing key %v: %w", dbKey.ID, err)
+		}
+		keys = append(keys, auth.SigningKey{
+			Id:         dbKey.ID,
+			Algorithm:  dbKey.Algorithm,
+			PrivateKey: privateKey,
+			SignsFrom:  dbKey.SignsFrom,
+			SignsUntil: dbKey.SignsUntil,
+			ValidUntil: dbKey.ValidUntil,
+		})
+	}
+	cfg.keyring.Replace(keys)
+	return nil
+}
+
+func (cfg *ApiConfig) rotateSigningKeysPeriodically() {
+	ticker := time.NewTicker(signingKeyCheckPeriod)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := cfg.rotateSigningKeys(context.Background())
+		if err != nil {
+			log.Printf("error when rotating the signing keys: %v", err)
+		}
+	}
+}
+
+func (cfg *ApiConfig) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	jsonKeys, err := json.Marshal(cfg.keyring.JWKS())
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header := w.Header()
+	header.Add("Content-Type", "application/json")
+	// shorter than signingKeyPublishLead, so a cached JWKS always has the next key before it signs
+	header.Add("Cache-Control", "public, max-age=3600")
+	w.WriteHeader(200)
+	w.Write(jsonKeys)
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+	"github.com/lib/pq"
+)
+
+const (
+	chirpEventsChannel       = "chirp_events" // notified by the outbox trigger, see 030_chirp_stream_positions.sql
+	streamHeartbeatPeriod    = 15 * time.Second
+	streamListenerPingPeriod = 90 * time.Second
+	streamReplayBatchSize    = 200
+	streamReconnectDelay     = 3 * time.Second // the retry the browsers wait before reconnecting
+	// sent instead of the replay when the events a client missed were pruned, it should reload the chirps
+	streamResetEvent = "reset"
+	// the events a connection can fall behind before it is dropped, it resumes from the database when it reconnects
+	streamClientBuffer = 64
+)
+
+// streamEvent is a chirp event as it is sent on the stream, the position is its SSE id
+type streamEvent struct {
+	Position int64
+	Type     string
+	AuthorId uuid.UUID
+	Data     json.RawMessage
+}
+
+// newStreamEvent reads the author of the chirp event. Only the published chirps are streamed, even their deletion would
+// tell about a held chirp that only its author could see
+func newStreamEvent(position int64, eventType string, payload []byte) (streamEvent, bool) {
+	var chirp struct {
+		UserId string `json:"user_id"`
+		Status string `json:"status"`
+	}
+	err := json.Unmarshal(payload, &chirp)
+	if err != nil {
+		log.Printf("error when reading the chirp event %v: %v", position, err)
+		return streamEvent{}, false
+	}
+	authorId, err := uuid.Parse(chirp.UserId)
+	// the deletions published before they carried the status have none, they are still streamed
+	if err != nil || (chirp.Status != "" && chirp.Status != chirpPublished) {
+		return streamEvent{}, false
+	}
+	return streamEvent{Position: position, Type: eventType, AuthorId: authorId, Data: payload}, true
+}
+
+type streamClient struct {
+	events    chan streamEvent
+	authorId  uuid.NullUUID
+	followees map[uuid.UUID]bool // only set when the stream is limited to the followed accounts
+	lagging   chan struct{}      // closed once the client fell behind
+	dropOnce  sync.Once
+}
+
+func (client *streamClient) wants(evt streamEvent) bool {
+	if client.authorId.Valid && evt.AuthorId != client.authorId.UUID {
+		return false
+	}
+	return client.followees == nil || client.followees[evt.AuthorId]
+}
+
+// chirpStream hands the chirp events this instance is notified of to its open streams
+type chirpStream struct {
+	mu      sync.Mutex
+	clients map[*streamClient]bool
+	// the last event read by the listener, what comes after it is read again when the listener reconnects
+	lastPosition int64
+}
+
+func newChirpStream() *chirpStream {
+	return &chirpStream{clients: map[*streamClient]bool{}}
+}
+
+func (stream *chirpStream) subscribe(client *streamClient) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	stream.clients[client] = true
+}
+
+func (stream *chirpStream) unsubscribe(client *streamClient) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	delete(stream.clients, client)
+}
+
+// broadcast never waits for a client: one whose buffer is full is dropped instead of slowing down the others
+func (stream *chirpStream) broadcast(evt streamEvent) {
+	stream.mu.Lock()
+	defer stream.mu.Unlock()
+	for client := range stream.clients {
+		if !client.wants(evt) {
+			continue
+		}
+		select {
+		case client.events <- evt:
+		default:
+			client.dropOnce.Do(func() { close(client.lagging) })
+		}
+	}
+}
+
+// listenToChirpEvents feeds the stream with the chirp events notified by Postgres, so the chirps posted through any
+// instance reach the streams of all of them
+func (cfg *ApiConfig) listenToChirpEvents(dbUrl string) {
+	ctx := context.Background()
+	lastPosition, err := cfg.dbQueries.GetLastOutboxPosition(ctx)
+	if err != nil {
+		log.Printf("error when getting the last position of the outbox, the stream won't catch up after a disconnection: %v", err)
+	}
+	cfg.chirpStream.lastPosition = lastPosition
+	listener := pq.NewListener(dbUrl, time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("chirp events listener: %v", err)
+		}
+	})
+	err = listener.Listen(chirpEventsChannel)
+	if err != nil {
+		log.Printf("error when listening to the chirp events: %v", err)
+		return
+	}
+	for {
+		select {
+		case notification := <-listener.Notify:
+			if notification == nil { // the connection was lost, the events notified meanwhile are read from the outbox
+				cfg.catchUpChirpStream(ctx)
+				continue
+			}
+			position, err := strconv.ParseInt(notification.Extra, 10, 64)
+			if err != nil {
+				log.Printf("error when reading the chirp event notification '%v': %v", notification.Extra, err)
+				continue
+			}
+			row, err := cfg.dbQueries.GetChirpStreamEvent(ctx, position)
+			if err != nil {
+				log.Printf("error when getting the chirp event %v: %v", position, err)
+				continue
+			}
+			cfg.chirpStream.lastPosition = max(cfg.chirpStream.lastPosition, position)
+			if evt, ok := newStreamEvent(row.Position, row.EventType, row.Payload); ok {
+				cfg.chirpStream.broadcast(evt)
+			}
+		case <-time.After(streamListenerPingPeriod):
+			go listener.Ping() // notices a dead connection even when no chirp is posted
+		}
+	}
+}
+
+func (cfg *ApiConfig) catchUpChirpStream(ctx context.Context) {
+	for {
+		rows, err := cfg.dbQueries.ListChirpStreamEvents(ctx, database.ListChirpStreamEventsParams{
+			Position: cfg.chirpStream.lastPosition,
+			RowLimit: streamReplayBatchSize,
+		})
+		if err != nil {
+			log.Printf("error when catching up with the chirp events: %v", err)
+			return
+		}
+		for _, row := range rows {
+			cfg.chirpStream.lastPosition = row.Position
+			if evt, ok := newStreamEvent(row.Position, row.EventType, row.Payload); ok {
+				cfg.chirpStream.broadcast(evt)
+			}
+		}
+		if len(rows) < streamReplayBatchSize {
+			return
+		}
+	}
+}
+
+func writeStreamEvent(w http.ResponseWriter, evt streamEvent) error {
+	_, err := fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", evt.Position, evt.Type, evt.Data)
+	return err
+}
+
+// replayChirpStream sends the events following the position the client got last, and tells the position it is at
+// then. When some of them were already pruned, the client gets a reset event telling it to reload the chirps instead
+func (cfg *ApiConfig) replayChirpStream(ctx context.Context, w http.ResponseWriter, client *streamClient, after int64) (int64, error) {
+	oldestPosition, err := cfg.dbQueries.GetOldestChirpStreamPosition(ctx)
+	if err != nil {
+		return after, err
+	}
+	if oldestPosition == 0 || after < oldestPosition-1 {
+		lastPosition, err := cfg.dbQueries.GetLastOutboxPosition(ctx)
+		if err != nil {
+			return after, err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: {}\n\n", lastPosition, streamResetEvent)
+		return lastPosition, err
+	}
+	for {
+		rows, err := cfg.dbQueries.ListChirpStreamEvents(ctx, database.ListChirpStreamEventsParams{
+			Position: after,
+			RowLimit: streamReplayBatchSize,
+		})
+		if err != nil {
+			return after, err
+		}
+		for _, row := range rows {
+			after = row.Position
+			evt, ok := newStreamEvent(row.Position, row.EventType, row.Payload)
+			if ok && client.wants(evt) {
+				err = writeStreamEvent(w, evt)
+				if err != nil {
+					return after, err
+				}
+			}
+		}
+		if len(rows) < streamReplayBatchSize {
+			return after, nil
+		}
+	}
+}
+
+// handleChirpStream pushes the new, published (once a moderator approved them) and deleted chirps as server-sent
+// events, of one author with ?author_id= or of the accounts the user follows with ?following=true. A client
+// reconnecting with Last-Event-ID first gets what it missed, or a reset event when it is too old
+func (cfg *ApiConfig) handleChirpStream(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	query := r.URL.Query()
+	client := &streamClient{events: make(chan streamEvent, streamClientBuffer), lagging: make(chan struct{})}
+	if authorId := query.Get("author_id"); authorId != "" {
+		parsedId, err := uuid.Parse(authorId)
+		if err != nil {
+			badRequest("the author_id should be a UUID")
+			return
+		}
+		client.authorId = uuid.NullUUID{UUID: parsedId, Valid: true}
+	}
+	if query.Get("following") == "true" {
+		viewerId := cfg.optionalUserId(r)
+		if !viewerId.Valid {
+			w.WriteHeader(401)
+			return
+		}
+		// the accounts followed later only show up once the stream is opened again
+		followeeIds, err := cfg.dbQueries.ListFolloweeIds(r.Context(), viewerId.UUID)
+		if err != nil {
+			log.Printf("error when listing the followed accounts: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+		client.followees = map[uuid.UUID]bool{}
+		for _, followeeId := range followeeIds {
+			client.followees[followeeId] = true
+		}
+	}
+	// the positions follow the commits (see 030_chirp_stream_positions.sql), no event can show up later before the id
+	var replayedUpTo int64
+	lastEventId := r.Header.Get("Last-Event-ID")
+	if lastEventId != "" {
+		var err error
+		replayedUpTo, err = strconv.ParseInt(lastEventId, 10, 64)
+		if err != nil {
+			badRequest("the Last-Event-ID should be the id of an event of the stream")
+			return
+		}
+	}
+
+	// subscribed before the replay, so nothing happening during it is missed
+	cfg.chirpStream.subscribe(client)
+	defer cfg.chirpStream.unsubscribe(client)
+	responseController := http.NewResponseController(w)
+	header.Add("Content-Type", "text/event-stream")
+	header.Add("Cache-Control", "no-cache")
+	header.Add("X-Accel-Buffering", "no") // a proxy buffering the response would hold the events back
+	w.WriteHeader(200)
+	fmt.Fprintf(w, "retry: %d\n\n", streamReconnectDelay.Milliseconds())
+	var err error
+	if lastEventId != "" {
+		replayedUpTo, err = cfg.replayChirpStream(r.Context(), w, client, replayedUpTo)
+		if err != nil {
+			log.Printf("error when replaying the chirp events: %v", err)
+			return
+		}
+	}
+	err = responseController.Flush()
+	if err != nil {
+		log.Printf("error when flushing the chirp stream: %v", err)
+		return
+	}
+
+	heartbeat := time.NewTicker(streamHeartbeatPeriod)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-client.lagging:
+			// the connection is closed, the client reconnects with the last id it got and catches up from the database
+			log.Printf("dropping a chirp stream that fell behind by %v events", streamClientBuffer)
+			return
+		case evt := <-client.events:
+			if evt.Position <= replayedUpTo { // already sent by the replay
+				continue
+			}
+			err = writeStreamEvent(w, evt)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err == nil {
+			err = responseController.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	polkaEventUpgraded      = "user.upgraded"
+	polkaEventRenewed       = "user.renewed"
+	polkaEventDowngraded    = "user.downgraded"
+	polkaEventPaymentFailed = "user.payment_failed"
+	polkaEventRefunded      = "user.refunded"
+
+	defaultSubscriptionPlan       = "chirpy_red"
+	subscriptionPeriod            = 30 * 24 * time.Hour // when Polka doesn't tell when the paid period ends
+	subscriptionExpiryCheckPeriod = 10 * time.Minute
+)
+
+type polkaWebhookBody struct {
+	Id    string `json:"id"`
+	Event string `json:"event"`
+	Data  struct {
+		UserId           string     `json:"user_id"`
+		Plan             string     `json:"plan"`
+		CurrentPeriodEnd *time.Time `json:"current_period_end"`
+	} `json:"data"`
+}
+
+type subscriptionResponse struct {
+	Plan              string     `json:"plan"`
+	Status            string     `json:"status"`
+	CurrentPeriodEnd  *time.Time `json:"current_period_end"` // null when the subscription doesn't end
+	CancelAtPeriodEnd bool       `json:"cancel_at_period_end"`
+	IsChirpyRed       bool       `json:"is_chirpy_red"`
+}
+
+var errPolkaUnknownUser = errors.New("the user of the Polka event doesn't exist")
+
+func newSubscriptionResponse(subscription database.Subscription, user database.User) subscriptionResponse {
+	response := subscriptionResponse{
+		Plan:              subscription.Plan,
+		Status:            subscription.Status,
+		CancelAtPeriodEnd: subscription.CancelAtPeriodEnd,
+		IsChirpyRed:       user.IsChirpyRed.Bool,
+	}
+	if subscription.CurrentPeriodEnd.Valid {
+		response.CurrentPeriodEnd = &subscription.CurrentPeriodEnd.Time
+	}
+	return response
+}
+
+// applyPolkaEvent keeps the subscription of the user in sync with Polka. A downgrade or a failed payment keeps
+// Chirpy Red until the end of the paid period, the expiry job removes it then; a refund removes it right away.
+// The events Chirpy doesn't know are ignored, the others publish a subscription.changed event when they changed something
+func applyPolkaEvent(ctx context.Context, queries *database.Queries, parameters *polkaWebhookBody) error {
+	userId, err := uuid.Parse(parameters.Data.UserId)
+	if err != nil {
+		return errPolkaUnknownUser
+	}
+	_, err = queries.GetUserById(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return errPolkaUnknownUser
+	}
+	if err != nil {
+		return err
+	}
+	var changed int64
+	switch parameters.Event {
+	case polkaEventUpgraded, polkaEventRenewed:
+		err = startSubscriptionPeriod(ctx, queries, userId, parameters)
+		changed = 1
+	case polkaEventDowngraded:
+		changed, err = queries.CancelSubscriptionAtPeriodEnd(ctx, userId)
+	case polkaEventPaymentFailed:
+		changed, err = queries.MarkSubscriptionPastDue(ctx, userId)
+	case polkaEventRefunded:
+		changed, err = queries.RefundSubscription(ctx, userId)
+		if err == nil {
+			err = queries.DowngradeFromChirpyRed(ctx, userId)
+		}
+	default:
+		log.Printf("ignoring the unknown Polka event '%v'", parameters.Event)
+	}
+	if err == nil && changed > 0 {
+		err = publishSubscriptionChanged(ctx, queries, userId)
+	}
+	return err
+}
+
+// startSubscriptionPeriod activates the subscription until the end of the period Polka sends, or for one more
+// period. A renewal before the end of the current period adds to it, and what was already paid for is never cut
+// short. A subscription without an end gets the one of Polka from then on
+func startSubscriptionPeriod(ctx context.Context, queries *database.Queries, userId uuid.UUID, parameters *polkaWebhookBody) error {
+	plan := parameters.Data.Plan
+	if plan == "" {
+		plan = defaultSubscriptionPlan
+	}
+	subscription, err := queries.GetSubscription(ctx, userId)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	currentEnd := subscription.CurrentPeriodEnd // not valid when there is no subscription yet
+	periodStart := time.Now().UTC()
+	if parameters.Event == polkaEventRenewed && currentEnd.Valid && currentEnd.Time.After(periodStart) {
+		periodStart = currentEnd.Time
+	}
+	periodEnd := periodStart.Add(subscriptionPeriod)
+	if parameters.Data.CurrentPeriodEnd != nil {
+		periodEnd = parameters.Data.CurrentPeriodEnd.UTC()
+	}
+	if currentEnd.Valid && currentEnd.Time.After(periodEnd) {
+		periodEnd = currentEnd.Time
+	}
+	_, err = queries.UpsertSubscription(ctx, database.UpsertSubscriptionParams{
+		UserID:           userId,
+		Plan:             plan,
+		CurrentPeriodEnd: sql.NullTime{Time: periodEnd, Valid: true},
+	})
+	if err != nil {
+		return err
+	}
+	return queries.UpgradeToChirpyRed(ctx, userId)
+}
+
+// expireSubscriptionsPeriodically removes Chirpy Red from the users whose paid period lapsed
+func (cfg *ApiConfig) expireSubscriptionsPeriodically() {
+	ticker := time.NewTicker(subscriptionExpiryCheckPeriod)
+	defer ticker.Stop()
+	for {
+		expired, err := cfg.expireSubscriptions(context.Background())
+		if err != nil {
+			log.Printf("error when expiring the subscriptions: %v", err)
+		} else if expired > 0 {
+			log.Printf("%v subscriptions expired", expired)
+		}
+		<-ticker.C
+	}
+}
+
+// expireSubscriptions ends the lapsed subscriptions and publishes their subscription.changed events in one transaction
+func (cfg *ApiConfig) expireSubscriptions(ctx context.Context) (int, error) {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	userIds, err := queries.ExpireSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for _, userId := range userIds {
+		err = publishSubscriptionChanged(ctx, queries, userId)
+		if err != nil {
+			return 0, err
+		}
+	}
+	return len(userIds), tx.Commit()
+}
+
+func handlerGetSubscription(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	subscription, err := cfg.dbQueries.GetSubscription(r.Context(), curUserId)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("error when getting the subscription: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), curUserId)
+	if err != nil {
+		log.Printf("error when getting the subscriber: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonSubscription, err := json.Marshal(newSubscriptionResponse(subscription, user))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonSubscription)
+}
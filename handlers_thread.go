@@ -0,0 +1,122 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+type threadNode struct {
+	chirpResponse
+	Replies []*threadNode `json:"replies"`
+}
+
+type threadResponse struct {
+	Ancestors  []chirpResponse `json:"ancestors"`
+	Chirp      chirpResponse   `json:"chirp"`
+	Replies    []*threadNode   `json:"replies"`
+	NextCursor string          `json:"next_cursor,omitempty"`
+}
+
+type threadCursor struct {
+	Path string `json:"path"`
+}
+
+func (cfg *ApiConfig) handleGetChirpThread(w http.ResponseWriter, r *http.Request) {
+	header := w.Header()
+	chirpId, err := uuid.Parse(r.PathValue("chirpId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	limit, err := parsePageLimit(r.URL.Query())
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[threadCursor](r.URL.Query().Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+
+	chirp, err := cfg.dbQueries.GetChirpById(r.Context(), chirpId)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	ancestors, err := cfg.dbQueries.GetChirpAncestors(r.Context(), chirp.ID)
+	if err != nil {
+		log.Printf("error when getting the chirp ancestors: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	afterPath := sql.NullString{}
+	if cursor != nil {
+		afterPath = sql.NullString{String: cursor.Path, Valid: true}
+	}
+	descendants, err := cfg.dbQueries.GetChirpDescendants(r.Context(), database.GetChirpDescendantsParams{
+		ChirpID:   uuid.NullUUID{UUID: chirp.ID, Valid: true},
+		AfterPath: afterPath,
+		RowLimit:  int32(limit + 1),
+	})
+	if err != nil {
+		log.Printf("error when getting the chirp descendants: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	thread := threadResponse{Replies: []*threadNode{}}
+	if len(descendants) > limit {
+		descendants = descendants[:limit]
+		thread.NextCursor, err = encodeCursor(threadCursor{Path: descendants[limit-1].Path})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	chirpList := append(ancestors, chirp) // counters and flags of the whole page are loaded at once
+	for _, descendant := range descendants {
+		chirpList = append(chirpList, descendant.Chirp)
+	}
+	responses, err := cfg.chirpResponses(r.Context(), chirpList, cfg.optionalUserId(r))
+	if err != nil {
+		log.Printf("error when building the thread response: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	thread.Ancestors = responses[:len(ancestors)]
+	thread.Chirp = responses[len(ancestors)]
+	replyResponses := responses[len(ancestors)+1:]
+
+	// descendants come depth first, so a parent is always placed before its replies. Replies whose parent was sent
+	// in a previous page are put at the top level, the client can attach them with their in_reply_to
+	nodes := map[uuid.UUID]*threadNode{}
+	for i, descendant := range descendants {
+		node := &threadNode{chirpResponse: replyResponses[i], Replies: []*threadNode{}}
+		nodes[descendant.Chirp.ID] = node
+		parent, ok := nodes[descendant.Chirp.InReplyTo.UUID]
+		if ok {
+			parent.Replies = append(parent.Replies, node)
+		} else {
+			thread.Replies = append(thread.Replies, node)
+		}
+	}
+
+	jsonThread, err := json.Marshal(&thread)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonThread)
+}
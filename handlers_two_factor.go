@@ -0,0 +1,331 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	totpIssuer            = "Chirpy"
+	recoveryCodesCount    = 10
+	twoFactorChallengeTTL = 5 * time.Minute
+)
+
+type twoFactorCodeParams struct {
+	Code string `json:"code"` // a TOTP code, or a recovery code
+}
+
+func (cfg *ApiConfig) isTwoFactorEnabled(ctx context.Context, userId uuid.UUID) (bool, error) {
+	totp, err := cfg.dbQueries.GetUserTotp(ctx, userId)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return totp.ConfirmedAt.Valid, nil
+}
+
+// the password was right, the challenge token proves it to POST /api/login/2fa without sending the password again
+func sendTwoFactorChallenge(w http.ResponseWriter, cfg *ApiConfig, userId uuid.UUID) {
+	type challengeResponse struct {
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		ChallengeToken    string `json:"challenge_token"`
+	}
+	challenge, err := auth.MakeJWT(userId, cfg.keyring, twoFactorChallengeTTL, auth.WithPurpose(auth.PurposeTwoFactor))
+	if err != nil {
+		log.Printf("error when creating the 2FA challenge: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonChallenge, err := json.Marshal(challengeResponse{TwoFactorRequired: true, ChallengeToken: challenge})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonChallenge)
+}
+
+// verifySecondFactor accepts a TOTP code that wasn't used yet, or one of the unused recovery codes which is then burnt
+func verifySecondFactor(ctx context.Context, queries *database.Queries, totp database.UserTotp, code string) (bool, error) {
+	code = strings.TrimSpace(code)
+	if step, ok := auth.ValidateTOTP(totp.Secret, code, time.Now()); ok {
+		used, err := queries.UseTotpStep(ctx, database.UseTotpStepParams{
+			UserID:       totp.UserID,
+			LastUsedStep: step,
+		})
+		return used == 1, err
+	}
+	if !auth.IsRecoveryCode(code) { // a wrong TOTP code isn't compared to every recovery code hash
+		return false, nil
+	}
+	recoveryCodes, err := queries.ListUnusedRecoveryCodes(ctx, totp.UserID)
+	if err != nil {
+		return false, err
+	}
+	normalizedCode := auth.NormalizeRecoveryCode(code)
+	for _, recoveryCode := range recoveryCodes {
+		if auth.CheckPasswordHash(normalizedCode, recoveryCode.CodeHash) != nil {
+			continue
+		}
+		used, err := queries.UseRecoveryCode(ctx, recoveryCode.ID)
+		return used == 1, err
+	}
+	return false, nil
+}
+
+func handlerEnrollTotp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type enrolmentResponse struct {
+		Secret          string `json:"secret"`
+		ProvisioningUri string `json:"provisioning_uri"`
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), curUserId)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		log.Printf("error when generating the TOTP secret: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	_, err = cfg.dbQueries.StartTotpEnrolment(r.Context(), database.StartTotpEnrolmentParams{
+		UserID: user.ID,
+		Secret: secret,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(409)
+		w.Write([]byte("two-factor authentication is already enabled, disable it first"))
+		return
+	}
+	if err != nil {
+		log.Printf("error when starting the TOTP enrolment: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonEnrolment, err := json.Marshal(enrolmentResponse{
+		Secret:          secret,
+		ProvisioningUri: auth.TOTPProvisioningURI(secret, totpIssuer, user.Email),
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonEnrolment)
+}
+
+// handlerConfirmTotp enables 2FA once the user typed a code from their authenticator, the recovery codes are only shown here
+func handlerConfirmTotp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type confirmationResponse struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	header := w.Header()
+	parameters, err := unmarshalRequestBody[twoFactorCodeParams](w, r)
+	if err != nil {
+		return
+	}
+	totp, err := cfg.dbQueries.GetUserTotp(r.Context(), curUserId)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	if totp.ConfirmedAt.Valid {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(409)
+		w.Write([]byte("two-factor authentication is already enabled"))
+		return
+	}
+	step, ok := auth.ValidateTOTP(totp.Secret, strings.TrimSpace(parameters.Code), time.Now())
+	if !ok {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("the code doesn't match, check the time of the device"))
+		return
+	}
+	recoveryCodes, err := auth.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		log.Printf("error when generating the recovery codes: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the 2FA transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+	confirmed, err := queries.ConfirmUserTotp(r.Context(), database.ConfirmUserTotpParams{
+		UserID:       curUserId,
+		LastUsedStep: step,
+	})
+	if err != nil || confirmed == 0 {
+		log.Printf("error when confirming the TOTP enrolment: %v", err)
+		w.WriteHeader(409)
+		return
+	}
+	err = queries.DeleteRecoveryCodes(r.Context(), curUserId)
+	if err != nil {
+		log.Printf("error when deleting the old recovery codes: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	for _, recoveryCode := range recoveryCodes {
+		// a slow hash, the codes are short enough to be brute forced from a fast one
+		codeHash, err := auth.HashPassword(auth.NormalizeRecoveryCode(recoveryCode))
+		if err == nil {
+			err = queries.CreateRecoveryCode(r.Context(), database.CreateRecoveryCodeParams{
+				UserID:   curUserId,
+				CodeHash: codeHash,
+			})
+		}
+		if err != nil {
+			log.Printf("error when saving the recovery codes: %v", err)
+			w.WriteHeader(500)
+			return
+		}
+	}
+	err = tx.Commit()
+	if err != nil {
+		log.Printf("error when committing the 2FA confirmation: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+
+	jsonCodes, err := json.Marshal(confirmationResponse{RecoveryCodes: recoveryCodes})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonCodes)
+}
+
+// handlerDisableTotp asks for the password, and the code once the enrolment is confirmed, through the login throttle:
+// a stolen access token shouldn't allow guessing them
+func handlerDisableTotp(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type disableParams struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+	parameters, err := unmarshalRequestBody[disableParams](w, r)
+	if err != nil {
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), curUserId)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	_, err = cfg.dbQueries.GetUserTotp(r.Context(), curUserId)
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	// an unfinished enrolment doesn't enable 2FA, it can be dropped with the password alone
+	authenticatedUser, refusal, err := cfg.authenticateUser(r.Context(), clientIp(r), loginCredentials{
+		email:    user.Email,
+		password: parameters.Password,
+		code:     parameters.Code,
+	})
+	if err != nil {
+		log.Printf("error when checking the credentials to disable 2FA: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if refusal == nil && authenticatedUser.ID != curUserId {
+		refusal = &loginRefusal{status: 401, message: "Wrong email or password."}
+	}
+	if refusal != nil {
+		refuseLogin(w, refusal)
+		return
+	}
+	tx, err := cfg.db.BeginTx(r.Context(), nil)
+	if err != nil {
+		log.Printf("error when starting the 2FA transaction: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+	err = queries.DeleteUserTotp(r.Context(), curUserId)
+	if err == nil {
+		err = queries.DeleteRecoveryCodes(r.Context(), curUserId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		log.Printf("error when disabling 2FA: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+func (cfg *ApiConfig) handleLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	type loginTwoFactorParams struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+		loginOptions
+	}
+	parameters, err := unmarshalRequestBody[loginTwoFactorParams](w, r)
+	if err != nil {
+		return
+	}
+	lifetimes, err := parameters.lifetimes(cfg.tokenPolicy)
+	if err != nil {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	userId, err := auth.ValidatePurposeJWT(parameters.ChallengeToken, cfg.keyring, auth.PurposeTwoFactor)
+	if err != nil {
+		log.Printf("invalid 2FA challenge: %v", err)
+		w.WriteHeader(401)
+		return
+	}
+	user, err := cfg.dbQueries.GetUserById(r.Context(), userId)
+	if err != nil {
+		w.WriteHeader(401)
+		return
+	}
+	authenticatedUser, refusal, err := cfg.authenticateUser(r.Context(), clientIp(r), loginCredentials{
+		email:           user.Email,
+		code:            parameters.Code,
+		passwordChecked: true,
+	})
+	if err != nil {
+		log.Printf("error when verifying the second factor: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if refusal == nil && authenticatedUser.ID != userId { // the email went to another account since the challenge
+		refusal = &loginRefusal{status: 401, message: "Wrong email or password."}
+	}
+	if refusal != nil {
+		refuseLogin(w, refusal)
+		return
+	}
+	completeLogin(w, r, cfg, authenticatedUser, parameters.loginOptions, lifetimes)
+}
@@ -0,0 +1,374 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	webhookDeliveryPending   = "pending"
+	webhookDeliveryDelivered = "delivered"
+	webhookDeliveryDead      = "dead" // out of attempts, it waits in the dead letters until an admin retries it
+
+	webhookSignatureHeader    = "Chirpy-Signature"
+	webhookEventTypeHeader    = "Chirpy-Event"
+	webhookDeliveryIdHeader   = "Chirpy-Delivery"
+	webhookDeliveryTimeout    = 10 * time.Second
+	webhookDeliveryLease      = time.Minute // longer than a delivery can take, it is sent again once the lease is over
+	webhookDeliveryBatchSize  = 20
+	webhookDeliveryPollPeriod = 5 * time.Second
+
+	// the delay doubles after each failed attempt: 10 attempts are spread over about 4 hours
+	maxWebhookDeliveryAttempts = 10
+	webhookRetryBaseDelay      = 30 * time.Second
+	webhookRetryMaxDelay       = 6 * time.Hour
+	maxWebhookResponseBodySize = 1 << 10 // the part of the answer kept in the attempt log
+)
+
+// a redirect is answered as a failed delivery, the subscription should be updated with the new URL. The connections
+// only go to public addresses, whatever the host name of the subscription resolves to when it is delivered
+var webhookClient = &http.Client{
+	Timeout: webhookDeliveryTimeout,
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: webhookDeliveryTimeout, Control: dialPublicAddress}).DialContext,
+		TLSHandshakeTimeout: webhookDeliveryTimeout,
+		MaxIdleConnsPerHost: webhookDeliveryBatchSize,
+	},
+}
+
+// dialPublicAddress refuses the connections to the addresses the subscriptions can't point to, it is called with
+// the resolved address of every connection
+func dialPublicAddress(network, address string, conn syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip, err := netip.ParseAddr(host)
+	if err != nil {
+		return err
+	}
+	if !isPublicAddress(ip) {
+		return fmt.Errorf("refusing to deliver to %v: %w", ip, errPrivateWebhookAddress)
+	}
+	return nil
+}
+
+type webhookDeliveryAttemptResponse struct {
+	AttemptedAt  time.Time `json:"attempted_at"`
+	StatusCode   *int32    `json:"status_code,omitempty"`
+	ResponseBody string    `json:"response_body,omitempty"`
+	Error        string    `json:"error,omitempty"`
+	DurationMs   int32     `json:"duration_ms"`
+}
+
+type webhookDeliveryResponse struct {
+	Id             uuid.UUID  `json:"id"`
+	SubscriptionId uuid.UUID  `json:"subscription_id"`
+	EventId        uuid.UUID  `json:"event_id"`
+	EventType      string     `json:"event_type"`
+	Status         string     `json:"status"`
+	Attempts       int32      `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"next_attempt_at,omitempty"` // only while it is pending
+	CreatedAt      time.Time  `json:"created_at"`
+	DeliveredAt    *time.Time `json:"delivered_at,omitempty"`
+	LastError      string     `json:"last_error,omitempty"`
+	// only when a single delivery is asked for
+	Payload    json.RawMessage                  `json:"payload,omitempty"`
+	AttemptLog []webhookDeliveryAttemptResponse `json:"attempt_log,omitempty"`
+}
+
+type webhookDeliveriesPage struct {
+	Deliveries []webhookDeliveryResponse `json:"deliveries"`
+	NextCursor string                    `json:"next_cursor,omitempty"`
+}
+
+func newWebhookDeliveryResponse(delivery database.WebhookDelivery) webhookDeliveryResponse {
+	response := webhookDeliveryResponse{
+		Id:             delivery.ID,
+		SubscriptionId: delivery.SubscriptionID,
+		EventId:        delivery.EventID,
+		EventType:      delivery.EventType,
+		Status:         delivery.Status,
+		Attempts:       delivery.Attempts,
+		CreatedAt:      delivery.CreatedAt,
+		LastError:      delivery.LastError.String,
+	}
+	if delivery.Status == webhookDeliveryPending {
+		response.NextAttemptAt = &delivery.NextAttemptAt
+	}
+	if delivery.DeliveredAt.Valid {
+		response.DeliveredAt = &delivery.DeliveredAt.Time
+	}
+	return response
+}
+
+// deliverWebhooksPeriodically sends the due deliveries, several instances can run it since each delivery is claimed
+func (cfg *ApiConfig) deliverWebhooksPeriodically() {
+	ticker := time.NewTicker(webhookDeliveryPollPeriod)
+	defer ticker.Stop()
+	for {
+		for cfg.deliverDueWebhooks(context.Background()) == webhookDeliveryBatchSize {
+			// a full batch may have left more due deliveries
+		}
+		<-ticker.C
+	}
+}
+
+// deliverDueWebhooks sends a batch of due deliveries at once and tells how many there were
+func (cfg *ApiConfig) deliverDueWebhooks(ctx context.Context) int {
+	deliveries, err := cfg.dbQueries.ClaimDueWebhookDeliveries(ctx, database.ClaimDueWebhookDeliveriesParams{
+		LeasedUntil: time.Now().Add(webhookDeliveryLease),
+		RowLimit:    webhookDeliveryBatchSize,
+	})
+	if err != nil {
+		log.Printf("error when claiming the due webhook deliveries: %v", err)
+		return 0
+	}
+	var wg sync.WaitGroup
+	for _, delivery := range deliveries {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cfg.attemptWebhookDelivery(ctx, delivery)
+		}()
+	}
+	wg.Wait()
+	return len(deliveries)
+}
+
+// attemptWebhookDelivery sends the delivery once and logs the attempt. A failure is retried later, or dead-lettered
+// once it was the last attempt
+func (cfg *ApiConfig) attemptWebhookDelivery(ctx context.Context, delivery database.ClaimDueWebhookDeliveriesRow) {
+	attemptedAt := time.Now()
+	statusCode, responseBody, sendErr := sendWebhook(ctx, delivery, attemptedAt)
+	attempt := database.CreateWebhookDeliveryAttemptParams{
+		DeliveryID:  delivery.ID,
+		AttemptedAt: attemptedAt,
+		DurationMs:  int32(time.Since(attemptedAt).Milliseconds()),
+	}
+	if statusCode != 0 {
+		attempt.StatusCode = sql.NullInt32{Int32: int32(statusCode), Valid: true}
+		attempt.ResponseBody = sql.NullString{String: responseBody, Valid: true}
+	}
+	if sendErr != nil {
+		attempt.Error = sql.NullString{String: sendErr.Error(), Valid: true}
+	}
+	err := cfg.dbQueries.CreateWebhookDeliveryAttempt(ctx, attempt)
+	if err != nil {
+		log.Printf("error when logging the attempt of the webhook delivery %v: %v", delivery.ID, err)
+	}
+
+	attempts := delivery.Attempts + 1
+	switch {
+	case sendErr == nil:
+		err = cfg.dbQueries.MarkWebhookDeliveryDelivered(ctx, delivery.ID)
+	case attempts >= maxWebhookDeliveryAttempts:
+		log.Printf("the webhook delivery %v failed %v times, it goes to the dead letters: %v", delivery.ID, attempts, sendErr)
+		err = cfg.dbQueries.DeadLetterWebhookDelivery(ctx, database.DeadLetterWebhookDeliveryParams{
+			ID:        delivery.ID,
+			LastError: attempt.Error,
+		})
+	default:
+		err = cfg.dbQueries.RetryWebhookDeliveryLater(ctx, database.RetryWebhookDeliveryLaterParams{
+			ID:            delivery.ID,
+			NextAttemptAt: time.Now().Add(retryDelay(attempts, webhookRetryBaseDelay, webhookRetryMaxDelay)),
+			LastError:     attempt.Error,
+		})
+	}
+	if err != nil { // the lease runs out and the delivery is sent again
+		log.Printf("error when recording the result of the webhook delivery %v: %v", delivery.ID, err)
+	}
+}
+
+// sendWebhook posts the payload signed with the secret of the subscription, any 2xx answer is a success
+func sendWebhook(ctx context.Context, delivery database.ClaimDueWebhookDeliveriesRow, now time.Time) (int, string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.Url, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(webhookSignatureHeader, auth.SignWebhook(delivery.Secret, now, delivery.Payload))
+	req.Header.Set(webhookEventTypeHeader, delivery.EventType)
+	req.Header.Set(webhookDeliveryIdHeader, delivery.ID.String())
+	res, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer res.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(res.Body, maxWebhookResponseBodySize))
+	// the answer is stored as text, it can't hold invalid UTF-8 or NUL bytes
+	responseBody := strings.ReplaceAll(strings.ToValidUTF8(string(body), "�"), "\x00", "")
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, responseBody, fmt.Errorf("the subscriber answered with the status %v", res.StatusCode)
+	}
+	return res.StatusCode, responseBody, nil
+}
+
+// handlerListWebhookDeliveries lists the deliveries, newest first. ?status=dead gives the dead letters
+func handlerListWebhookDeliveries(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	query := r.URL.Query()
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	cursor, err := decodeCursor[webhookDeliveryCursor](query.Get("cursor"))
+	if err != nil {
+		badRequest(err.Error())
+		return
+	}
+	params := database.ListWebhookDeliveriesParams{RowLimit: int32(limit + 1)}
+	if subscriptionId := query.Get("subscription_id"); subscriptionId != "" {
+		parsedId, err := uuid.Parse(subscriptionId)
+		if err != nil {
+			badRequest("the subscription_id should be a UUID")
+			return
+		}
+		params.SubscriptionID = uuid.NullUUID{UUID: parsedId, Valid: true}
+	}
+	switch status := query.Get("status"); status {
+	case "":
+	case webhookDeliveryPending, webhookDeliveryDelivered, webhookDeliveryDead:
+		params.Status = sql.NullString{String: status, Valid: true}
+	default:
+		badRequest("the status should be pending, delivered or dead")
+		return
+	}
+	if cursor != nil {
+		params.BeforeCreatedAt = sql.NullTime{Time: cursor.CreatedAt, Valid: true}
+		params.BeforeID = uuid.NullUUID{UUID: cursor.Id, Valid: true}
+	}
+	deliveries, err := cfg.dbQueries.ListWebhookDeliveries(r.Context(), params)
+	if err != nil {
+		log.Printf("error when listing the webhook deliveries: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := webhookDeliveriesPage{}
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+		lastDelivery := deliveries[limit-1]
+		page.NextCursor, err = encodeCursor(webhookDeliveryCursor{CreatedAt: lastDelivery.CreatedAt, Id: lastDelivery.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	page.Deliveries = make([]webhookDeliveryResponse, len(deliveries))
+	for i, delivery := range deliveries {
+		page.Deliveries[i] = newWebhookDeliveryResponse(delivery)
+	}
+	jsonPage, err := json.Marshal(page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+// writeWebhookDelivery answers with the delivery, its payload and the log of its attempts
+func writeWebhookDelivery(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, deliveryId uuid.UUID) {
+	delivery, err := cfg.dbQueries.GetWebhookDelivery(r.Context(), deliveryId)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("error when getting the webhook delivery: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	attempts, err := cfg.dbQueries.ListWebhookDeliveryAttempts(r.Context(), deliveryId)
+	if err != nil {
+		log.Printf("error when listing the attempts of the webhook delivery: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := newWebhookDeliveryResponse(delivery)
+	response.Payload = delivery.Payload
+	response.AttemptLog = make([]webhookDeliveryAttemptResponse, len(attempts))
+	for i, attempt := range attempts {
+		response.AttemptLog[i] = webhookDeliveryAttemptResponse{
+			AttemptedAt:  attempt.AttemptedAt,
+			ResponseBody: attempt.ResponseBody.String,
+			Error:        attempt.Error.String,
+			DurationMs:   attempt.DurationMs,
+		}
+		if attempt.StatusCode.Valid {
+			response.AttemptLog[i].StatusCode = &attempt.StatusCode.Int32
+		}
+	}
+	jsonDelivery, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonDelivery)
+}
+
+func handlerGetWebhookDelivery(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	deliveryId, err := uuid.Parse(r.PathValue("deliveryId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	writeWebhookDelivery(w, r, cfg, deliveryId)
+}
+
+// handlerRetryWebhookDelivery takes a delivery out of the dead letters, it is sent again with all its attempts
+func handlerRetryWebhookDelivery(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	deliveryId, err := uuid.Parse(r.PathValue("deliveryId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	requeued, err := cfg.dbQueries.RequeueWebhookDelivery(r.Context(), deliveryId)
+	if err != nil {
+		log.Printf("error when requeuing the webhook delivery: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if requeued == 0 {
+		_, err = cfg.dbQueries.GetWebhookDelivery(r.Context(), deliveryId)
+		if errors.Is(err, sql.ErrNoRows) {
+			w.WriteHeader(404)
+			return
+		}
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(409)
+		w.Write([]byte("only a dead delivery can be retried"))
+		return
+	}
+	log.Printf("user %v retried the webhook delivery %v", curUserId, deliveryId)
+	writeWebhookDelivery(w, r, cfg, deliveryId)
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const webhookSecretPrefix = "whsec_"
+
+var errPrivateWebhookAddress = errors.New("the url can't point to a private, loopback or link-local address")
+
+// the cloud metadata services, they also answer on a link-local address
+var internalWebhookHosts = map[string]bool{
+	"metadata.google.internal": true,
+	"metadata.goog":            true,
+}
+
+// the addresses Go counts as global unicast that aren't reachable from the internet
+var nonPublicPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // this network
+	netip.MustParsePrefix("100.64.0.0/10"), // shared address space of the carrier-grade NATs
+}
+
+var webhookEventTypes = map[string]bool{
+	eventChirpCreated:        true,
+	eventChirpUpdated:        true,
+	eventChirpPublished:      true,
+	eventChirpDeleted:        true,
+	eventUserCreated:         true,
+	eventUserUpdated:         true,
+	eventSubscriptionChanged: true,
+}
+
+// webhookEnvelope is the body of every delivery, the id is the one of the event so it is the same for all the
+// subscriptions getting it
+type webhookEnvelope struct {
+	Id        uuid.UUID       `json:"id"`
+	Type      string          `json:"type"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+type chirpEvent struct {
+	chirpResponse
+	Status string `json:"status"` // a held chirp is created before a moderator publishes it
+}
+
+type chirpDeletedEvent struct {
+	Id         uuid.UUID `json:"id"`
+	UserId     uuid.UUID `json:"user_id"`
+	Tombstoned bool      `json:"tombstoned"` // the chirp had replies, it stays as a placeholder in its thread
+	Status     string    `json:"status"`     // before the deletion, the streams never sent the chirps that weren't published
+}
+
+// userEvent is the user without the tokens and expiries of the login response
+type userEvent struct {
+	Id            uuid.UUID `json:"id"`
+	Email         string    `json:"email"`
+	CreatedAt     time.Time `json:"created_at"`
+	UpdatedAt     time.Time `json:"updated_at"`
+	IsChirpyRed   bool      `json:"is_chirpy_red"`
+	Role          string    `json:"role"`
+	EmailVerified bool      `json:"email_verified"`
+}
+
+func newUserEvent(user database.User) userEvent {
+	return userEvent{
+		Id:            user.ID,
+		Email:         user.Email,
+		CreatedAt:     user.CreatedAt,
+		UpdatedAt:     user.UpdatedAt,
+		IsChirpyRed:   user.IsChirpyRed.Bool,
+		Role:          user.Role,
+		EmailVerified: user.EmailVerifiedAt.Valid,
+	}
+}
+
+type subscriptionEvent struct {
+	UserId uuid.UUID `json:"user_id"`
+	subscriptionResponse
+}
+
+type webhookSubscriptionResponse struct {
+	Id         uuid.UUID `json:"id"`
+	Url        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+	Secret     string    `json:"secret,omitempty"` // only given when the subscription is created
+}
+
+func newWebhookSubscriptionResponse(subscription database.WebhookSubscription) webhookSubscriptionResponse {
+	return webhookSubscriptionResponse{
+		Id:         subscription.ID,
+		Url:        subscription.Url,
+		EventTypes: subscription.EventTypes,
+		CreatedAt:  subscription.CreatedAt,
+	}
+}
+
+// queueWebhookDeliveries is the subscriber of the event bus adding a delivery of the event for every webhook
+// subscription wanting it
+func queueWebhookDeliveries(ctx context.Context, queries *database.Queries, evt event) error {
+	payload, err := json.Marshal(webhookEnvelope{Id: evt.Id, Type: evt.Type, CreatedAt: evt.CreatedAt, Data: evt.Data})
+	if err != nil {
+		return err
+	}
+	return queries.EnqueueWebhookDeliveries(ctx, database.EnqueueWebhookDeliveriesParams{
+		EventID:   evt.Id,
+		EventType: evt.Type,
+		Payload:   payload,
+	})
+}
+
+// publishSubscriptionChanged tells the subscription of the user as it is now, with the entitlement it gives
+func publishSubscriptionChanged(ctx context.Context, queries *database.Queries, userId uuid.UUID) error {
+	subscription, err := queries.GetSubscription(ctx, userId)
+	if err != nil {
+		return err
+	}
+	user, err := queries.GetUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	return publishEvent(ctx, queries, eventSubscriptionChanged, subscriptionEvent{
+		UserId:               userId,
+		subscriptionResponse: newSubscriptionResponse(subscription, user),
+	})
+}
+
+// validateWebhookURL only accepts absolute http(s) URLs, the credentials of a subscriber go in the URL path or the
+// secret, not in the user info. The URLs of the internal network are refused, the deliveries would let anyone allowed
+// to subscribe probe it; a host name is checked again once resolved, when the delivery connects
+func validateWebhookURL(rawUrl string) error {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || (parsedUrl.Scheme != "https" && parsedUrl.Scheme != "http") || parsedUrl.Host == "" {
+		return fmt.Errorf("the url should be an absolute http or https URL")
+	}
+	if parsedUrl.User != nil || parsedUrl.Fragment != "" {
+		return fmt.Errorf("the url can't have user info or a fragment")
+	}
+	host := strings.ToLower(strings.TrimSuffix(parsedUrl.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") || internalWebhookHosts[host] {
+		return errPrivateWebhookAddress
+	}
+	if address, err := netip.ParseAddr(host); err == nil && !isPublicAddress(address) {
+		return errPrivateWebhookAddress
+	}
+	return nil
+}
+
+// isPublicAddress is false for the loopback, link-local (the cloud metadata services), private and shared addresses
+func isPublicAddress(address netip.Addr) bool {
+	address = address.Unmap()
+	if !address.IsGlobalUnicast() || address.IsPrivate() {
+		return false
+	}
+	for _, prefix := range nonPublicPrefixes {
+		if prefix.Contains(address) {
+			return false
+		}
+	}
+	return true
+}
+
+// handlerCreateWebhookSubscription registers a URL for the events, or all of them when no event type is given. The
+// secret signing the deliveries is only shown in this response
+func handlerCreateWebhookSubscription(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	type createWebhookSubscriptionParams struct {
+		Url        string   `json:"url"`
+		EventTypes []string `json:"event_types"`
+	}
+	header := w.Header()
+	badRequest := func(message string) {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(message))
+	}
+	parameters, err := unmarshalRequestBody[createWebhookSubscriptionParams](w, r)
+	if err != nil {
+		return
+	}
+	if err := validateWebhookURL(parameters.Url); err != nil {
+		badRequest(err.Error())
+		return
+	}
+	eventTypes := []string{}
+	seen := map[string]bool{}
+	for _, eventType := range parameters.EventTypes {
+		if !webhookEventTypes[eventType] {
+			badRequest(fmt.Sprintf("unknown event type '%v'", eventType))
+			return
+		}
+		if !seen[eventType] {
+			seen[eventType] = true
+			eventTypes = append(eventTypes, eventType)
+		}
+	}
+	secret, err := auth.MakeRefreshToken()
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	secret = webhookSecretPrefix + secret
+	subscription, err := cfg.dbQueries.CreateWebhookSubscription(r.Context(), database.CreateWebhookSubscriptionParams{
+		Url:        parameters.Url,
+		Secret:     secret,
+		EventTypes: eventTypes,
+		CreatedBy:  uuid.NullUUID{UUID: curUserId, Valid: true},
+	})
+	if err != nil {
+		log.Printf("error when creating the webhook subscription: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := newWebhookSubscriptionResponse(subscription)
+	response.Secret = secret
+	jsonSubscription, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(201)
+	w.Write(jsonSubscription)
+}
+
+func handlerListWebhookSubscriptions(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	subscriptions, err := cfg.dbQueries.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		log.Printf("error when listing the webhook subscriptions: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := make([]webhookSubscriptionResponse, len(subscriptions))
+	for i, subscription := range subscriptions {
+		response[i] = newWebhookSubscriptionResponse(subscription)
+	}
+	jsonSubscriptions, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonSubscriptions)
+}
+
+func handlerGetWebhookSubscription(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	subscriptionId, err := uuid.Parse(r.PathValue("subscriptionId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	subscription, err := cfg.dbQueries.GetWebhookSubscription(r.Context(), subscriptionId)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("error when getting the webhook subscription: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	jsonSubscription, err := json.Marshal(newWebhookSubscriptionResponse(subscription))
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonSubscription)
+}
+
+// handlerDeleteWebhookSubscription stops the deliveries to the URL, the pending ones and the log of the past ones go
+// with it
+func handlerDeleteWebhookSubscription(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	subscriptionId, err := uuid.Parse(r.PathValue("subscriptionId"))
+	if err != nil {
+		w.WriteHeader(404)
+		return
+	}
+	deleted, err := cfg.dbQueries.DeleteWebhookSubscription(r.Context(), subscriptionId)
+	if err != nil {
+		log.Printf("error when deleting the webhook subscription: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	if deleted == 0 {
+		w.WriteHeader(404)
+		return
+	}
+	log.Printf("user %v deleted the webhook subscription %v", curUserId, subscriptionId)
+	w.WriteHeader(204)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/google/uuid"
+)
+
+const (
+	polkaSignatureHeader    = "Polka-Signature"
+	defaultWebhookTolerance = 5 * time.Minute
+	maxWebhookBodySize      = 1 << 20
+)
+
+type webhookEventResponse struct {
+	Id          string          `json:"id"`
+	EventType   string          `json:"event_type"`
+	ReceivedAt  time.Time       `json:"received_at"`
+	ProcessedAt *time.Time      `json:"processed_at"`
+	Attempts    int32           `json:"attempts"`
+	LastError   string          `json:"last_error,omitempty"`
+	Payload     json.RawMessage `json:"payload,omitempty"` // only when a single event is asked for
+}
+
+type webhookEventsPage struct {
+	Events     []webhookEventResponse `json:"events"`
+	NextCursor string                 `json:"next_cursor,omitempty"`
+}
+
+func newWebhookEventResponse(event database.WebhookEvent) webhookEventResponse {
+	response := webhookEventResponse{
+		Id:         event.ID,
+		EventType:  event.EventType,
+		ReceivedAt: event.ReceivedAt,
+		Attempts:   event.Attempts,
+		LastError:  event.LastError.String,
+	}
+	if event.ProcessedAt.Valid {
+		response.ProcessedAt = &event.ProcessedAt.Time
+	}
+	return response
+}
+
+// verifyPolkaDelivery checks the signature made with POLKA_WEBHOOK_SECRET. Without a secret, the static POLKA_KEY is
+// still accepted so the signatures can be turned on without missing deliveries
+func (cfg *ApiConfig) verifyPolkaDelivery(header http.Header, body []byte) error {
+	if cfg.polkaWebhookSecret != "" {
+		return auth.VerifyWebhookSignature(cfg.polkaWebhookSecret, header.Get(polkaSignatureHeader), body, cfg.polkaWebhookTolerance, time.Now())
+	}
+	providedApiKey, err := auth.GetApiKey(header)
+	if err != nil {
+		return err
+	}
+	if cfg.polkaKey == "" || subtle.ConstantTimeCompare([]byte(providedApiKey), []byte(cfg.polkaKey)) != 1 {
+		return fmt.Errorf("wrong Polka API key")
+	}
+	return nil
+}
+
+// polkaDeliveryId identifies an event sent without an id. Identical events are legitimate (two renewals of the same
+// plan), so only the same signed delivery is deduplicated; without a signature every delivery is a new event
+func (cfg *ApiConfig) polkaDeliveryId(header http.Header, body []byte) string {
+	if cfg.polkaWebhookSecret != "" {
+		deliveryId, err := auth.WebhookDeliveryId(header.Get(polkaSignatureHeader), body)
+		if err == nil {
+			return deliveryId
+		}
+	}
+	return uuid.NewString()
+}
+
+// handlerPolkaWebhook stores the delivery before processing it. A redelivery of a processed event is answered
+// without doing anything, one that failed before is processed again
+func (cfg *ApiConfig) handlerPolkaWebhook(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxWebhookBodySize))
+	if err != nil {
+		w.WriteHeader(400)
+		return
+	}
+	// the signature is over the raw body, it has to be checked before the body is parsed
+	err = cfg.verifyPolkaDelivery(r.Header, body)
+	if err != nil {
+		log.Printf("refusing the Polka delivery: %v", err)
+		w.WriteHeader(401)
+		return
+	}
+	var event polkaWebhookBody
+	err = json.Unmarshal(body, &event)
+	if err != nil {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte("Server unable to read request body"))
+		return
+	}
+	eventId := event.Id
+	if eventId == "" {
+		eventId = cfg.polkaDeliveryId(r.Header, body)
+	}
+	err = cfg.dbQueries.CreateWebhookEvent(r.Context(), database.CreateWebhookEventParams{
+		ID:        eventId,
+		EventType: event.Event,
+		Payload:   body,
+	})
+	if err != nil {
+		log.Printf("error when storing the Polka event %v: %v", eventId, err)
+		w.WriteHeader(500)
+		return
+	}
+	err = cfg.processWebhookEvent(r.Context(), eventId, false)
+	if errors.Is(err, errPolkaUnknownUser) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("error when processing the Polka event %v: %v", eventId, err)
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(204)
+}
+
+// processWebhookEvent applies the stored payload of the event, the lock on the event makes two concurrent deliveries
+// apply it once. An already processed event is only applied again when forced
+func (cfg *ApiConfig) processWebhookEvent(ctx context.Context, eventId string, force bool) error {
+	tx, err := cfg.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	queries := cfg.dbQueries.WithTx(tx)
+
+	event, err := queries.GetWebhookEventForUpdate(ctx, eventId)
+	if err != nil {
+		return err
+	}
+	if event.ProcessedAt.Valid && !force {
+		return nil
+	}
+	var parameters polkaWebhookBody
+	err = json.Unmarshal(event.Payload, &parameters)
+	if err == nil {
+		err = applyPolkaEvent(ctx, queries, &parameters)
+	}
+	if err == nil {
+		err = queries.MarkWebhookEventProcessed(ctx, eventId)
+	}
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		// the transaction holds the lock on the event, it has to end before the failure is recorded
+		tx.Rollback()
+		recordErr := cfg.dbQueries.RecordWebhookEventFailure(ctx, database.RecordWebhookEventFailureParams{
+			ID:        eventId,
+			LastError: sql.NullString{String: err.Error(), Valid: true},
+		})
+		if recordErr != nil {
+			log.Printf("error when recording the failure of the webhook event %v: %v", eventId, recordErr)
+		}
+		return err
+	}
+	return nil
+}
+
+func handlerListWebhookEvents(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	header := w.Header()
+	query := r.URL.Query()
+	limit, err := parsePageLimit(query)
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	cursor, err := decodeCursor[webhookEventCursor](query.Get("cursor"))
+	if err != nil {
+		header.Add("Content-Type", "text/plain")
+		w.WriteHeader(400)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	params := database.ListWebhookEventsParams{
+		OnlyUnprocessed: query.Get("unprocessed") == "true",
+		RowLimit:        int32(limit + 1),
+	}
+	if cursor != nil {
+		params.BeforeReceivedAt = sql.NullTime{Time: cursor.ReceivedAt, Valid: true}
+		params.BeforeID = sql.NullString{String: cursor.Id, Valid: true}
+	}
+	events, err := cfg.dbQueries.ListWebhookEvents(r.Context(), params)
+	if err != nil {
+		log.Printf("error when listing the webhook events: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	page := webhookEventsPage{}
+	if len(events) > limit {
+		events = events[:limit]
+		lastEvent := events[limit-1]
+		page.NextCursor, err = encodeCursor(webhookEventCursor{ReceivedAt: lastEvent.ReceivedAt, Id: lastEvent.ID})
+		if err != nil {
+			w.WriteHeader(500)
+			return
+		}
+	}
+	page.Events = make([]webhookEventResponse, len(events))
+	for i, event := range events {
+		page.Events[i] = newWebhookEventResponse(event)
+	}
+	jsonPage, err := json.Marshal(page)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	header.Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonPage)
+}
+
+// writeWebhookEvent answers with the event and the payload it was received with
+func writeWebhookEvent(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, eventId string) {
+	event, err := cfg.dbQueries.GetWebhookEvent(r.Context(), eventId)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if err != nil {
+		log.Printf("error when getting the webhook event: %v", err)
+		w.WriteHeader(500)
+		return
+	}
+	response := newWebhookEventResponse(event)
+	response.Payload = event.Payload // only valid JSON payloads are stored
+	jsonEvent, err := json.Marshal(response)
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(200)
+	w.Write(jsonEvent)
+}
+
+func handlerGetWebhookEvent(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	writeWebhookEvent(w, r, cfg, r.PathValue("eventId"))
+}
+
+// handlerReprocessWebhookEvent applies the stored event again, even if it was processed: it is up to the admin to
+// know that an upgrade or a renewal applied twice starts a new period
+func handlerReprocessWebhookEvent(w http.ResponseWriter, r *http.Request, cfg *ApiConfig, curUserId uuid.UUID) {
+	eventId := r.PathValue("eventId")
+	err := cfg.processWebhookEvent(r.Context(), eventId, true)
+	if errors.Is(err, sql.ErrNoRows) {
+		w.WriteHeader(404)
+		return
+	}
+	if errors.Is(err, errPolkaUnknownUser) {
+		w.Header().Add("Content-Type", "text/plain")
+		w.WriteHeader(422)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	if err != nil {
+		log.Printf("error when processing the webhook event %v again: %v", eventId, err)
+		w.WriteHeader(500)
+		return
+	}
+	log.Printf("user %v processed the webhook event %v again", curUserId, eventId)
+	writeWebhookEvent(w, r, cfg, eventId)
+}
@@ -0,0 +1,73 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scope is what an API key or an OAuth client is allowed to do, a logged in user (with an access token) can do everything
+type Scope string
+
+const (
+	// the routes an API key can never call, like managing the keys or changing the email and the password
+	SessionOnly      Scope = ""
+	ScopeChirpsRead  Scope = "chirps:read"  // see the held chirps of the user and their timeline
+	ScopeChirpsWrite Scope = "chirps:write" // post, edit and delete chirps
+	ScopeSocialWrite Scope = "social:write" // like, rechirp, follow and report
+)
+
+var knownScopes = map[Scope]bool{
+	ScopeChirpsRead:  true,
+	ScopeChirpsWrite: true,
+	ScopeSocialWrite: true,
+}
+
+const apiKeyPrefix = "chirpy_"
+
+func ParseScopes(scopes []string) ([]Scope, error) {
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("an API key needs at least one scope")
+	}
+	parsedScopes := make([]Scope, 0, len(scopes))
+	for _, scope := range scopes {
+		if !knownScopes[Scope(scope)] {
+			return nil, fmt.Errorf("unknown scope '%v', expected chirps:read, chirps:write or social:write", scope)
+		}
+		parsedScopes = append(parsedScopes, Scope(scope))
+	}
+	return parsedScopes, nil
+}
+
+func HasScope(granted []string, scope Scope) bool {
+	for _, grantedScope := range granted {
+		if Scope(grantedScope) == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// MakeApiKey gives the key to show once to the user and its public prefix, which identifies the key in the lists
+// without revealing it. The "chirpy_" start lets secret scanners recognize a leaked key
+func MakeApiKey() (key string, prefix string, err error) {
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", "", err
+	}
+	key = apiKeyPrefix + hex.EncodeToString(secret)
+	return key, key[:len(apiKeyPrefix)+8], nil
+}
+
+// HashApiKey is a plain SHA-256 like for the refresh tokens, the keys are random enough
+func HashApiKey(key string) string {
+	return HashRefreshToken(key)
+}
+
+// IsApiKeyAuthorization tells whether the request authenticates with "Authorization: ApiKey <key>" rather than a bearer token
+func IsApiKeyAuthorization(header http.Header) bool {
+	return strings.HasPrefix(header.Get("Authorization"), "ApiKey ")
+}
@@ -1,57 +1,229 @@
 package auth
 
 import (
+	"crypto"
+	"crypto/ed25519"
 	"fmt"
 	"net/http"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
 )
 
+func newTestKeyring(t *testing.T, algorithm string) *Keyring {
+	now := time.Now()
+	key, err := GenerateSigningKey(algorithm, now.Add(-time.Minute), now.Add(time.Hour), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatalf("error when generating the key: %v", err)
+	}
+	return NewKeyring("chirpy", "chirpy", key)
+}
+
 func TestCreateAndValidateJWT(t *testing.T) {
 	cases := []struct {
-		id          uuid.UUID
-		tokenSecret string
+		id        uuid.UUID
+		algorithm string
 	}{
 		{
-			id:          uuid.New(),
-			tokenSecret: "secret1",
+			id:        uuid.New(),
+			algorithm: AlgEdDSA,
 		},
 		{
-			id:          uuid.New(),
-			tokenSecret: "secret2",
+			id:        uuid.New(),
+			algorithm: AlgRS256,
 		},
 	}
 	for _, c := range cases {
-		token, err := MakeJWT(c.id, c.tokenSecret, 5*time.Minute)
+		keyring := newTestKeyring(t, c.algorithm)
+		token, err := MakeJWT(c.id, keyring, 5*time.Minute)
 		if err != nil {
 			fmt.Println(err)
 			t.Errorf("An error occured")
 			return
 		}
-		tokenUserId, err := ValidateJWT(token, c.tokenSecret)
+		tokenUserId, err := ValidateJWT(token, keyring)
 		if err != nil {
 			fmt.Println(err)
 			t.Errorf("An error occured when validating the JWT")
 			return
 		}
-		falseToken, _ := ValidateJWT(token, "a false secret")
+		falseToken, _ := ValidateJWT(token, newTestKeyring(t, c.algorithm))
 		if tokenUserId.String() != c.id.String() {
 			t.Errorf("the unsigned token %v, is different from the given token: %v", tokenUserId.String(), c.id.String())
 		}
 		if falseToken.String() == c.id.String() {
-			t.Errorf("the token created with a wrong secret was 'validated':\n\t %v should be different to: %v", falseToken.String(), c.id.String())
+			t.Errorf("the token created with a wrong key was 'validated':\n\t %v should be different to: %v", falseToken.String(), c.id.String())
+		}
+	}
+
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Now()
+	oldKey, _ := GenerateSigningKey(AlgEdDSA, now.Add(-2*time.Hour), now.Add(-time.Minute), now.Add(time.Hour))
+	keyring := NewKeyring("chirpy", "chirpy", oldKey)
+	// the old key no longer signs but the tokens it signed are still valid
+	oldKeyring := NewKeyring("chirpy", "chirpy", SigningKey{
+		Id: oldKey.Id, Algorithm: oldKey.Algorithm, PrivateKey: oldKey.PrivateKey,
+		SignsFrom: oldKey.SignsFrom, SignsUntil: now.Add(time.Hour), ValidUntil: oldKey.ValidUntil,
+	})
+	oldToken, _ := MakeJWT(uuid.New(), oldKeyring, 5*time.Minute)
+	if _, err := MakeJWT(uuid.New(), keyring, 5*time.Minute); err != ErrNoSigningKey {
+		t.Errorf("no key should sign once the old one stopped signing, got %v", err)
+	}
+	newKey, _ := GenerateSigningKey(AlgRS256, now.Add(-time.Minute), now.Add(time.Hour), now.Add(2*time.Hour))
+	keyring.Replace([]SigningKey{oldKey, newKey})
+	newToken, err := MakeJWT(uuid.New(), keyring, 5*time.Minute)
+	if err != nil {
+		t.Fatalf("error when signing with the new key: %v", err)
+	}
+	if _, err := ValidateJWT(newToken, keyring); err != nil {
+		t.Errorf("the token of the new key should be valid: %v", err)
+	}
+	if _, err := ValidateJWT(oldToken, keyring); err != nil {
+		t.Errorf("the token of the old key should still be valid: %v", err)
+	}
+	expiredKey := oldKey
+	expiredKey.ValidUntil = now.Add(-time.Second)
+	keyring.Replace([]SigningKey{expiredKey, newKey})
+	if _, err := ValidateJWT(oldToken, keyring); err == nil {
+		t.Errorf("the token of an expired key should not be valid")
+	}
+	if jwks := keyring.JWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != newKey.Id || jwks.Keys[0].Kty != "RSA" {
+		t.Errorf("only the new key should be published, got %+v", jwks.Keys)
+	}
+}
+
+func TestAlgorithmConfusion(t *testing.T) {
+	keyring := newTestKeyring(t, AlgEdDSA)
+	key := keyring.Keys()[0]
+	claims := jwt.RegisteredClaims{
+		Issuer:    "chirpy",
+		Audience:  jwt.ClaimStrings{"chirpy"},
+		Subject:   uuid.New().String(),
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+	}
+	unsigned := jwt.NewWithClaims(jwt.SigningMethodNone, claims)
+	unsigned.Header["kid"] = key.Id
+	noneToken, _ := unsigned.SignedString(jwt.UnsafeAllowNoneSignatureType)
+	if _, err := ValidateJWT(noneToken, keyring); err == nil {
+		t.Errorf("an unsigned token should not be accepted")
+	}
+	// an HMAC signed with the public key, which anyone can fetch from the JWKS
+	hmac := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	hmac.Header["kid"] = key.Id
+	hmacToken, _ := hmac.SignedString([]byte(key.PrivateKey.Public().(ed25519.PublicKey)))
+	if _, err := ValidateJWT(hmacToken, keyring); err == nil {
+		t.Errorf("a token signed with HS256 should not be accepted")
+	}
+}
+
+func TestIssuerAndAudience(t *testing.T) {
+	keyring := newTestKeyring(t, AlgEdDSA)
+	key := keyring.Keys()[0]
+	otherAudience := NewKeyring("chirpy", "another-service", key)
+	otherIssuer := NewKeyring("another-issuer", "chirpy", key)
+	token, _ := MakeJWT(uuid.New(), keyring, 5*time.Minute)
+	if _, err := ValidateJWT(token, otherAudience); err == nil {
+		t.Errorf("a token for another audience should not be accepted")
+	}
+	if _, err := ValidateJWT(token, otherIssuer); err == nil {
+		t.Errorf("a token from another issuer should not be accepted")
+	}
+}
+
+func TestEncodePrivateKey(t *testing.T) {
+	for _, algorithm := range []string{AlgEdDSA, AlgRS256} {
+		key, _ := GenerateSigningKey(algorithm, time.Now(), time.Now().Add(time.Hour), time.Now().Add(time.Hour))
+		encodedKey, err := EncodePrivateKey(key.PrivateKey)
+		if err != nil {
+			t.Fatalf("error when encoding the %v key: %v", algorithm, err)
+		}
+		decodedKey, err := ParsePrivateKey(encodedKey)
+		if err != nil {
+			t.Fatalf("error when parsing the %v key: %v", algorithm, err)
 		}
+		if !decodedKey.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.PrivateKey.Public()) {
+			t.Errorf("the parsed %v key is different from the encoded one", algorithm)
+		}
+	}
+}
+
+func TestRoleClaim(t *testing.T) {
+	userId := uuid.New()
+	sessionId := uuid.New()
+	keyring := newTestKeyring(t, AlgEdDSA)
+	token, err := MakeJWT(userId, keyring, 5*time.Minute, WithRole(RoleModerator), WithSessionId(sessionId))
+	if err != nil {
+		t.Fatalf("error when creating the token: %v", err)
+	}
+	claims, err := ParseJWT(token, keyring)
+	if err != nil {
+		t.Fatalf("error when parsing the token: %v", err)
+	}
+	if claims.Role != RoleModerator || claims.Subject != userId.String() || claims.SessionId != sessionId.String() {
+		t.Errorf("unexpected claims: role '%v', subject '%v', session '%v'", claims.Role, claims.Subject, claims.SessionId)
+	}
+	token, _ = MakeJWT(userId, keyring, 5*time.Minute)
+	claims, _ = ParseJWT(token, keyring)
+	if claims.Role != "" || claims.SessionId != "" {
+		t.Errorf("no role or session was given but the token carries '%v' '%v'", claims.Role, claims.SessionId)
+	}
+}
+
+func TestPurposeToken(t *testing.T) {
+	userId := uuid.New()
+	keyring := newTestKeyring(t, AlgEdDSA)
+	challenge, _ := MakeJWT(userId, keyring, 5*time.Minute, WithPurpose(PurposeTwoFactor))
+	if _, err := ValidateJWT(challenge, keyring); err == nil {
+		t.Errorf("a token with a purpose should not be accepted as an access token")
 	}
+	tokenUserId, err := ValidatePurposeJWT(challenge, keyring, PurposeTwoFactor)
+	if err != nil || tokenUserId != userId {
+		t.Errorf("the challenge should be accepted for its purpose: %v", err)
+	}
+	accessToken, _ := MakeJWT(userId, keyring, 5*time.Minute)
+	if _, err := ValidatePurposeJWT(accessToken, keyring, PurposeTwoFactor); err == nil {
+		t.Errorf("an access token should not be accepted as a challenge")
+	}
+}
 
+func TestRolePermissions(t *testing.T) {
+	cases := []struct {
+		role       Role
+		permission Permission
+		expected   bool
+	}{
+		{role: RoleUser, permission: PermModerate, expected: false},
+		{role: RoleModerator, permission: PermModerate, expected: true},
+		{role: RoleModerator, permission: PermManageRoles, expected: false},
+		{role: RoleAdmin, permission: PermManageRoles, expected: true},
+		{role: RoleModerator, permission: PermManageLockouts, expected: false},
+		{role: RoleAdmin, permission: PermManageLockouts, expected: true},
+		{role: RoleModerator, permission: PermManageWebhooks, expected: false},
+		{role: RoleAdmin, permission: PermManageWebhooks, expected: true},
+		{role: Role("unknown"), permission: PermModerate, expected: false},
+	}
+	for _, c := range cases {
+		if c.role.Can(c.permission) != c.expected {
+			t.Errorf("%v.Can(%v) should be %v", c.role, c.permission, c.expected)
+		}
+	}
+	if _, err := ParseRole("superuser"); err == nil {
+		t.Errorf("unknown roles should not be parsed")
+	}
 }
 
 func TestTokenExpiry(t *testing.T) {
 	// test token expiry
-	token, _ := MakeJWT(uuid.New(), "secret key", 2*time.Second)
+	keyring := newTestKeyring(t, AlgEdDSA)
+	token, _ := MakeJWT(uuid.New(), keyring, 2*time.Second)
 	time.Sleep(3 * time.Second)
-	_, err := ValidateJWT(token, "secret key")
+	_, err := ValidateJWT(token, keyring)
 	if err == nil {
 		t.Errorf("The token should not be validated, it should be expired")
 	}
@@ -84,3 +256,401 @@ func TestCreateRefreshToken(t *testing.T) {
 	}
 	// t.Errorf("the new Random: %v", newRandom)
 }
+
+func TestHashRefreshToken(t *testing.T) {
+	token, _ := MakeRefreshToken()
+	if HashRefreshToken(token) != HashRefreshToken(token) {
+		t.Errorf("the same token should always give the same hash")
+	}
+	if HashRefreshToken(token) == token {
+		t.Errorf("the hash should not be the token itself")
+	}
+	otherToken, _ := MakeRefreshToken()
+	if HashRefreshToken(token) == HashRefreshToken(otherToken) {
+		t.Errorf("two tokens should not have the same hash")
+	}
+	// matches what the migration computes with encode(sha256(...), 'hex')
+	if HashRefreshToken("abc") != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("unexpected hash %v", HashRefreshToken("abc"))
+	}
+}
+
+func TestTOTPVectors(t *testing.T) {
+	// the SHA1 test vectors of RFC 6238 appendix B, they use 8 digits codes
+	key := []byte("12345678901234567890")
+	cases := []struct {
+		time     int64
+		expected string
+	}{
+		{time: 59, expected: "94287082"},
+		{time: 1111111109, expected: "07081804"},
+		{time: 1111111111, expected: "14050471"},
+		{time: 1234567890, expected: "89005924"},
+		{time: 2000000000, expected: "69279037"},
+		{time: 20000000000, expected: "65353130"},
+	}
+	for _, c := range cases {
+		code := hotp(key, uint64(TOTPStep(time.Unix(c.time, 0))), 8)
+		if code != c.expected {
+			t.Errorf("at %v the code should be %v, got %v", c.time, c.expected, code)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("error when generating the secret: %v", err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, err := TOTPCode(secret, now)
+	if err != nil {
+		t.Fatalf("error when generating the code: %v", err)
+	}
+	if step, ok := ValidateTOTP(secret, code, now); !ok || step != TOTPStep(now) {
+		t.Errorf("the current code should be valid")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(30*time.Second)); !ok {
+		t.Errorf("the code of the previous step should still be accepted")
+	}
+	if _, ok := ValidateTOTP(secret, code, now.Add(2*time.Minute)); ok {
+		t.Errorf("an old code should be refused")
+	}
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Errorf("a code with the wrong length should be refused")
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, err := GenerateRecoveryCodes(10)
+	if err != nil {
+		t.Fatalf("error when generating the recovery codes: %v", err)
+	}
+	seen := map[string]bool{}
+	for _, code := range codes {
+		if len(code) != 11 || code[5] != '-' {
+			t.Errorf("unexpected recovery code format '%v'", code)
+		}
+		if !IsRecoveryCode(code) {
+			t.Errorf("the recovery code '%v' isn't recognized", code)
+		}
+		if seen[code] {
+			t.Errorf("the recovery code '%v' was generated twice", code)
+		}
+		seen[code] = true
+	}
+	if NormalizeRecoveryCode("ABCDE-fghij") != "abcdefghij" {
+		t.Errorf("recovery codes should be normalized")
+	}
+	for _, code := range []string{"123456", "abcde-fghi", "abcde-fgh1j", ""} {
+		if IsRecoveryCode(code) {
+			t.Errorf("'%v' shouldn't be taken for a recovery code", code)
+		}
+	}
+}
+
+func TestTokenPolicy(t *testing.T) {
+	clients, err := ParseClientLifetimes("web=15m/168h, mobile=/2160h")
+	if err != nil {
+		t.Fatalf("error when parsing the client lifetimes: %v", err)
+	}
+	policy := TokenPolicy{
+		Default: TokenLifetimes{Access: time.Hour, Refresh: 720 * time.Hour},
+		Max:     TokenLifetimes{Access: 2 * time.Hour, Refresh: 2160 * time.Hour},
+		Clients: clients,
+	}
+	if err := policy.Validate(); err != nil {
+		t.Fatalf("the policy should be valid: %v", err)
+	}
+	cases := []struct {
+		client    string
+		requested TokenLifetimes
+		expected  TokenLifetimes
+	}{
+		{client: "", expected: TokenLifetimes{Access: time.Hour, Refresh: 720 * time.Hour}},
+		{client: "web", expected: TokenLifetimes{Access: 15 * time.Minute, Refresh: 168 * time.Hour}},
+		{client: "mobile", expected: TokenLifetimes{Access: time.Hour, Refresh: 2160 * time.Hour}},
+		{client: "web", requested: TokenLifetimes{Access: 5 * time.Minute}, expected: TokenLifetimes{Access: 5 * time.Minute, Refresh: 168 * time.Hour}},
+		{client: "", requested: TokenLifetimes{Access: 10 * time.Hour, Refresh: 9000 * time.Hour}, expected: TokenLifetimes{Access: 2 * time.Hour, Refresh: 2160 * time.Hour}},
+	}
+	for _, c := range cases {
+		lifetimes, err := policy.Lifetimes(c.client, c.requested)
+		if err != nil || lifetimes != c.expected {
+			t.Errorf("client '%v' requesting %+v: expected %+v, got %+v (%v)", c.client, c.requested, c.expected, lifetimes, err)
+		}
+	}
+	if _, err := policy.Lifetimes("tv", TokenLifetimes{}); err == nil {
+		t.Errorf("an unknown client should be refused")
+	}
+	if _, err := ParseClientLifetimes("web=15m"); err == nil {
+		t.Errorf("a client without a refresh lifetime should not be parsed")
+	}
+	policy.Clients["web"] = TokenLifetimes{Access: 3 * time.Hour}
+	if err := policy.Validate(); err == nil {
+		t.Errorf("a client lifetime longer than the maximum should not be valid")
+	}
+	longPolicy := DefaultTokenPolicy
+	longPolicy.Max.Access = MaxAccessTokenLifetime + time.Hour
+	if err := longPolicy.Validate(); err == nil {
+		t.Errorf("a maximum access lifetime longer than the signing key grace period should not be valid")
+	}
+}
+
+func TestThrottlePolicy(t *testing.T) {
+	policy := ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        10 * time.Second,
+		LockoutAfter:    8,
+		LockoutDuration: time.Hour,
+	}
+	cases := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{failures: 1, expected: 0},
+		{failures: 2, expected: 0},
+		{failures: 3, expected: time.Second},
+		{failures: 4, expected: 2 * time.Second},
+		{failures: 5, expected: 4 * time.Second},
+		{failures: 6, expected: 8 * time.Second},
+		{failures: 7, expected: 10 * time.Second},
+		{failures: 8, expected: time.Hour},
+		{failures: 50, expected: time.Hour},
+	}
+	for _, c := range cases {
+		if blockedFor := policy.BlockedFor(c.failures); blockedFor != c.expected {
+			t.Errorf("after %v failures the attempts should be blocked for %v, not %v", c.failures, c.expected, blockedFor)
+		}
+	}
+	if !policy.IsLockout(8) || policy.IsLockout(9) {
+		t.Errorf("only the failure reaching the threshold should be the lockout")
+	}
+}
+
+func TestPasswordHash(t *testing.T) {
+	// longer than the 72 bytes bcrypt reads, the end of the password must count
+	password := strings.Repeat("a", 80) + "1"
+	hash, err := HashPassword(password)
+	if err != nil {
+		t.Fatalf("error when hashing the password: %v", err)
+	}
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected hash format '%v'", hash)
+	}
+	if err := CheckPasswordHash(password, hash); err != nil {
+		t.Errorf("the password should match its hash: %v", err)
+	}
+	if CheckPasswordHash(strings.Repeat("a", 80)+"2", hash) == nil {
+		t.Errorf("another password should not match the hash")
+	}
+	if otherHash, _ := HashPassword(password); otherHash == hash {
+		t.Errorf("two hashes of the same password should have different salts")
+	}
+	if NeedsRehash(hash) {
+		t.Errorf("a hash with the current parameters doesn't need to be rehashed")
+	}
+	if CheckPasswordHash(password, "$argon2id$v=19$m=19456,t=2$c2FsdA$a2V5") == nil || CheckPasswordHash(password, "plain") == nil {
+		t.Errorf("malformed hashes should be refused")
+	}
+}
+
+func TestLegacyPasswordHash(t *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("hunter2"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("error when creating the bcrypt hash: %v", err)
+	}
+	if err := CheckPasswordHash("hunter2", string(bcryptHash)); err != nil {
+		t.Errorf("the bcrypt hashes should still be accepted: %v", err)
+	}
+	if CheckPasswordHash("hunter3", string(bcryptHash)) == nil {
+		t.Errorf("a wrong password should not match the bcrypt hash")
+	}
+	if !NeedsRehash(string(bcryptHash)) {
+		t.Errorf("a bcrypt hash should be rehashed")
+	}
+
+	hash, _ := HashPassword("hunter2")
+	err = SetPasswordParams(PasswordParams{Memory: 32 * 1024, Iterations: 3, Parallelism: 1, SaltLength: 16, KeyLength: 32})
+	if err != nil {
+		t.Fatalf("error when setting the parameters: %v", err)
+	}
+	defer SetPasswordParams(DefaultPasswordParams)
+	if !NeedsRehash(hash) {
+		t.Errorf("a hash made with the old parameters should be rehashed")
+	}
+	if err := CheckPasswordHash("hunter2", hash); err != nil {
+		t.Errorf("a hash made with the old parameters should still be accepted: %v", err)
+	}
+	if SetPasswordParams(PasswordParams{Memory: 1024, Iterations: 0, Parallelism: 1, SaltLength: 16, KeyLength: 32}) == nil {
+		t.Errorf("invalid parameters should be refused")
+	}
+}
+
+func TestDummyPasswordHash(t *testing.T) {
+	if DummyPasswordHash() == "" || DummyPasswordHash() != DummyPasswordHash() {
+		t.Fatalf("the dummy hash should be created once")
+	}
+	if CheckPasswordHash("", DummyPasswordHash()) == nil {
+		t.Errorf("no password should match the dummy hash")
+	}
+}
+
+func TestApiKeys(t *testing.T) {
+	key, prefix, err := MakeApiKey()
+	if err != nil {
+		t.Fatalf("error when creating the API key: %v", err)
+	}
+	otherKey, _, _ := MakeApiKey()
+	if !strings.HasPrefix(key, prefix) || len(prefix) != len("chirpy_")+8 || key == otherKey {
+		t.Errorf("unexpected key '%v' with prefix '%v'", key, prefix)
+	}
+	if HashApiKey(key) == HashApiKey(otherKey) {
+		t.Errorf("two keys should not have the same hash")
+	}
+	header := http.Header{}
+	header.Add("Authorization", "ApiKey "+key)
+	receivedKey, _ := GetApiKey(header)
+	if !IsApiKeyAuthorization(header) || receivedKey != key {
+		t.Errorf("the API key should be read from the header, got '%v'", receivedKey)
+	}
+	header.Set("Authorization", "Bearer token")
+	if IsApiKeyAuthorization(header) {
+		t.Errorf("a bearer token is not an API key")
+	}
+	scopes, err := ParseScopes([]string{"chirps:read", "chirps:write"})
+	if err != nil || len(scopes) != 2 {
+		t.Errorf("the scopes should be parsed: %v", err)
+	}
+	if _, err := ParseScopes([]string{"chirps:read", "admin"}); err == nil {
+		t.Errorf("unknown scopes should be refused")
+	}
+	if _, err := ParseScopes(nil); err == nil {
+		t.Errorf("a key without scopes should be refused")
+	}
+	if !HasScope([]string{"chirps:read"}, ScopeChirpsRead) || HasScope([]string{"chirps:read"}, ScopeChirpsWrite) {
+		t.Errorf("HasScope should only accept the granted scopes")
+	}
+}
+
+func TestPKCE(t *testing.T) {
+	// the example of RFC 7636 appendix B
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	challenge := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if PKCEChallenge(verifier) != challenge {
+		t.Errorf("unexpected challenge '%v'", PKCEChallenge(verifier))
+	}
+	if err := VerifyPKCE(verifier, challenge); err != nil {
+		t.Errorf("the verifier should match its challenge: %v", err)
+	}
+	otherVerifier := strings.Repeat("a", 43)
+	if err := VerifyPKCE(otherVerifier, challenge); err == nil {
+		t.Errorf("another verifier should not match the challenge")
+	}
+	if err := VerifyPKCE("short", PKCEChallenge("short")); err == nil {
+		t.Errorf("a verifier shorter than 43 characters should be refused")
+	}
+	invalidVerifier := strings.Repeat("a", 42) + "/"
+	if err := VerifyPKCE(invalidVerifier, PKCEChallenge(invalidVerifier)); err == nil {
+		t.Errorf("a verifier with characters outside of the unreserved ones should be refused")
+	}
+}
+
+func TestOAuthScopes(t *testing.T) {
+	keyring := newTestKeyring(t, AlgEdDSA)
+	scopes, err := ParseScopeParam(" chirps:read  social:write ")
+	if err != nil || len(scopes) != 2 || scopes[1] != ScopeSocialWrite {
+		t.Errorf("unexpected scopes %v: %v", scopes, err)
+	}
+	if scopes, err := ParseScopeParam(""); err != nil || scopes != nil {
+		t.Errorf("an empty scope parameter should give no scopes, got %v: %v", scopes, err)
+	}
+	if _, err := ParseScopeParam("chirps:read admin"); err == nil {
+		t.Errorf("unknown scopes should be refused")
+	}
+	clientId := uuid.New()
+	token, err := MakeJWT(uuid.New(), keyring, time.Minute, WithClient(clientId, []string{"chirps:read", "social:write"}))
+	if err != nil {
+		t.Fatalf("error when creating the token: %v", err)
+	}
+	claims, err := ParseJWT(token, keyring)
+	if err != nil {
+		t.Fatalf("error when parsing the token: %v", err)
+	}
+	if claims.ClientId != clientId.String() || !HasScope(claims.Scopes(), ScopeSocialWrite) || HasScope(claims.Scopes(), ScopeChirpsWrite) {
+		t.Errorf("the token should carry the client and its scopes, got %+v", claims)
+	}
+	if (&Claims{Scope: "chirps:read"}).Scopes() != nil {
+		t.Errorf("a token without a client is not limited by scopes")
+	}
+}
+
+func TestValidateRedirectURI(t *testing.T) {
+	for redirectUri, valid := range map[string]bool{
+		"https://app.example.com/callback":    true,
+		"http://localhost:3000/callback":      true,
+		"http://127.0.0.1/callback":           true,
+		"http://app.example.com/callback":     false,
+		"https://app.example.com/callback#me": false,
+		"/callback":                           false,
+		"javascript:alert(1)":                 false,
+	} {
+		if err := ValidateRedirectURI(redirectUri); (err == nil) != valid {
+			t.Errorf("unexpected result for '%v': %v", redirectUri, err)
+		}
+	}
+}
+
+func TestWebhookSignature(t *testing.T) {
+	body := []byte(`{"id":"evt_1","event":"user.upgraded"}`)
+	signedAt := time.Now()
+	header := SignWebhook("secret", signedAt, body)
+	if err := VerifyWebhookSignature("secret", header, body, 5*time.Minute, signedAt.Add(time.Minute)); err != nil {
+		t.Errorf("the signature should be valid: %v", err)
+	}
+	if err := VerifyWebhookSignature("other secret", header, body, 5*time.Minute, signedAt); err != ErrInvalidSignature {
+		t.Errorf("a signature made with another secret should be refused, got %v", err)
+	}
+	if err := VerifyWebhookSignature("secret", header, []byte(`{"id":"evt_1","event":"user.refunded"}`), 5*time.Minute, signedAt); err != ErrInvalidSignature {
+		t.Errorf("a changed body should be refused, got %v", err)
+	}
+	if err := VerifyWebhookSignature("secret", header, body, 5*time.Minute, signedAt.Add(10*time.Minute)); err != ErrStaleSignature {
+		t.Errorf("an old signature should be refused, got %v", err)
+	}
+	rotatedHeader := header + ",v1=" + strings.Repeat("0", 64)
+	if err := VerifyWebhookSignature("secret", rotatedHeader, body, 5*time.Minute, signedAt); err != nil {
+		t.Errorf("one matching signature should be enough: %v", err)
+	}
+	replayedHeader := strings.Replace(header, fmt.Sprintf("t=%d", signedAt.Unix()), fmt.Sprintf("t=%d", signedAt.Unix()+60), 1)
+	if err := VerifyWebhookSignature("secret", replayedHeader, body, 5*time.Minute, signedAt); err != ErrInvalidSignature {
+		t.Errorf("a new timestamp on an old signature should be refused, got %v", err)
+	}
+	if VerifyWebhookSignature("secret", "v1=abc", body, 5*time.Minute, signedAt) == nil {
+		t.Errorf("a header without a timestamp should be refused")
+	}
+}
+
+func TestWebhookDeliveryId(t *testing.T) {
+	// two monthly renewals without an event id have the same body, only their signatures tell them apart
+	renewal := []byte(`{"event":"user.renewed","data":{"user_id":"3311741c-680c-4546-99f3-fc9efac2036c"}}`)
+	firstRenewal := SignWebhook("secret", time.Unix(1760000000, 0), renewal)
+	secondRenewal := SignWebhook("secret", time.Unix(1762592000, 0), renewal)
+	firstId, err := WebhookDeliveryId(firstRenewal, renewal)
+	if err != nil {
+		t.Fatalf("error when identifying the delivery: %v", err)
+	}
+	secondId, err := WebhookDeliveryId(secondRenewal, renewal)
+	if err != nil {
+		t.Fatalf("error when identifying the delivery: %v", err)
+	}
+	if firstId == secondId {
+		t.Errorf("two identical renewals signed at different times should get different ids")
+	}
+	redeliveredId, err := WebhookDeliveryId(firstRenewal, renewal)
+	if err != nil || redeliveredId != firstId {
+		t.Errorf("the same delivery should get the same id, got %v and %v (%v)", firstId, redeliveredId, err)
+	}
+	if _, err := WebhookDeliveryId("", renewal); err == nil {
+		t.Errorf("a delivery without a signature should not be identified")
+	}
+}
@@ -1,12 +1,127 @@
 package auth
 
-import "golang.org/x/crypto/bcrypt"
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
 
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordParams are the argon2id parameters of the new password hashes. They are written in each hash, so changing
+// them keeps the old hashes valid until NeedsRehash upgrades them
+type PasswordParams struct {
+	Memory      uint32 // in KiB
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+// DefaultPasswordParams follow the OWASP recommendation for argon2id
+var DefaultPasswordParams = PasswordParams{Memory: 19 * 1024, Iterations: 2, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+
+var (
+	ErrMismatchedPassword = errors.New("the password doesn't match the hash")
+	ErrUnknownHashFormat  = errors.New("unknown password hash format")
+)
+
+// the passwords are hashed with these parameters, SetPasswordParams changes them when the server starts
+var passwordParams = DefaultPasswordParams
+
+func SetPasswordParams(params PasswordParams) error {
+	if params.Memory < 8*uint32(params.Parallelism) || params.Iterations < 1 || params.Parallelism < 1 {
+		return fmt.Errorf("argon2id needs at least 1 iteration, 1 thread and 8 KiB of memory per thread")
+	}
+	if params.SaltLength < 16 || params.KeyLength < 16 {
+		return fmt.Errorf("the salt and the key should be at least 16 bytes")
+	}
+	passwordParams = params
+	return nil
+}
+
+// HashPassword gives an argon2id hash in the PHC string format, like
+// "$argon2id$v=19$m=19456,t=2,p=1$<salt>$<key>" with the salt and the key in unpadded base64
 func HashPassword(password string) (string, error) {
-	genPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	return string(genPassword), err
+	params := passwordParams
+	salt := make([]byte, params.SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+	key := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", argon2.Version, params.Memory, params.Iterations, params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
 }
 
+// CheckPasswordHash accepts the argon2id hashes and the bcrypt ones made before argon2id was used
 func CheckPasswordHash(password, hash string) error {
-	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	if strings.HasPrefix(hash, "$2") {
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+	}
+	params, salt, key, err := decodeArgon2Hash(hash)
+	if err != nil {
+		return err
+	}
+	passwordKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, params.KeyLength)
+	if subtle.ConstantTimeCompare(passwordKey, key) != 1 {
+		return ErrMismatchedPassword
+	}
+	return nil
+}
+
+// NeedsRehash tells whether the hash was made with bcrypt or with other parameters than the current ones. It is only
+// known to be outdated, the password should be checked before hashing it again
+func NeedsRehash(hash string) bool {
+	params, _, _, err := decodeArgon2Hash(hash)
+	return err != nil || params != passwordParams
+}
+
+func decodeArgon2Hash(hash string) (params PasswordParams, salt []byte, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || parts[0] != "" || parts[1] != "argon2id" {
+		return PasswordParams{}, nil, nil, ErrUnknownHashFormat
+	}
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return PasswordParams{}, nil, nil, fmt.Errorf("unsupported argon2 version '%v'", parts[2])
+	}
+	_, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism)
+	if err != nil {
+		return PasswordParams{}, nil, nil, fmt.Errorf("invalid argon2 parameters '%v': %w", parts[3], err)
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return PasswordParams{}, nil, nil, fmt.Errorf("invalid argon2 salt: %w", err)
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return PasswordParams{}, nil, nil, fmt.Errorf("invalid argon2 key: %w", err)
+	}
+	params.SaltLength = uint32(len(salt))
+	params.KeyLength = uint32(len(key))
+	return params, salt, key, nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     string
+)
+
+// DummyPasswordHash is compared with the password when the email belongs to no user, so a login for an unknown
+// email takes as long as a wrong password and doesn't tell which emails have an account
+func DummyPasswordHash() string {
+	dummyHashOnce.Do(func() {
+		randomPassword := make([]byte, 16)
+		rand.Read(randomPassword)
+		dummyHash, _ = HashPassword(hex.EncodeToString(randomPassword))
+	})
+	return dummyHash
 }
@@ -1,35 +1,105 @@
 package auth
 
 import (
+	"errors"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
 )
 
-func MakeJWT(userId uuid.UUID, tokenSecret string, expiresIn time.Duration) (string, error) {
-	claim := &jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().Add(expiresIn).UTC()),
-		Subject:   userId.String(), //the data we actually want
+// Claims are the registered claims plus the ones chirpy adds to its access tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	Role      Role   `json:"role,omitempty"`
+	SessionId string `json:"sid,omitempty"` // the refresh token family the access token was issued from
+	Purpose   string `json:"purpose,omitempty"`
+	// set on the tokens issued to an OAuth client, the scopes are space separated (RFC 9068)
+	ClientId string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// tokens with a purpose are only good for one step of a flow, like proving the password before the second factor.
+// They are never accepted as access tokens
+const PurposeTwoFactor = "2fa"
+
+var ErrTokenPurpose = errors.New("the token was issued for another purpose")
+
+type ClaimOption func(*Claims)
+
+func WithRole(role Role) ClaimOption {
+	return func(claims *Claims) {
+		claims.Role = role
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claim)
-	tokenString, err := token.SignedString([]byte(tokenSecret))
+}
+
+func WithSessionId(sessionId uuid.UUID) ClaimOption {
+	return func(claims *Claims) {
+		claims.SessionId = sessionId.String()
+	}
+}
+
+func WithPurpose(purpose string) ClaimOption {
+	return func(claims *Claims) {
+		claims.Purpose = purpose
+	}
+}
+
+func MakeJWT(userId uuid.UUID, keyring *Keyring, expiresIn time.Duration, options ...ClaimOption) (string, error) {
+	now := time.Now()
+	signingKey, err := keyring.SigningKey(now)
+	if err != nil {
+		return "", err
+	}
+	claim := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    keyring.issuer,
+			Audience:  jwt.ClaimStrings{keyring.audience},
+			IssuedAt:  jwt.NewNumericDate(now.UTC()),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn).UTC()),
+			Subject:   userId.String(), //the data we actually want
+		},
+	}
+	for _, option := range options {
+		option(claim)
+	}
+	token := jwt.NewWithClaims(signingKey.signingMethod(), claim)
+	token.Header["kid"] = signingKey.Id
+	tokenString, err := token.SignedString(signingKey.PrivateKey)
 	if err != nil {
 		return "", err
 	}
 	return tokenString, nil
 }
 
-func ValidateJWT(tokenString, tokenSecret string) (uuid.UUID, error) {
-	claim := &jwt.RegisteredClaims{}
-	_, err := jwt.ParseWithClaims(tokenString, claim, func(token *jwt.Token) (interface{}, error) {
-		return []byte(tokenSecret), nil
-	})
+// ParseJWT validates the token and returns all of its claims, ValidateJWT is enough when only the user is needed
+func ParseJWT(tokenString string, keyring *Keyring) (*Claims, error) {
+	claim := &Claims{}
+	_, err := jwt.ParseWithClaims(tokenString, claim, keyring.keyfunc,
+		jwt.WithValidMethods([]string{AlgEdDSA, AlgRS256}),
+		jwt.WithIssuer(keyring.issuer),
+		jwt.WithAudience(keyring.audience),
+		jwt.WithExpirationRequired(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	return claim, nil
+}
+
+func ValidateJWT(tokenString string, keyring *Keyring) (uuid.UUID, error) {
+	return ValidatePurposeJWT(tokenString, keyring, "")
+}
+
+// ValidatePurposeJWT only accepts the tokens issued for the purpose, an empty purpose is for access tokens
+func ValidatePurposeJWT(tokenString string, keyring *Keyring, purpose string) (uuid.UUID, error) {
+	claim, err := ParseJWT(tokenString, keyring)
 	if err != nil {
 		return uuid.UUID{}, err
 	}
+	if claim.Purpose != purpose {
+		return uuid.UUID{}, ErrTokenPurpose
+	}
 	userId, err := uuid.Parse(claim.Subject)
 	if err != nil {
 		return uuid.UUID{}, err
@@ -0,0 +1,206 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AlgEdDSA = "EdDSA"
+	AlgRS256 = "RS256"
+)
+
+var ErrNoSigningKey = errors.New("no signing key is active")
+
+// SigningKey is one key of the keyring. It signs tokens between SignsFrom and SignsUntil, then keeps validating
+// the tokens it signed until ValidUntil. It is published in the JWKS as soon as it is in the keyring, so the
+// services verifying our tokens can fetch it before it signs anything
+type SigningKey struct {
+	Id         string
+	Algorithm  string
+	PrivateKey crypto.Signer
+	SignsFrom  time.Time
+	SignsUntil time.Time
+	ValidUntil time.Time
+}
+
+func (k SigningKey) signingMethod() jwt.SigningMethod {
+	if k.Algorithm == AlgRS256 {
+		return jwt.SigningMethodRS256
+	}
+	return jwt.SigningMethodEdDSA
+}
+
+// Keyring holds the keys used to sign and validate the tokens, it can be replaced at runtime when the keys rotate
+type Keyring struct {
+	issuer   string
+	audience string
+	mu       sync.RWMutex
+	keys     []SigningKey
+}
+
+func NewKeyring(issuer, audience string, keys ...SigningKey) *Keyring {
+	keyring := &Keyring{issuer: issuer, audience: audience}
+	keyring.Replace(keys)
+	return keyring
+}
+
+func (k *Keyring) Replace(keys []SigningKey) {
+	sortedKeys := append([]SigningKey{}, keys...)
+	sort.Slice(sortedKeys, func(i, j int) bool {
+		return sortedKeys[i].SignsFrom.After(sortedKeys[j].SignsFrom)
+	})
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = sortedKeys
+}
+
+// Keys are sorted from the newest to the oldest
+func (k *Keyring) Keys() []SigningKey {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return append([]SigningKey{}, k.keys...)
+}
+
+// SigningKey is the newest key whose signing window contains the time
+func (k *Keyring) SigningKey(now time.Time) (SigningKey, error) {
+	for _, key := range k.Keys() {
+		if !now.Before(key.SignsFrom) && now.Before(key.SignsUntil) {
+			return key, nil
+		}
+	}
+	return SigningKey{}, ErrNoSigningKey
+}
+
+// keyfunc finds the key of the token by its kid. The algorithm of the token has to be the one of the key,
+// so a token can't make us verify an HMAC with a public key or skip the signature with "none"
+func (k *Keyring) keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		return nil, errors.New("the token has no kid")
+	}
+	now := time.Now()
+	for _, key := range k.Keys() {
+		if key.Id != kid {
+			continue
+		}
+		if token.Method.Alg() != key.Algorithm {
+			return nil, fmt.Errorf("the key %v signs with %v, not %v", kid, key.Algorithm, token.Method.Alg())
+		}
+		if !now.Before(key.ValidUntil) {
+			return nil, fmt.Errorf("the key %v has expired", kid)
+		}
+		return key.PrivateKey.Public(), nil
+	}
+	return nil, fmt.Errorf("unknown key %v", kid)
+}
+
+// JWK is the public part of a key as published in /.well-known/jwks.json (RFC 7517)
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"` // for OKP keys
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"` // for RSA keys
+	E   string `json:"e,omitempty"`
+}
+
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS publishes every key that signs now, will sign later, or still validates tokens
+func (k *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	now := time.Now()
+	for _, key := range k.Keys() {
+		if !now.Before(key.ValidUntil) {
+			continue
+		}
+		jwk := JWK{Kid: key.Id, Use: "sig", Alg: key.Algorithm}
+		switch publicKey := key.PrivateKey.Public().(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	return set
+}
+
+func GenerateSigningKey(algorithm string, signsFrom, signsUntil, validUntil time.Time) (SigningKey, error) {
+	var privateKey crypto.Signer
+	var err error
+	switch algorithm {
+	case AlgEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case AlgRS256:
+		privateKey, err = rsa.GenerateKey(rand.Reader, 2048)
+	default:
+		return SigningKey{}, fmt.Errorf("unsupported signing algorithm '%v', expected EdDSA or RS256", algorithm)
+	}
+	if err != nil {
+		return SigningKey{}, err
+	}
+	id := make([]byte, 8)
+	_, err = rand.Read(id)
+	if err != nil {
+		return SigningKey{}, err
+	}
+	return SigningKey{
+		Id:         hex.EncodeToString(id),
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+		SignsFrom:  signsFrom,
+		SignsUntil: signsUntil,
+		ValidUntil: validUntil,
+	}, nil
+}
+
+// EncodePrivateKey gives the PKCS #8 PEM of the key, to store it
+func EncodePrivateKey(key crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func ParsePrivateKey(encodedKey string) (crypto.Signer, error) {
+	block, _ := pem.Decode([]byte(encodedKey))
+	if block == nil {
+		return nil, errors.New("the private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("the private key can't sign")
+	}
+	return signer, nil
+}
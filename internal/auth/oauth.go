@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+const PKCEMethodS256 = "S256"
+
+var ErrInvalidRedirectURI = errors.New("a redirect URI should be an absolute https URL, or http on localhost, without a fragment")
+
+// WithClient marks an access token issued to an OAuth client, it only allows what its scopes grant
+func WithClient(clientId uuid.UUID, scopes []string) ClaimOption {
+	return func(claims *Claims) {
+		claims.ClientId = clientId.String()
+		claims.Scope = strings.Join(scopes, " ")
+	}
+}
+
+// Scopes of the token, nil for the tokens of a logged in user who can do everything
+func (c *Claims) Scopes() []string {
+	if c.ClientId == "" {
+		return nil
+	}
+	return strings.Fields(c.Scope)
+}
+
+// ParseScopeParam reads the space separated scopes of OAuth (RFC 6749 section 3.3), an empty parameter gives no
+// scopes and lets the caller pick the default ones
+func ParseScopeParam(scope string) ([]Scope, error) {
+	if strings.TrimSpace(scope) == "" {
+		return nil, nil
+	}
+	return ParseScopes(strings.Fields(scope))
+}
+
+// PKCEChallenge is the S256 challenge of a verifier (RFC 7636 section 4.2)
+func PKCEChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// VerifyPKCE checks the verifier sent with the code matches the challenge sent with the authorization request.
+// Only S256 is supported, the plain method would send the verifier in the clear
+func VerifyPKCE(verifier, challenge string) error {
+	if len(verifier) < 43 || len(verifier) > 128 {
+		return fmt.Errorf("the code verifier should be between 43 and 128 characters")
+	}
+	for _, char := range verifier {
+		if !(char >= 'a' && char <= 'z' || char >= 'A' && char <= 'Z' || char >= '0' && char <= '9' || strings.ContainsRune("-._~", char)) {
+			return fmt.Errorf("the code verifier contains an invalid character")
+		}
+	}
+	if PKCEChallenge(verifier) != challenge {
+		return fmt.Errorf("the code verifier doesn't match the code challenge")
+	}
+	return nil
+}
+
+// ValidateRedirectURI checks a redirect URI given when registering a client
+func ValidateRedirectURI(redirectUri string) error {
+	parsedUri, err := url.Parse(redirectUri)
+	if err != nil || !parsedUri.IsAbs() || parsedUri.Fragment != "" || parsedUri.Host == "" {
+		return ErrInvalidRedirectURI
+	}
+	hostname := parsedUri.Hostname()
+	isLocal := hostname == "localhost" || hostname == "127.0.0.1" || hostname == "::1"
+	if parsedUri.Scheme != "https" && !(parsedUri.Scheme == "http" && isLocal) {
+		return ErrInvalidRedirectURI
+	}
+	return nil
+}
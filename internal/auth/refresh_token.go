@@ -2,6 +2,7 @@ package auth
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 )
 
@@ -13,3 +14,10 @@ func MakeRefreshToken() (string, error) { // just a random number that we return
 	}
 	return hex.EncodeToString(refreshToken), nil
 }
+
+// HashRefreshToken gives what is stored instead of the token, a leaked table can't be used to refresh sessions.
+// The tokens are random enough that a plain SHA-256 is sufficient, no salt or slow hash is needed
+func HashRefreshToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
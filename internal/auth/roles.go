@@ -0,0 +1,45 @@
+package auth
+
+import "fmt"
+
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+type Permission string
+
+const (
+	PermModerate        Permission = "moderate"         // review reports and held chirps, hide chirps, suspend users
+	PermManageWordLists Permission = "manage_wordlists" // edit the words of the moderation chain
+	PermViewMetrics     Permission = "view_metrics"
+	PermManageRoles     Permission = "manage_roles"
+	PermResetDatabase   Permission = "reset_database"
+	PermManageLockouts  Permission = "manage_lockouts" // see and lift the login lockouts
+	PermManageWebhooks  Permission = "manage_webhooks" // inspect the received webhook events, manage the outbound subscriptions
+)
+
+var rolePermissions = map[Role][]Permission{
+	RoleUser:      {},
+	RoleModerator: {PermModerate},
+	RoleAdmin:     {PermModerate, PermManageWordLists, PermViewMetrics, PermManageRoles, PermResetDatabase, PermManageLockouts, PermManageWebhooks},
+}
+
+func ParseRole(role string) (Role, error) {
+	if _, ok := rolePermissions[Role(role)]; !ok {
+		return "", fmt.Errorf("unknown role '%v', expected user, moderator or admin", role)
+	}
+	return Role(role), nil
+}
+
+func (r Role) Can(permission Permission) bool {
+	for _, granted := range rolePermissions[r] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
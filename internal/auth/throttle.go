@@ -0,0 +1,66 @@
+package auth
+
+import "time"
+
+// ThrottlePolicy slows down the login attempts of an account or an IP address after a few failures: every failure
+// doubles the wait before the next attempt, until enough failures lock the attempts out for a while
+type ThrottlePolicy struct {
+	FreeAttempts    int // failures allowed before any wait
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAfter    int // failures after which the attempts are locked out
+	LockoutDuration time.Duration
+}
+
+var (
+	AccountThrottle = ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        5 * time.Minute,
+		LockoutAfter:    10,
+		LockoutDuration: 15 * time.Minute,
+	}
+	// an IP address can be shared by many users, it gets more attempts before being slowed down
+	IpThrottle = ThrottlePolicy{
+		FreeAttempts:    20,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAfter:    100,
+		LockoutDuration: time.Hour,
+	}
+	// every password reset request sends an email, an address only gets a few of them a day
+	ResetEmailThrottle = ThrottlePolicy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		LockoutAfter:    10,
+		LockoutDuration: 24 * time.Hour,
+	}
+	ResetIpThrottle = ThrottlePolicy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		LockoutAfter:    50,
+		LockoutDuration: 24 * time.Hour,
+	}
+)
+
+// BlockedFor is how long the attempts are refused after the last of the failures
+func (p ThrottlePolicy) BlockedFor(failures int) time.Duration {
+	if failures >= p.LockoutAfter {
+		return p.LockoutDuration
+	}
+	if failures < p.FreeAttempts {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := p.FreeAttempts; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// IsLockout tells whether this failure is the one locking the attempts out, to notify it only once
+func (p ThrottlePolicy) IsLockout(failures int) bool {
+	return failures == p.LockoutAfter
+}
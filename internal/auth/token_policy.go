@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// TokenLifetimes are how long the access token and the refresh token of a session last
+type TokenLifetimes struct {
+	Access  time.Duration
+	Refresh time.Duration
+}
+
+// TokenPolicy decides the lifetime of the tokens. A client can ask for shorter or longer tokens than the default of
+// its kind, but never longer than the maximum
+type TokenPolicy struct {
+	Default TokenLifetimes
+	Max     TokenLifetimes
+	// the defaults of each kind of client ("web", "mobile", ...), the ones missing use Default
+	Clients map[string]TokenLifetimes
+}
+
+// MaxAccessTokenLifetime bounds the maximum of the access tokens: a signing key keeps validating the tokens it signed
+// this long after it stops signing, a longer lived token would be refused before it expires
+const MaxAccessTokenLifetime = 24 * time.Hour
+
+var DefaultTokenPolicy = TokenPolicy{
+	Default: TokenLifetimes{Access: time.Hour, Refresh: 60 * 24 * time.Hour},
+	Max:     TokenLifetimes{Access: MaxAccessTokenLifetime, Refresh: 90 * 24 * time.Hour},
+}
+
+// Lifetimes gives the lifetimes for the kind of client, a zero requested duration takes the default of the client
+func (p TokenPolicy) Lifetimes(client string, requested TokenLifetimes) (TokenLifetimes, error) {
+	lifetimes := p.Default
+	if client != "" {
+		clientLifetimes, ok := p.Clients[client]
+		if !ok {
+			return TokenLifetimes{}, fmt.Errorf("unknown client '%v'", client)
+		}
+		lifetimes = clientLifetimes
+	}
+	if requested.Access > 0 {
+		lifetimes.Access = requested.Access
+	}
+	if requested.Refresh > 0 {
+		lifetimes.Refresh = requested.Refresh
+	}
+	return TokenLifetimes{
+		Access:  min(lifetimes.Access, p.Max.Access),
+		Refresh: min(lifetimes.Refresh, p.Max.Refresh),
+	}, nil
+}
+
+// ParseClientLifetimes reads the lifetimes of each kind of client written as "web=15m/168h,mobile=1h/2160h",
+// the access token lifetime first. A lifetime left empty ("cli=/720h") uses the default
+func ParseClientLifetimes(rawClients string) (map[string]TokenLifetimes, error) {
+	clients := map[string]TokenLifetimes{}
+	for _, rawClient := range strings.Split(rawClients, ",") {
+		rawClient = strings.TrimSpace(rawClient)
+		if rawClient == "" {
+			continue
+		}
+		client, rawLifetimes, found := strings.Cut(rawClient, "=")
+		rawAccess, rawRefresh, hasRefresh := strings.Cut(rawLifetimes, "/")
+		if !found || !hasRefresh || strings.TrimSpace(client) == "" {
+			return nil, fmt.Errorf("'%v' should look like 'client=access/refresh'", rawClient)
+		}
+		var lifetimes TokenLifetimes
+		var err error
+		if rawAccess != "" {
+			lifetimes.Access, err = time.ParseDuration(rawAccess)
+			if err != nil {
+				return nil, fmt.Errorf("invalid access token lifetime for '%v': %w", client, err)
+			}
+		}
+		if rawRefresh != "" {
+			lifetimes.Refresh, err = time.ParseDuration(rawRefresh)
+			if err != nil {
+				return nil, fmt.Errorf("invalid refresh token lifetime for '%v': %w", client, err)
+			}
+		}
+		clients[strings.TrimSpace(client)] = lifetimes
+	}
+	return clients, nil
+}
+
+// Validate fills the lifetimes the clients left empty with the defaults and checks they are all within the maximums
+func (p *TokenPolicy) Validate() error {
+	if p.Default.Access <= 0 || p.Default.Refresh <= 0 {
+		return fmt.Errorf("the default token lifetimes should be positive")
+	}
+	if p.Max.Access > MaxAccessTokenLifetime {
+		return fmt.Errorf("the access tokens can't live longer than %v", MaxAccessTokenLifetime)
+	}
+	if p.Default.Access > p.Max.Access || p.Default.Refresh > p.Max.Refresh {
+		return fmt.Errorf("the default token lifetimes should not be longer than the maximums")
+	}
+	for client, lifetimes := range p.Clients {
+		if lifetimes.Access == 0 {
+			lifetimes.Access = p.Default.Access
+		}
+		if lifetimes.Refresh == 0 {
+			lifetimes.Refresh = p.Default.Refresh
+		}
+		if lifetimes.Access < 0 || lifetimes.Refresh < 0 || lifetimes.Access > p.Max.Access || lifetimes.Refresh > p.Max.Refresh {
+			return fmt.Errorf("the token lifetimes of '%v' should be positive and not longer than the maximums", client)
+		}
+		p.Clients[client] = lifetimes
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP as described by RFC 6238 with the parameters every authenticator app supports: HMAC-SHA1, 6 digits, 30 seconds steps
+const (
+	totpDigits = 6
+	totpPeriod = 30
+	totpSkew   = 1 // steps accepted before and after the current one, for clocks that drifted a bit
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+func GenerateTOTPSecret() (string, error) {
+	secret := make([]byte, 20) // the size of a SHA1 block, as recommended by RFC 4226
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(secret), nil
+}
+
+// TOTPProvisioningURI is the otpauth:// URI the authenticator apps read, usually shown as a QR code
+func TOTPProvisioningURI(secret, issuer, accountName string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(totpDigits))
+	query.Set("period", fmt.Sprint(totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(accountName)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+func TOTPCode(secret string, t time.Time) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", fmt.Errorf("malformed TOTP secret: %w", err)
+	}
+	return hotp(key, uint64(TOTPStep(t)), totpDigits), nil
+}
+
+// ValidateTOTP returns the step the code was generated for, so the callers can refuse a code that was already used
+func ValidateTOTP(secret, code string, t time.Time) (int64, bool) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+	currentStep := TOTPStep(t)
+	for step := currentStep - totpSkew; step <= currentStep+totpSkew; step++ {
+		expected := hotp(key, uint64(step), totpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// hotp is the HOTP algorithm of RFC 4226, TOTP only replaces the counter by a time step
+func hotp(key []byte, counter uint64, digits int) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	truncated := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, truncated%modulo)
+}
+
+const recoveryCodeLength = 10
+
+// GenerateRecoveryCodes gives single use codes to log in without the authenticator, formatted like "abcde-fghij"
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	codes := make([]string, count)
+	for i := range codes {
+		random := make([]byte, 7)
+		_, err := rand.Read(random)
+		if err != nil {
+			return nil, err
+		}
+		code := strings.ToLower(totpEncoding.EncodeToString(random))[:recoveryCodeLength]
+		codes[i] = code[:5] + "-" + code[5:]
+	}
+	return codes, nil
+}
+
+// NormalizeRecoveryCode lets the users type the codes with any case, spaces or dashes
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToLower(code))
+}
+
+// IsRecoveryCode tells whether the code has the format of the recovery codes, only those are worth comparing to their
+// slow hashes
+func IsRecoveryCode(code string) bool {
+	normalizedCode := NormalizeRecoveryCode(code)
+	if len(normalizedCode) != recoveryCodeLength {
+		return false
+	}
+	for _, r := range normalizedCode {
+		if (r < 'a' || r > 'z') && (r < '2' || r > '7') {
+			return false
+		}
+	}
+	return true
+}
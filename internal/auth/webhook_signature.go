@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("the webhook signature doesn't match the body")
+	ErrStaleSignature   = errors.New("the webhook signature is too old or too far in the future")
+)
+
+// SignWebhook gives the signature header of a webhook, "t=<unix time>,v1=<hex HMAC-SHA256>". The timestamp is
+// signed along with the raw body, so an old delivery can't be replayed with a new timestamp
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp.Unix(), webhookMac(secret, timestamp.Unix(), body))
+}
+
+// VerifyWebhookSignature checks the signature header against the raw body. A header can hold several v1 signatures
+// while the sender rotates its secret, one matching is enough
+func VerifyWebhookSignature(secret, header string, body []byte, tolerance time.Duration, now time.Time) error {
+	timestamp, signatures, err := parseWebhookSignature(header)
+	if err != nil {
+		return err
+	}
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > tolerance || age < -tolerance {
+		return ErrStaleSignature
+	}
+	expected := []byte(webhookMac(secret, timestamp, body))
+	for _, signature := range signatures {
+		if hmac.Equal([]byte(signature), expected) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+// WebhookDeliveryId identifies a signed delivery by its timestamp and its body, for the events sent without an id.
+// The same delivery sent twice gets the same id, while two identical events (like two monthly renewals) are told
+// apart by the time they were signed
+func WebhookDeliveryId(header string, body []byte) (string, error) {
+	timestamp, _, err := parseWebhookSignature(header)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	fmt.Fprintf(hash, "%d.", timestamp)
+	hash.Write(body)
+	return "sha256:" + hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func parseWebhookSignature(header string) (timestamp int64, signatures []string, err error) {
+	hasTimestamp := false
+	for _, part := range strings.Split(header, ",") {
+		key, value, found := strings.Cut(strings.TrimSpace(part), "=")
+		if !found {
+			continue
+		}
+		switch key {
+		case "t":
+			timestamp, err = strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return 0, nil, fmt.Errorf("invalid webhook signature timestamp: %w", err)
+			}
+			hasTimestamp = true
+		case "v1":
+			signatures = append(signatures, value)
+		}
+	}
+	if !hasTimestamp || len(signatures) == 0 {
+		return 0, nil, fmt.Errorf("the webhook signature header should look like 't=<timestamp>,v1=<signature>'")
+	}
+	return timestamp, signatures, nil
+}
+
+func webhookMac(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
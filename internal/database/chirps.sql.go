@@ -7,24 +7,48 @@ package database
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
+const chirpHasReplies = `-- name: ChirpHasReplies :one
+SELECT EXISTS(SELECT 1 FROM chirps WHERE in_reply_to= $1)
+`
+
+func (q *Queries) ChirpHasReplies(ctx context.Context, inReplyTo uuid.NullUUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, chirpHasReplies, inReplyTo)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
 const createChirp = `-- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, root_id, quote_of, status)
 VALUES (
-    gen_random_uuid(), NOW(), NOW(), $1, $2
-) RETURNING id, created_at, updated_at, body, user_id
+    gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5, $6
+) RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status
 `
 
 type CreateChirpParams struct {
-	Body   string
-	UserID uuid.UUID
+	Body      string
+	UserID    uuid.UUID
+	InReplyTo uuid.NullUUID
+	RootID    uuid.NullUUID
+	QuoteOf   uuid.NullUUID
+	Status    string
 }
 
 func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, createChirp, arg.Body, arg.UserID)
+	row := q.db.QueryRowContext(ctx, createChirp,
+		arg.Body,
+		arg.UserID,
+		arg.InReplyTo,
+		arg.RootID,
+		arg.QuoteOf,
+		arg.Status,
+	)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -32,6 +56,15 @@ func (q *Queries) CreateChirp(ctx context.Context, arg CreateChirpParams) (Chirp
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.QuoteOf,
+		&i.LikeCount,
+		&i.RechirpCount,
+		&i.ReplyCount,
+		&i.Status,
 	)
 	return i, err
 }
@@ -45,12 +78,183 @@ func (q *Queries) DeleteChirpWithId(ctx context.Context, id uuid.UUID) error {
 	return err
 }
 
-const getAllChirps = `-- name: GetAllChirps :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps ORDER BY created_at ASC
+const getChirpAncestors = `-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps parent JOIN chirps child ON child.in_reply_to= parent.id
+    WHERE child.id= $1
+    UNION ALL
+    SELECT chirps.id, chirps.in_reply_to, ancestors.depth + 1
+    FROM chirps JOIN ancestors ON chirps.id= ancestors.in_reply_to
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.in_reply_to, chirps.root_id, chirps.deleted_at, chirps.quote_of, chirps.like_count, chirps.rechirp_count, chirps.reply_count, chirps.status FROM ancestors JOIN chirps ON chirps.id= ancestors.id
+ORDER BY ancestors.depth DESC
+`
+
+func (q *Queries) GetChirpAncestors(ctx context.Context, chirpID uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpAncestors, chirpID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpById = `-- name: GetChirpById :one
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps WHERE id= $1 LIMIT 1
+`
+
+func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpById, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.QuoteOf,
+		&i.LikeCount,
+		&i.RechirpCount,
+		&i.ReplyCount,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getChirpByIdForUpdate = `-- name: GetChirpByIdForUpdate :one
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps WHERE id= $1 LIMIT 1 FOR UPDATE
+`
+
+func (q *Queries) GetChirpByIdForUpdate(ctx context.Context, id uuid.UUID) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, getChirpByIdForUpdate, id)
+	var i Chirp
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Body,
+		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.QuoteOf,
+		&i.LikeCount,
+		&i.RechirpCount,
+		&i.ReplyCount,
+		&i.Status,
+	)
+	return i, err
+}
+
+const getChirpDescendants = `-- name: GetChirpDescendants :many
+WITH RECURSIVE descendants AS (
+    SELECT id, 1 AS depth, to_char(created_at, 'YYYYMMDDHH24MISSUS') || id::text AS path
+    FROM chirps WHERE in_reply_to= $1
+    UNION ALL
+    SELECT chirps.id, descendants.depth + 1, descendants.path || '/' || to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text
+    FROM chirps JOIN descendants ON chirps.in_reply_to= descendants.id
+)
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.in_reply_to, chirps.root_id, chirps.deleted_at, descendants.depth::int AS depth, descendants.path::text AS path
+FROM descendants JOIN chirps ON chirps.id= descendants.id
+WHERE ($2::text IS NULL OR descendants.path COLLATE "C" > $2::text)
+ORDER BY descendants.path COLLATE "C"
+LIMIT $3
 `
 
-func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirps)
+type GetChirpDescendantsParams struct {
+	ChirpID   uuid.NullUUID
+	AfterPath sql.NullString
+	RowLimit  int32
+}
+
+type GetChirpDescendantsRow struct {
+	Chirp Chirp
+	Depth int32
+	Path  string
+}
+
+// the path orders the replies depth first (each level sorted by creation date), so a page is always a piece of the tree
+func (q *Queries) GetChirpDescendants(ctx context.Context, arg GetChirpDescendantsParams) ([]GetChirpDescendantsRow, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpDescendants, arg.ChirpID, arg.AfterPath, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetChirpDescendantsRow
+	for rows.Next() {
+		var i GetChirpDescendantsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.SearchVector,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.RootID,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.QuoteOf,
+			&i.Chirp.LikeCount,
+			&i.Chirp.RechirpCount,
+			&i.Chirp.ReplyCount,
+			&i.Chirp.Status,
+			&i.Depth,
+			&i.Path,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getChirpsByIds = `-- name: GetChirpsByIds :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps WHERE id= ANY($1::uuid[])
+`
+
+func (q *Queries) GetChirpsByIds(ctx context.Context, ids []uuid.UUID) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getChirpsByIds, pq.Array(ids))
 	if err != nil {
 		return nil, err
 	}
@@ -64,6 +268,15 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -78,12 +291,28 @@ func (q *Queries) GetAllChirps(ctx context.Context) ([]Chirp, error) {
 	return items, nil
 }
 
-const getAllChirpsFromAuthor = `-- name: GetAllChirpsFromAuthor :many
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE user_id=$1 ORDER BY created_at ASC
+const getTimeline = `-- name: GetTimeline :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.in_reply_to, chirps.root_id, chirps.deleted_at, chirps.quote_of, chirps.like_count, chirps.rechirp_count, chirps.reply_count, chirps.status FROM chirps JOIN follows ON follows.followee_id= chirps.user_id
+WHERE follows.follower_id= $1 AND chirps.deleted_at IS NULL AND chirps.status= 'published'
+    AND ($2::timestamp IS NULL OR (chirps.created_at, chirps.id) < ($2::timestamp, $3::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT $4
 `
 
-func (q *Queries) GetAllChirpsFromAuthor(ctx context.Context, userID uuid.UUID) ([]Chirp, error) {
-	rows, err := q.db.QueryContext(ctx, getAllChirpsFromAuthor, userID)
+type GetTimelineParams struct {
+	UserID         uuid.UUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) GetTimeline(ctx context.Context, arg GetTimelineParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, getTimeline,
+		arg.UserID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
 	if err != nil {
 		return nil, err
 	}
@@ -97,6 +326,15 @@ func (q *Queries) GetAllChirpsFromAuthor(ctx context.Context, userID uuid.UUID)
 			&i.UpdatedAt,
 			&i.Body,
 			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
 		); err != nil {
 			return nil, err
 		}
@@ -111,12 +349,288 @@ func (q *Queries) GetAllChirpsFromAuthor(ctx context.Context, userID uuid.UUID)
 	return items, nil
 }
 
-const getChirpById = `-- name: GetChirpById :one
-SELECT id, created_at, updated_at, body, user_id FROM chirps WHERE id= $1 LIMIT 1
+const listChirpsAsc = `-- name: ListChirpsAsc :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps
+WHERE deleted_at IS NULL AND status= 'published'
+    AND ($1::uuid IS NULL OR user_id= $1::uuid)
+    AND ($2::timestamp IS NULL OR (created_at, id) > ($2::timestamp, $3::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $4
 `
 
-func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error) {
-	row := q.db.QueryRowContext(ctx, getChirpById, id)
+type ListChirpsAscParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) ListChirpsAsc(ctx context.Context, arg ListChirpsAscParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsAsc,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listChirpsDesc = `-- name: ListChirpsDesc :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps
+WHERE deleted_at IS NULL AND status= 'published'
+    AND ($1::uuid IS NULL OR user_id= $1::uuid)
+    AND ($2::timestamp IS NULL OR (created_at, id) < ($2::timestamp, $3::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT $4
+`
+
+type ListChirpsDescParams struct {
+	AuthorID       uuid.NullUUID
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) ListChirpsDesc(ctx context.Context, arg ListChirpsDescParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listChirpsDesc,
+		arg.AuthorID,
+		arg.AfterCreatedAt,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listHeldChirps = `-- name: ListHeldChirps :many
+SELECT id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status FROM chirps
+WHERE deleted_at IS NULL AND status= 'held'
+    AND ($1::timestamp IS NULL OR (created_at, id) > ($1::timestamp, $2::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT $3
+`
+
+type ListHeldChirpsParams struct {
+	AfterCreatedAt sql.NullTime
+	AfterID        uuid.NullUUID
+	RowLimit       int32
+}
+
+func (q *Queries) ListHeldChirps(ctx context.Context, arg ListHeldChirpsParams) ([]Chirp, error) {
+	rows, err := q.db.QueryContext(ctx, listHeldChirps, arg.AfterCreatedAt, arg.AfterID, arg.RowLimit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []Chirp
+	for rows.Next() {
+		var i Chirp
+		if err := rows.Scan(
+			&i.ID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Body,
+			&i.UserID,
+			&i.SearchVector,
+			&i.InReplyTo,
+			&i.RootID,
+			&i.DeletedAt,
+			&i.QuoteOf,
+			&i.LikeCount,
+			&i.RechirpCount,
+			&i.ReplyCount,
+			&i.Status,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const searchChirps = `-- name: SearchChirps :many
+SELECT chirps.id, chirps.created_at, chirps.updated_at, chirps.body, chirps.user_id, chirps.search_vector, chirps.in_reply_to, chirps.root_id, chirps.deleted_at, chirps.quote_of, chirps.like_count, chirps.rechirp_count, chirps.reply_count, ts_rank(chirps.search_vector, query)::real AS rank
+FROM chirps, to_tsquery('english', $1) AS query
+WHERE chirps.search_vector @@ query AND chirps.deleted_at IS NULL AND chirps.status= 'published'
+    AND ($2::uuid IS NULL OR chirps.user_id= $2::uuid)
+    AND ($3::timestamp IS NULL OR chirps.created_at >= $3::timestamp)
+    AND ($4::timestamp IS NULL OR chirps.created_at < $4::timestamp)
+    AND ($5::real IS NULL OR (ts_rank(chirps.search_vector, query)::real, chirps.id) < ($5::real, $6::uuid))
+ORDER BY rank DESC, chirps.id DESC
+LIMIT $7
+`
+
+type SearchChirpsParams struct {
+	Query     string
+	AuthorID  uuid.NullUUID
+	Since     sql.NullTime
+	Until     sql.NullTime
+	AfterRank sql.NullFloat64
+	AfterID   uuid.NullUUID
+	RowLimit  int32
+}
+
+type SearchChirpsRow struct {
+	Chirp Chirp
+	Rank  float32
+}
+
+func (q *Queries) SearchChirps(ctx context.Context, arg SearchChirpsParams) ([]SearchChirpsRow, error) {
+	rows, err := q.db.QueryContext(ctx, searchChirps,
+		arg.Query,
+		arg.AuthorID,
+		arg.Since,
+		arg.Until,
+		arg.AfterRank,
+		arg.AfterID,
+		arg.RowLimit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []SearchChirpsRow
+	for rows.Next() {
+		var i SearchChirpsRow
+		if err := rows.Scan(
+			&i.Chirp.ID,
+			&i.Chirp.CreatedAt,
+			&i.Chirp.UpdatedAt,
+			&i.Chirp.Body,
+			&i.Chirp.UserID,
+			&i.Chirp.SearchVector,
+			&i.Chirp.InReplyTo,
+			&i.Chirp.RootID,
+			&i.Chirp.DeletedAt,
+			&i.Chirp.QuoteOf,
+			&i.Chirp.LikeCount,
+			&i.Chirp.RechirpCount,
+			&i.Chirp.ReplyCount,
+			&i.Chirp.Status,
+			&i.Rank,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const setChirpStatus = `-- name: SetChirpStatus :execrows
+UPDATE chirps SET status= $2, updated_at= NOW() WHERE id= $1 AND deleted_at IS NULL
+`
+
+type SetChirpStatusParams struct {
+	ID     uuid.UUID
+	Status string
+}
+
+func (q *Queries) SetChirpStatus(ctx context.Context, arg SetChirpStatusParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, setChirpStatus, arg.ID, arg.Status)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const tombstoneChirp = `-- name: TombstoneChirp :exec
+UPDATE chirps SET body= '', deleted_at= NOW(), updated_at= NOW() WHERE id= $1
+`
+
+func (q *Queries) TombstoneChirp(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, tombstoneChirp, id)
+	return err
+}
+
+const updateChirpBody = `-- name: UpdateChirpBody :one
+UPDATE chirps SET body= $2, status= $3, updated_at= NOW() WHERE id= $1 RETURNING id, created_at, updated_at, body, user_id, search_vector, in_reply_to, root_id, deleted_at, quote_of, like_count, rechirp_count, reply_count, status
+`
+
+type UpdateChirpBodyParams struct {
+	ID     uuid.UUID
+	Body   string
+	Status string
+}
+
+func (q *Queries) UpdateChirpBody(ctx context.Context, arg UpdateChirpBodyParams) (Chirp, error) {
+	row := q.db.QueryRowContext(ctx, updateChirpBody, arg.ID, arg.Body, arg.Status)
 	var i Chirp
 	err := row.Scan(
 		&i.ID,
@@ -124,6 +638,15 @@ func (q *Queries) GetChirpById(ctx context.Context, id uuid.UUID) (Chirp, error)
 		&i.UpdatedAt,
 		&i.Body,
 		&i.UserID,
+		&i.SearchVector,
+		&i.InReplyTo,
+		&i.RootID,
+		&i.DeletedAt,
+		&i.QuoteOf,
+		&i.LikeCount,
+		&i.RechirpCount,
+		&i.ReplyCount,
+		&i.Status,
 	)
 	return i, err
 }
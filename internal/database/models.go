@@ -12,27 +12,256 @@ import (
 )
 
 type Chirp struct {
-	ID        uuid.UUID
+	ID           uuid.UUID
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Body         string
+	UserID       uuid.UUID
+	SearchVector interface{}
+	InReplyTo    uuid.NullUUID
+	RootID       uuid.NullUUID
+	DeletedAt    sql.NullTime
+	QuoteOf      uuid.NullUUID
+	LikeCount    int32
+	RechirpCount int32
+	ReplyCount   int32
+	Status       string
+}
+
+type ChirpRevision struct {
+	ID         uuid.UUID
+	ChirpID    uuid.UUID
+	Body       string
+	CreatedAt  time.Time
+	ReplacedAt time.Time
+}
+
+type ChirpLike struct {
+	UserID    uuid.UUID
+	ChirpID   uuid.UUID
 	CreatedAt time.Time
-	UpdatedAt time.Time
-	Body      string
+}
+
+type Rechirp struct {
 	UserID    uuid.UUID
+	ChirpID   uuid.UUID
+	CreatedAt time.Time
 }
 
-type RefreshToken struct {
-	Token     string
+type ModerationWord struct {
+	Word      string
+	Action    string
 	CreatedAt time.Time
 	UpdatedAt time.Time
+}
+
+type ModerationAction struct {
+	ID          uuid.UUID
+	CreatedAt   time.Time
+	ModeratorID uuid.NullUUID
+	Action      string
+	ChirpID     uuid.NullUUID
+	UserID      uuid.NullUUID
+	Notes       string
+}
+
+type Report struct {
+	ID             uuid.UUID
+	CreatedAt      time.Time
+	ReporterID     uuid.UUID
+	ChirpID        uuid.NullUUID
+	ReportedUserID uuid.UUID
+	Reason         string
+	Details        string
+	ResolvedAt     sql.NullTime
+	ActionID       uuid.NullUUID
+}
+
+type RefreshToken struct {
+	TokenHash             string
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+	UserID                uuid.UUID
+	ExpiresAt             time.Time
+	RevokedAt             sql.NullTime
+	FamilyID              uuid.UUID
+	RotatedAt             sql.NullTime
+	DeviceLabel           string
+	UserAgent             string
+	IpAddress             string
+	LastUsedAt            time.Time
+	Client                string
+	AccessTokenTtlSeconds int32
+	OauthClientID         uuid.NullUUID
+	Scopes                []string
+}
+
+type User struct {
+	ID              uuid.UUID
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	Email           string
+	HashedPassword  string
+	IsChirpyRed     sql.NullBool
+	SuspendedAt     sql.NullTime
+	Role            string
+	EmailVerifiedAt sql.NullTime
+}
+
+type UserTotp struct {
+	UserID       uuid.UUID
+	Secret       string
+	CreatedAt    time.Time
+	ConfirmedAt  sql.NullTime
+	LastUsedStep int64
+}
+
+type RecoveryCode struct {
+	ID        uuid.UUID
 	UserID    uuid.UUID
+	CodeHash  string
+	CreatedAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type SigningKey struct {
+	ID         string
+	Algorithm  string
+	PrivateKey string
+	CreatedAt  time.Time
+	SignsFrom  time.Time
+	SignsUntil time.Time
+	ValidUntil time.Time
+}
+
+type PasswordResetToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	CreatedAt time.Time
 	ExpiresAt time.Time
-	RevokedAt sql.NullTime
+	UsedAt    sql.NullTime
 }
 
-type User struct {
+type EmailVerificationToken struct {
+	TokenHash string
+	UserID    uuid.UUID
+	Email     string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	UsedAt    sql.NullTime
+}
+
+type LoginThrottle struct {
+	Kind          string
+	Subject       string
+	Failures      int32
+	LastFailureAt time.Time
+	BlockedUntil  time.Time
+}
+
+type ApiKey struct {
+	ID         uuid.UUID
+	UserID     uuid.UUID
+	Name       string
+	KeyHash    string
+	Prefix     string
+	Scopes     []string
+	CreatedAt  time.Time
+	ExpiresAt  time.Time
+	LastUsedAt sql.NullTime
+	RevokedAt  sql.NullTime
+}
+
+type OauthClient struct {
+	ID           uuid.UUID
+	OwnerID      uuid.UUID
+	Name         string
+	SecretHash   sql.NullString
+	RedirectUris []string
+	Scopes       []string
+	CreatedAt    time.Time
+}
+
+type OauthAuthorizationCode struct {
+	CodeHash      string
+	ClientID      uuid.UUID
+	UserID        uuid.UUID
+	RedirectUri   string
+	Scopes        []string
+	CodeChallenge string
+	CreatedAt     time.Time
+	ExpiresAt     time.Time
+	UsedAt        sql.NullTime
+	FamilyID      uuid.UUID
+}
+
+type Subscription struct {
+	UserID            uuid.UUID
+	Plan              string
+	Status            string
+	CurrentPeriodEnd  sql.NullTime
+	CancelAtPeriodEnd bool
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+}
+
+type WebhookEvent struct {
+	ID          string
+	EventType   string
+	Payload     []byte
+	ReceivedAt  time.Time
+	ProcessedAt sql.NullTime
+	Attempts    int32
+	LastError   sql.NullString
+}
+
+type WebhookSubscription struct {
+	ID         uuid.UUID
+	Url        string
+	Secret     string
+	EventTypes []string
+	CreatedBy  uuid.NullUUID
+	CreatedAt  time.Time
+}
+
+type WebhookDelivery struct {
 	ID             uuid.UUID
+	SubscriptionID uuid.UUID
+	EventID        uuid.UUID
+	EventType      string
+	Payload        []byte
+	Status         string
+	Attempts       int32
+	NextAttemptAt  time.Time
 	CreatedAt      time.Time
-	UpdatedAt      time.Time
-	Email          string
-	HashedPassword string
-	IsChirpyRed    sql.NullBool
+	DeliveredAt    sql.NullTime
+	LastError      sql.NullString
+}
+
+type WebhookDeliveryAttempt struct {
+	ID           uuid.UUID
+	DeliveryID   uuid.UUID
+	AttemptedAt  time.Time
+	StatusCode   sql.NullInt32
+	ResponseBody sql.NullString
+	Error        sql.NullString
+	DurationMs   int32
+}
+
+type OutboxEvent struct {
+	ID            uuid.UUID
+	EventType     string
+	Payload       []byte
+	CreatedAt     time.Time
+	DispatchedAt  sql.NullTime
+	Attempts      int32
+	NextAttemptAt time.Time
+	LastError     sql.NullString
+	Position      sql.NullInt64
+}
+
+type OutboxHandledEvent struct {
+	EventID    uuid.UUID
+	Subscriber string
+	HandledAt  time.Time
 }
@@ -10,56 +10,251 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/lib/pq"
 )
 
 const createRefreshToken = `-- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at )
-VALUES ($1, NOW(), NOW(), $2, $3) RETURNING token, created_at, updated_at, user_id, expires_at, revoked_at
+INSERT INTO refresh_tokens(token_hash, created_at, updated_at, user_id, expires_at, family_id, device_label, user_agent, ip_address, last_used_at,
+    client, access_token_ttl_seconds, oauth_client_id, scopes)
+VALUES ($1, NOW(), NOW(), $2, $3, $4, $5, $6, $7, NOW(), $8, $9, $10, $11) RETURNING token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, device_label, user_agent, ip_address, last_used_at, client, access_token_ttl_seconds, oauth_client_id, scopes
 `
 
 type CreateRefreshTokenParams struct {
-	Token     string
-	UserID    uuid.UUID
-	ExpiresAt time.Time
+	TokenHash             string
+	UserID                uuid.UUID
+	ExpiresAt             time.Time
+	FamilyID              uuid.UUID
+	DeviceLabel           string
+	UserAgent             string
+	IpAddress             string
+	Client                string
+	AccessTokenTtlSeconds int32
+	OauthClientID         uuid.NullUUID
+	Scopes                []string
 }
 
 func (q *Queries) CreateRefreshToken(ctx context.Context, arg CreateRefreshTokenParams) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, createRefreshToken, arg.Token, arg.UserID, arg.ExpiresAt)
+	row := q.db.QueryRowContext(ctx, createRefreshToken,
+		arg.TokenHash,
+		arg.UserID,
+		arg.ExpiresAt,
+		arg.FamilyID,
+		arg.DeviceLabel,
+		arg.UserAgent,
+		arg.IpAddress,
+		arg.Client,
+		arg.AccessTokenTtlSeconds,
+		arg.OauthClientID,
+		pq.Array(arg.Scopes),
+	)
+	var i RefreshToken
+	err := row.Scan(
+		&i.TokenHash,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.UserID,
+		&i.ExpiresAt,
+		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.DeviceLabel,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Client,
+		&i.AccessTokenTtlSeconds,
+		&i.OauthClientID,
+		pq.Array(&i.Scopes),
+	)
+	return i, err
+}
+
+const getRefreshTokenByHash = `-- name: GetRefreshTokenByHash :one
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, device_label, user_agent, ip_address, last_used_at, client, access_token_ttl_seconds, oauth_client_id, scopes FROM refresh_tokens WHERE token_hash= $1 LIMIT 1
+`
+
+func (q *Queries) GetRefreshTokenByHash(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenByHash, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.DeviceLabel,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Client,
+		&i.AccessTokenTtlSeconds,
+		&i.OauthClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
-const getRefreshTokenById = `-- name: GetRefreshTokenById :one
-SELECT token, created_at, updated_at, user_id, expires_at, revoked_at FROM refresh_tokens WHERE token= $1 LIMIT 1
+const getRefreshTokenByHashForUpdate = `-- name: GetRefreshTokenByHashForUpdate :one
+SELECT token_hash, created_at, updated_at, user_id, expires_at, revoked_at, family_id, rotated_at, device_label, user_agent, ip_address, last_used_at, client, access_token_ttl_seconds, oauth_client_id, scopes FROM refresh_tokens WHERE token_hash= $1 LIMIT 1 FOR UPDATE
 `
 
-func (q *Queries) GetRefreshTokenById(ctx context.Context, token string) (RefreshToken, error) {
-	row := q.db.QueryRowContext(ctx, getRefreshTokenById, token)
+func (q *Queries) GetRefreshTokenByHashForUpdate(ctx context.Context, tokenHash string) (RefreshToken, error) {
+	row := q.db.QueryRowContext(ctx, getRefreshTokenByHashForUpdate, tokenHash)
 	var i RefreshToken
 	err := row.Scan(
-		&i.Token,
+		&i.TokenHash,
 		&i.CreatedAt,
 		&i.UpdatedAt,
 		&i.UserID,
 		&i.ExpiresAt,
 		&i.RevokedAt,
+		&i.FamilyID,
+		&i.RotatedAt,
+		&i.DeviceLabel,
+		&i.UserAgent,
+		&i.IpAddress,
+		&i.LastUsedAt,
+		&i.Client,
+		&i.AccessTokenTtlSeconds,
+		&i.OauthClientID,
+		pq.Array(&i.Scopes),
 	)
 	return i, err
 }
 
+const isSessionActive = `-- name: IsSessionActive :one
+SELECT EXISTS(SELECT 1 FROM refresh_tokens WHERE family_id= $1 AND revoked_at IS NULL AND expires_at > NOW())
+`
+
+func (q *Queries) IsSessionActive(ctx context.Context, familyID uuid.UUID) (bool, error) {
+	row := q.db.QueryRowContext(ctx, isSessionActive, familyID)
+	var exists bool
+	err := row.Scan(&exists)
+	return exists, err
+}
+
+const listUserSessions = `-- name: ListUserSessions :many
+SELECT refresh_tokens.family_id, refresh_tokens.device_label, refresh_tokens.user_agent, refresh_tokens.ip_address,
+    refresh_tokens.last_used_at, refresh_tokens.expires_at,
+    (SELECT MIN(family.created_at) FROM refresh_tokens AS family WHERE family.family_id= refresh_tokens.family_id)::timestamp AS started_at
+FROM refresh_tokens
+WHERE refresh_tokens.user_id= $1 AND refresh_tokens.rotated_at IS NULL AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC
+`
+
+type ListUserSessionsRow struct {
+	FamilyID    uuid.UUID
+	DeviceLabel string
+	UserAgent   string
+	IpAddress   string
+	LastUsedAt  time.Time
+	ExpiresAt   time.Time
+	StartedAt   time.Time
+}
+
+// the active token of each family stands for the session, the first token of the family tells when it started
+func (q *Queries) ListUserSessions(ctx context.Context, userID uuid.UUID) ([]ListUserSessionsRow, error) {
+	rows, err := q.db.QueryContext(ctx, listUserSessions, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []ListUserSessionsRow
+	for rows.Next() {
+		var i ListUserSessionsRow
+		if err := rows.Scan(
+			&i.FamilyID,
+			&i.DeviceLabel,
+			&i.UserAgent,
+			&i.IpAddress,
+			&i.LastUsedAt,
+			&i.ExpiresAt,
+			&i.StartedAt,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const revokeOtherUserSessions = `-- name: RevokeOtherUserSessions :execrows
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND family_id <> $2 AND revoked_at IS NULL
+`
+
+type RevokeOtherUserSessionsParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeOtherUserSessions(ctx context.Context, arg RevokeOtherUserSessionsParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeOtherUserSessions, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const revokeRefreshTokenFamily = `-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE family_id= $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeRefreshTokenFamily(ctx context.Context, familyID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeRefreshTokenFamily, familyID)
+	return err
+}
+
 const revokeToken = `-- name: RevokeToken :exec
-UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE token= $1
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW()
+WHERE family_id= (SELECT family_id FROM refresh_tokens WHERE token_hash= $1) AND revoked_at IS NULL
+`
+
+// revoking a token ends its whole family, the tokens it was rotated from included
+func (q *Queries) RevokeToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, revokeToken, tokenHash)
+	return err
+}
+
+const revokeUserRefreshTokens = `-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND revoked_at IS NULL
+`
+
+func (q *Queries) RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, revokeUserRefreshTokens, userID)
+	return err
+}
+
+const revokeUserSession = `-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND family_id= $2 AND revoked_at IS NULL
+`
+
+type RevokeUserSessionParams struct {
+	UserID   uuid.UUID
+	FamilyID uuid.UUID
+}
+
+func (q *Queries) RevokeUserSession(ctx context.Context, arg RevokeUserSessionParams) (int64, error) {
+	result, err := q.db.ExecContext(ctx, revokeUserSession, arg.UserID, arg.FamilyID)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const rotateRefreshToken = `-- name: RotateRefreshToken :exec
+UPDATE refresh_tokens SET rotated_at= NOW(), updated_at= NOW() WHERE token_hash= $1
 `
 
-func (q *Queries) RevokeToken(ctx context.Context, token string) error {
-	_, err := q.db.ExecContext(ctx, revokeToken, token)
+func (q *Queries) RotateRefreshToken(ctx context.Context, tokenHash string) error {
+	_, err := q.db.ExecContext(ctx, rotateRefreshToken, tokenHash)
 	return err
 }
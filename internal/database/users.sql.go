@@ -11,9 +11,20 @@ import (
 	"github.com/google/uuid"
 )
 
+const countUsersWithRole = `-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users WHERE role= $1
+`
+
+func (q *Queries) CountUsersWithRole(ctx context.Context, role string) (int64, error) {
+	row := q.db.QueryRowContext(ctx, countUsersWithRole, role)
+	var count int64
+	err := row.Scan(&count)
+	return count, err
+}
+
 const createUser = `-- name: CreateUser :one
 INSERT INTO users(id, created_at, updated_at, email, hashed_password)
-VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+VALUES (gen_random_uuid(), NOW(), NOW(), $1, $2) RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at
 `
 
 type CreateUserParams struct {
@@ -31,6 +42,9 @@ func (q *Queries) CreateUser(ctx context.Context, arg CreateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
@@ -44,8 +58,17 @@ func (q *Queries) DeleteAllUsers(ctx context.Context) error {
 	return err
 }
 
+const downgradeFromChirpyRed = `-- name: DowngradeFromChirpyRed :exec
+UPDATE users SET is_chirpy_red= FALSE WHERE id= $1
+`
+
+func (q *Queries) DowngradeFromChirpyRed(ctx context.Context, id uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, downgradeFromChirpyRed, id)
+	return err
+}
+
 const getUserByEmail = `-- name: GetUserByEmail :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE email= $1 LIMIT 1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at FROM users WHERE email= $1 LIMIT 1
 `
 
 func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error) {
@@ -58,12 +81,15 @@ func (q *Queries) GetUserByEmail(ctx context.Context, email string) (User, error
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
 const getUserById = `-- name: GetUserById :one
-SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red FROM users WHERE id= $1 LIMIT 1
+SELECT id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at FROM users WHERE id= $1 LIMIT 1
 `
 
 func (q *Queries) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
@@ -76,22 +102,24 @@ func (q *Queries) GetUserById(ctx context.Context, id uuid.UUID) (User, error) {
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
-const updateUser = `-- name: UpdateUser :one
-UPDATE users SET updated_at=NOW(), email=$2, hashed_password=$3 WHERE id=$1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red
+const setUserRole = `-- name: SetUserRole :one
+UPDATE users SET role= $2, updated_at= NOW() WHERE id= $1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at
 `
 
-type UpdateUserParams struct {
-	ID             uuid.UUID
-	Email          string
-	HashedPassword string
+type SetUserRoleParams struct {
+	ID   uuid.UUID
+	Role string
 }
 
-func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, error) {
-	row := q.db.QueryRowContext(ctx, updateUser, arg.ID, arg.Email, arg.HashedPassword)
+func (q *Queries) SetUserRole(ctx context.Context, arg SetUserRoleParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, setUserRole, arg.ID, arg.Role)
 	var i User
 	err := row.Scan(
 		&i.ID,
@@ -100,10 +128,73 @@ func (q *Queries) UpdateUser(ctx context.Context, arg UpdateUserParams) (User, e
 		&i.Email,
 		&i.HashedPassword,
 		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
 	)
 	return i, err
 }
 
+const suspendUser = `-- name: SuspendUser :execrows
+UPDATE users SET suspended_at= NOW(), updated_at= NOW() WHERE id= $1 AND suspended_at IS NULL
+`
+
+func (q *Queries) SuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, suspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const unsuspendUser = `-- name: UnsuspendUser :execrows
+UPDATE users SET suspended_at= NULL, updated_at= NOW() WHERE id= $1 AND suspended_at IS NOT NULL
+`
+
+func (q *Queries) UnsuspendUser(ctx context.Context, id uuid.UUID) (int64, error) {
+	result, err := q.db.ExecContext(ctx, unsuspendUser, id)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
+const updateUser = `-- name: UpdateUser :one
+UPDATE users SET updated_at=NOW() WHERE id=$1 RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at
+`
+
+// the email only changes once the new address is verified, see VerifyUserEmail, and the password with UpdateUserPassword
+func (q *Queries) UpdateUser(ctx context.Context, id uuid.UUID) (User, error) {
+	row := q.db.QueryRowContext(ctx, updateUser, id)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
+
+const updateUserPassword = `-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password= $2, updated_at= NOW() WHERE id= $1
+`
+
+type UpdateUserPasswordParams struct {
+	ID             uuid.UUID
+	HashedPassword string
+}
+
+func (q *Queries) UpdateUserPassword(ctx context.Context, arg UpdateUserPasswordParams) error {
+	_, err := q.db.ExecContext(ctx, updateUserPassword, arg.ID, arg.HashedPassword)
+	return err
+}
+
 const upgradeToChirpyRed = `-- name: UpgradeToChirpyRed :exec
 UPDATE users SET is_chirpy_red= TRUE WHERE id=$1
 `
@@ -112,3 +203,32 @@ func (q *Queries) UpgradeToChirpyRed(ctx context.Context, id uuid.UUID) error {
 	_, err := q.db.ExecContext(ctx, upgradeToChirpyRed, id)
 	return err
 }
+
+const verifyUserEmail = `-- name: VerifyUserEmail :one
+UPDATE users SET email= $2, email_verified_at= NOW(), updated_at= NOW()
+WHERE id= $1 AND NOT EXISTS (SELECT 1 FROM users AS other WHERE other.email= $2 AND other.id <> $1)
+RETURNING id, created_at, updated_at, email, hashed_password, is_chirpy_red, suspended_at, role, email_verified_at
+`
+
+type VerifyUserEmailParams struct {
+	ID    uuid.UUID
+	Email string
+}
+
+// nothing is returned when another account took the address since it was requested
+func (q *Queries) VerifyUserEmail(ctx context.Context, arg VerifyUserEmailParams) (User, error) {
+	row := q.db.QueryRowContext(ctx, verifyUserEmail, arg.ID, arg.Email)
+	var i User
+	err := row.Scan(
+		&i.ID,
+		&i.CreatedAt,
+		&i.UpdatedAt,
+		&i.Email,
+		&i.HashedPassword,
+		&i.IsChirpyRed,
+		&i.SuspendedAt,
+		&i.Role,
+		&i.EmailVerifiedAt,
+	)
+	return i, err
+}
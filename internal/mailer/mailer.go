@@ -0,0 +1,44 @@
+package mailer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends the emails of chirpy, SMTPMailer in production and WriterMailer in dev and tests
+type Mailer interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var ErrInvalidHeader = errors.New("the address or the subject contains a line break")
+
+// format builds the RFC 5322 message. The recipient and the subject come from the users, a line break in them
+// would let them add their own headers
+func format(from string, message Message, date time.Time) ([]byte, error) {
+	for _, header := range []string{from, message.To, message.Subject} {
+		if strings.ContainsAny(header, "\r\n") {
+			return nil, ErrInvalidHeader
+		}
+	}
+	body := strings.ReplaceAll(strings.ReplaceAll(message.Body, "\r\n", "\n"), "\n", "\r\n")
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "From: %v\r\n", from)
+	fmt.Fprintf(&builder, "To: %v\r\n", message.To)
+	fmt.Fprintf(&builder, "Subject: %v\r\n", message.Subject)
+	fmt.Fprintf(&builder, "Date: %v\r\n", date.Format(time.RFC1123Z))
+	builder.WriteString("MIME-Version: 1.0\r\n")
+	builder.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(body)
+	return []byte(builder.String()), nil
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var buffer bytes.Buffer
+	mailer := NewWriterMailer(&buffer, "Chirpy <no-reply@chirpy.local>")
+	err := mailer.Send(context.Background(), Message{
+		To:      "walt@breakingbad.com",
+		Subject: "Reset your password",
+		Body:    "first line\nsecond line",
+	})
+	if err != nil {
+		t.Fatalf("error when sending the email: %v", err)
+	}
+	written := buffer.String()
+	for _, expected := range []string{
+		"From: Chirpy <no-reply@chirpy.local>\r\n",
+		"To: walt@breakingbad.com\r\n",
+		"Subject: Reset your password\r\n",
+		"\r\n\r\nfirst line\r\nsecond line\r\n.\r\n",
+	} {
+		if !strings.Contains(written, expected) {
+			t.Errorf("the email should contain %q:\n%v", expected, written)
+		}
+	}
+}
+
+func TestHeaderInjection(t *testing.T) {
+	var buffer bytes.Buffer
+	mailer := NewWriterMailer(&buffer, "no-reply@chirpy.local")
+	cases := []Message{
+		{To: "walt@breakingbad.com\r\nBcc: everyone@example.com", Subject: "hello"},
+		{To: "walt@breakingbad.com", Subject: "hello\nBcc: everyone@example.com"},
+	}
+	for _, c := range cases {
+		if err := mailer.Send(context.Background(), c); err != ErrInvalidHeader {
+			t.Errorf("a line break in the headers should be refused, got %v", err)
+		}
+	}
+	if buffer.Len() != 0 {
+		t.Errorf("nothing should be written when the headers are invalid:\n%v", buffer.String())
+	}
+}
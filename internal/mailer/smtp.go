@@ -0,0 +1,42 @@
+package mailer
+
+import (
+	"context"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// SMTPMailer sends the emails through an SMTP server, with STARTTLS when the server offers it
+type SMTPMailer struct {
+	addr string
+	from string
+	auth smtp.Auth
+}
+
+// NewSMTPMailer only authenticates when a username is given, net/smtp refuses to send the password without TLS
+// unless the server is on localhost
+func NewSMTPMailer(host, port, username, password, from string) *SMTPMailer {
+	mailer := &SMTPMailer{addr: net.JoinHostPort(host, port), from: from}
+	if username != "" {
+		mailer.auth = smtp.PlainAuth("", username, password, host)
+	}
+	return mailer
+}
+
+func (m *SMTPMailer) Send(ctx context.Context, message Message) error {
+	formattedMessage, err := format(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+	sent := make(chan error, 1)
+	go func() {
+		sent <- smtp.SendMail(m.addr, m.auth, m.from, []string{message.To}, formattedMessage)
+	}()
+	select {
+	case err := <-sent:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
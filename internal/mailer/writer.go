@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// WriterMailer writes the emails instead of sending them, to stdout or to a file in dev and to a buffer in tests
+type WriterMailer struct {
+	from string
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+func NewWriterMailer(w io.Writer, from string) *WriterMailer {
+	return &WriterMailer{w: w, from: from}
+}
+
+// NewFileMailer appends the emails to the file, the file is kept open for the life of the server
+func NewFileMailer(path, from string) (*WriterMailer, error) {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return NewWriterMailer(file, from), nil
+}
+
+func (m *WriterMailer) Send(ctx context.Context, message Message) error {
+	formattedMessage, err := format(m.from, message, time.Now())
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err = fmt.Fprintf(m.w, "%s\r\n.\r\n", formattedMessage)
+	return err
+}
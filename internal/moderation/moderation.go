@@ -0,0 +1,89 @@
+package moderation
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Action is what moderation decides to do with a text, ordered from the most lenient to the strictest
+type Action int
+
+const (
+	Allow Action = iota
+	Mask
+	Hold // the text is kept but stays hidden until a moderator reviews it
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Allow:
+		return "allow"
+	case Mask:
+		return "mask"
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	}
+	return fmt.Sprintf("action(%d)", int(a))
+}
+
+func ParseAction(action string) (Action, error) {
+	switch strings.ToLower(strings.TrimSpace(action)) {
+	case "allow":
+		return Allow, nil
+	case "mask":
+		return Mask, nil
+	case "hold":
+		return Hold, nil
+	case "reject":
+		return Reject, nil
+	}
+	return Allow, fmt.Errorf("unknown moderation action '%v', expected allow, mask, hold or reject", action)
+}
+
+type Result struct {
+	Action Action
+	Text   string // the text once the filter applied its masks
+}
+
+type Filter interface {
+	Name() string
+	Apply(text string) Result
+}
+
+type Verdict struct {
+	Action  Action
+	Text    string
+	ActedBy []string // names of the filters that didn't just allow the text
+}
+
+type Chain struct {
+	filters []Filter
+}
+
+func NewChain(filters ...Filter) *Chain {
+	return &Chain{filters: filters}
+}
+
+// Moderate runs the filters in order, each one receives the text masked by the previous ones.
+// The verdict is the strictest action of the chain, we stop as soon as a filter rejects the text
+func (c *Chain) Moderate(text string) Verdict {
+	verdict := Verdict{Action: Allow, Text: text, ActedBy: []string{}}
+	for _, filter := range c.filters {
+		result := filter.Apply(verdict.Text)
+		if result.Action == Allow {
+			continue
+		}
+		verdict.ActedBy = append(verdict.ActedBy, filter.Name())
+		verdict.Text = result.Text
+		if result.Action > verdict.Action {
+			verdict.Action = result.Action
+		}
+		if verdict.Action == Reject {
+			break
+		}
+	}
+	return verdict
+}
@@ -0,0 +1,73 @@
+package moderation
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestWordListMasking(t *testing.T) {
+	list := NewWordList("wordlist", map[string]Action{
+		"kerfuffle": Mask,
+		"sharbert":  Mask,
+		"fornax":    Mask,
+	})
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "I had something interesting for breakfast", expected: "I had something interesting for breakfast"},
+		{input: "I hear Mastodon is better than Chirpy. sharbert I need to migrate", expected: "I hear Mastodon is better than Chirpy. **** I need to migrate"},
+		{input: "I really need a kerfuffle to go to bed sooner, Fornax !", expected: "I really need a **** to go to bed sooner, **** !"},
+		{input: "what a Kerfuffle!", expected: "what a ****!"},
+		{input: "k3rfuffl3 and $h@rbert", expected: "**** and ****"},
+		{input: "ＦＯＲＮＡＸ or fórnax", expected: "**** or ****"},
+		{input: "fornaxes are fine", expected: "fornaxes are fine"},
+	}
+	for _, c := range cases {
+		result := list.Apply(c.input)
+		if result.Text != c.expected {
+			t.Errorf("'%v' was masked as '%v', expected '%v'", c.input, result.Text, c.expected)
+		}
+	}
+}
+
+func TestChainVerdict(t *testing.T) {
+	list := NewWordList("wordlist", map[string]Action{"kerfuffle": Mask})
+	rules := NewRegexFilter("regex", []RegexRule{
+		{Pattern: regexp.MustCompile(`(?i)buy now`), Action: Hold},
+		{Pattern: regexp.MustCompile(`spam`), Action: Reject},
+	})
+	chain := NewChain(list, rules)
+
+	verdict := chain.Moderate("a kerfuffle, buy now")
+	if verdict.Action != Hold {
+		t.Errorf("expected the chirp to be held, got %v", verdict.Action)
+	}
+	if verdict.Text != "a ****, buy now" {
+		t.Errorf("unexpected moderated text '%v'", verdict.Text)
+	}
+	if len(verdict.ActedBy) != 2 {
+		t.Errorf("both filters should have acted, got %v", verdict.ActedBy)
+	}
+
+	verdict = chain.Moderate("$p@m")
+	if verdict.Action != Reject {
+		t.Errorf("the leetspeak spam should be rejected, got %v", verdict.Action)
+	}
+
+	verdict = chain.Moderate("nothing to see")
+	if verdict.Action != Allow || len(verdict.ActedBy) != 0 {
+		t.Errorf("the text should be allowed without any filter acting, got %v %v", verdict.Action, verdict.ActedBy)
+	}
+}
+
+func TestWordListReplace(t *testing.T) {
+	list := NewWordList("wordlist", map[string]Action{"fornax": Mask})
+	list.Replace(map[string]Action{"chirp": Reject})
+	if result := list.Apply("fornax"); result.Action != Allow {
+		t.Errorf("replaced words should not be flagged anymore")
+	}
+	if result := list.Apply("Chirp"); result.Action != Reject {
+		t.Errorf("new words should be flagged, got %v", result.Action)
+	}
+}
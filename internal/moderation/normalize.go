@@ -0,0 +1,41 @@
+package moderation
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+var leetspeak = map[rune]rune{
+	'0': 'o',
+	'1': 'i',
+	'3': 'e',
+	'4': 'a',
+	'5': 's',
+	'7': 't',
+	'@': 'a',
+	'$': 's',
+}
+
+// Normalize brings a word to the form used to compare it with the word lists: compatibility characters are
+// decomposed (fullwidth letters, ligatures...), accents are dropped, leetspeak is translated and everything is lower case
+func Normalize(word string) string {
+	var normalized strings.Builder
+	for _, r := range norm.NFKD.String(word) {
+		if unicode.Is(unicode.Mn, r) {
+			continue
+		}
+		if replacement, ok := leetspeak[r]; ok {
+			r = replacement
+		}
+		normalized.WriteRune(unicode.ToLower(r))
+	}
+	return normalized.String()
+}
+
+// isWordRune tells if the rune can be part of a word, leetspeak symbols included so "$h@rbert" stays one word
+func isWordRune(r rune) bool {
+	_, leet := leetspeak[r]
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.Is(unicode.Mn, r) || leet
+}
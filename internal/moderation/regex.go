@@ -0,0 +1,86 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+type RegexRule struct {
+	Pattern *regexp.Regexp
+	Action  Action
+}
+
+// RegexFilter applies rules written as regular expressions, they are matched on the text as written and on its
+// normalized form. Masks can only be applied on the text as written, a mask rule that only matches the normalized
+// form holds the text for review instead
+type RegexFilter struct {
+	name  string
+	rules []RegexRule
+}
+
+func NewRegexFilter(name string, rules []RegexRule) *RegexFilter {
+	return &RegexFilter{name: name, rules: rules}
+}
+
+func (f *RegexFilter) Name() string {
+	return f.name
+}
+
+func (f *RegexFilter) Apply(text string) Result {
+	result := Result{Action: Allow, Text: text}
+	normalizedText := Normalize(text)
+	for _, rule := range f.rules {
+		action := Allow
+		if rule.Pattern.MatchString(result.Text) {
+			action = rule.Action
+			if action == Mask {
+				result.Text = rule.Pattern.ReplaceAllString(result.Text, maskReplacement)
+			}
+		} else if rule.Pattern.MatchString(normalizedText) {
+			action = rule.Action
+			if action == Mask {
+				action = Hold
+			}
+		}
+		if action > result.Action {
+			result.Action = action
+		}
+	}
+	return result
+}
+
+// LoadRegexRulesFile reads one rule per line: the action, then the regular expression.
+// Empty lines and lines starting with '#' are ignored
+func LoadRegexRulesFile(path string) ([]RegexRule, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	rules := []RegexRule{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rawAction, rawPattern, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("%v:%d: a rule needs an action and a pattern", path, lineNumber)
+		}
+		action, err := ParseAction(rawAction)
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %w", path, lineNumber, err)
+		}
+		pattern, err := regexp.Compile(strings.TrimSpace(rawPattern))
+		if err != nil {
+			return nil, fmt.Errorf("%v:%d: %w", path, lineNumber, err)
+		}
+		rules = append(rules, RegexRule{Pattern: pattern, Action: action})
+	}
+	return rules, scanner.Err()
+}
@@ -0,0 +1,100 @@
+package moderation
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+const maskReplacement = "****"
+
+// WordList flags the words of a list, whatever their case, accents, leetspeak or the punctuation around them.
+// The list can be replaced at runtime while chirps are being moderated
+type WordList struct {
+	name  string
+	mu    sync.RWMutex
+	words map[string]Action // keys are normalized words
+}
+
+func NewWordList(name string, words map[string]Action) *WordList {
+	list := &WordList{name: name}
+	list.Replace(words)
+	return list
+}
+
+func (l *WordList) Name() string {
+	return l.name
+}
+
+func (l *WordList) Replace(words map[string]Action) {
+	normalizedWords := make(map[string]Action, len(words))
+	for word, action := range words {
+		normalizedWords[Normalize(word)] = action
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.words = normalizedWords
+}
+
+func (l *WordList) Apply(text string) Result {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	result := Result{Action: Allow}
+	var masked strings.Builder
+	runes := []rune(text)
+	for start := 0; start < len(runes); {
+		if !isWordRune(runes[start]) {
+			masked.WriteRune(runes[start])
+			start++
+			continue
+		}
+		end := start
+		for end < len(runes) && isWordRune(runes[end]) {
+			end++
+		}
+		word := string(runes[start:end])
+		action, listed := l.words[Normalize(word)]
+		if listed && action > result.Action {
+			result.Action = action
+		}
+		if listed && action != Allow {
+			masked.WriteString(maskReplacement)
+		} else {
+			masked.WriteString(word)
+		}
+		start = end
+	}
+	result.Text = masked.String()
+	return result
+}
+
+// LoadWordListFile reads a word list with one word per line, optionally followed by its action (mask by default).
+// Empty lines and lines starting with '#' are ignored
+func LoadWordListFile(path string) (map[string]Action, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	words := map[string]Action{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		action := Mask
+		if len(fields) > 1 {
+			action, err = ParseAction(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("%v:%d: %w", path, lineNumber, err)
+			}
+		}
+		words[fields[0]] = action
+	}
+	return words, scanner.Err()
+}
@@ -0,0 +1,80 @@
+package search
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseQuery turns what a user types in the search box into a Postgres tsquery (to use with to_tsquery):
+//   - words are all required: `go chirp` -> `go & chirp`
+//   - "quoted words" must follow each other: `"hello world"` -> `(hello <-> world)`
+//   - a trailing star matches prefixes: `chir*` -> `chir:*`
+//   - a leading dash excludes a word: `-spam` -> `!spam`
+//
+// Only letters and digits reach the tsquery, so the user can't inject tsquery operators
+func ParseQuery(rawQuery string) (string, error) {
+	terms := []string{}
+	remaining := strings.TrimSpace(rawQuery)
+	for remaining != "" {
+		var term string
+		if remaining[0] == '"' {
+			closingQuote := strings.IndexByte(remaining[1:], '"')
+			phrase := remaining[1:]
+			remaining = ""
+			if closingQuote >= 0 {
+				phrase, remaining = phrase[:closingQuote], phrase[closingQuote+1:]
+			}
+			lexemes := splitLexemes(phrase)
+			if len(lexemes) > 1 {
+				term = "(" + strings.Join(lexemes, " <-> ") + ")"
+			} else if len(lexemes) == 1 {
+				term = lexemes[0]
+			}
+		} else {
+			word := remaining
+			remaining = ""
+			if end := strings.IndexFunc(word, unicode.IsSpace); end >= 0 {
+				word, remaining = word[:end], word[end:]
+			}
+			term = parseWord(word)
+		}
+		if term != "" {
+			terms = append(terms, term)
+		}
+		remaining = strings.TrimSpace(remaining)
+	}
+	if len(terms) == 0 {
+		return "", fmt.Errorf("the search query has no searchable word")
+	}
+	return strings.Join(terms, " & "), nil
+}
+
+func parseWord(word string) string {
+	negated := strings.HasPrefix(word, "-")
+	word = strings.TrimPrefix(word, "-")
+	prefix := strings.HasSuffix(word, "*")
+	word = strings.TrimRight(word, "*")
+
+	lexemes := splitLexemes(word)
+	if len(lexemes) == 0 {
+		return ""
+	}
+	if prefix {
+		lexemes[len(lexemes)-1] += ":*"
+	}
+	term := lexemes[0]
+	if len(lexemes) > 1 { // words like "e-mail" or "don't" are searched as a phrase
+		term = "(" + strings.Join(lexemes, " <-> ") + ")"
+	}
+	if negated {
+		term = "!" + term
+	}
+	return term
+}
+
+func splitLexemes(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
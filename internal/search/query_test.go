@@ -0,0 +1,38 @@
+package search
+
+import "testing"
+
+func TestParseQuery(t *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{input: "go chirp", expected: "go & chirp"},
+		{input: `"hello world" again`, expected: "(hello <-> world) & again"},
+		{input: "chir*", expected: "chir:*"},
+		{input: "-spam eggs", expected: "!spam & eggs"},
+		{input: "e-mail", expected: "(e <-> mail)"},
+		{input: `"unfinished phrase`, expected: "(unfinished <-> phrase)"},
+		{input: "a:* | b & !c", expected: "a:* & b & c"},
+		{input: "  Kerfuffle  ", expected: "kerfuffle"},
+	}
+	for _, c := range cases {
+		result, err := ParseQuery(c.input)
+		if err != nil {
+			t.Errorf("unexpected error for '%v': %v", c.input, err)
+			continue
+		}
+		if result != c.expected {
+			t.Errorf("query '%v' gave '%v', expected '%v'", c.input, result, c.expected)
+		}
+	}
+}
+
+func TestParseQueryWithoutWords(t *testing.T) {
+	for _, input := range []string{"", "   ", `""`, "*** !!"} {
+		_, err := ParseQuery(input)
+		if err == nil {
+			t.Errorf("query '%v' should have been rejected", input)
+		}
+	}
+}
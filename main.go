@@ -1,95 +1,481 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"maps"
 	"net/http"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"sync/atomic"
+	"time"
 
 	"github.com/RazafimanantsoaJohnson/chirpy/internal/auth"
 	"github.com/RazafimanantsoaJohnson/chirpy/internal/database"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/mailer"
+	"github.com/RazafimanantsoaJohnson/chirpy/internal/moderation"
 	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/lib/pq"
 )
 
 type ApiConfig struct {
-	fileserverHits atomic.Int32
-	dbQueries      *database.Queries
-	secretKey      string
-	polkaKey       string
+	fileserverHits  atomic.Int32
+	db              *sql.DB // only needed to open transactions, everything else goes through dbQueries
+	dbQueries       *database.Queries
+	polkaKey        string
+	chirpEditWindow time.Duration
+	moderation      *moderation.Chain
+	// the keys signing the access tokens, reloaded from the database every signingKeyCheckPeriod
+	keyring           *auth.Keyring
+	signingAlgorithm  string
+	keyRotationPeriod time.Duration
+	tokenPolicy       auth.TokenPolicy
+	mailer            mailer.Mailer
+	publicUrl         string // where the links sent by email point to
+	// REQUIRE_VERIFIED_EMAIL=true keeps the users who didn't verify their email from posting chirps
+	requireVerifiedEmail bool
+	onLockout            lockoutHook // emails the owner of the account by default
+	// POLKA_WEBHOOK_SECRET signs the Polka deliveries, they are refused once they are older than the tolerance
+	polkaWebhookSecret    string
+	polkaWebhookTolerance time.Duration
+	// the word list of the moderation chain, kept aside so it can be reloaded when the admins edit it
+	moderationWords     *moderation.WordList
+	moderationWordsFile string
+	// the outbox dispatcher, the handlers publish their events with publishEvent in the transaction of the change
+	events      *eventBus
+	chirpStream *chirpStream // the open streams of chirps of this instance
 }
 
 func (cfg *ApiConfig) middlewareMetricsInc(next http.Handler) http.Handler {
 	result := func(w http.ResponseWriter, r *http.Request) {
-		fmt.Println("we add a value")
 		cfg.fileserverHits.Add(1)
 		next.ServeHTTP(w, r)
 	}
 	return http.HandlerFunc(result)
 }
 
-func (cfg *ApiConfig) middlewareCheckAuth(next func(http.ResponseWriter, *http.Request, *ApiConfig, uuid.UUID)) func(http.ResponseWriter, *http.Request) {
+// middlewareCheckAuth lets through the logged in users, and the API keys granted the scope of the route.
+// auth.SessionOnly routes can't be called with an API key
+func (cfg *ApiConfig) middlewareCheckAuth(scope auth.Scope, next func(http.ResponseWriter, *http.Request, *ApiConfig, uuid.UUID)) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		receivedToken, err := auth.GetBearerToken(r.Header)
-		if err != nil {
-			log.Printf("%v", err)
-			w.WriteHeader(401)
-			w.Write([]byte("This user is not authorized to make this request"))
+		r, currentUser, ok := cfg.authenticate(w, r, scope)
+		if !ok {
 			return
 		}
-		currentUserId, err := auth.ValidateJWT(receivedToken, cfg.secretKey)
-		if err != nil {
-			log.Printf("%v", err)
-			w.WriteHeader(401)
-			w.Write([]byte("This user is not authorized to make this request"))
+		next(w, r, cfg, currentUser.ID)
+	}
+}
+
+// middlewareRequirePermission only lets through the users whose role grants the permission. The role is read from
+// the database rather than from the token claim, so a demoted user loses their access right away
+func (cfg *ApiConfig) middlewareRequirePermission(permission auth.Permission, next func(http.ResponseWriter, *http.Request, *ApiConfig, uuid.UUID)) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, currentUser, ok := cfg.authenticate(w, r, auth.SessionOnly)
+		if !ok {
 			return
 		}
-		next(w, r, cfg, currentUserId)
+		if !auth.Role(currentUser.Role).Can(permission) {
+			log.Printf("user %v (%v) is missing the %v permission", currentUser.ID, currentUser.Role, permission)
+			w.WriteHeader(403)
+			w.Write([]byte("This user is not allowed to make this request"))
+			return
+		}
+		next(w, r, cfg, currentUser.ID)
 	}
 }
 
+// authenticate writes the error response itself when the request doesn't come from an active user.
+// The returned request carries the token claims in its context
+func (cfg *ApiConfig) authenticate(w http.ResponseWriter, r *http.Request, scope auth.Scope) (*http.Request, database.User, bool) {
+	unauthorized := func(err error) (*http.Request, database.User, bool) {
+		writeUnauthorized(w, err)
+		return r, database.User{}, false
+	}
+	if auth.IsApiKeyAuthorization(r.Header) {
+		apiKey, err := cfg.findApiKey(r.Context(), r.Header)
+		if err != nil {
+			return unauthorized(fmt.Errorf("invalid API key: %w", err))
+		}
+		if scope == auth.SessionOnly || !auth.HasScope(apiKey.Scopes, scope) {
+			writeMissingScope(w, "API key", scope)
+			return r, database.User{}, false
+		}
+		currentUser, ok := cfg.activeUser(w, r, apiKey.UserID)
+		return r, currentUser, ok
+	}
+	receivedToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return unauthorized(err)
+	}
+	claims, err := auth.ParseJWT(receivedToken, cfg.keyring)
+	if err != nil {
+		return unauthorized(err)
+	}
+	if claims.Purpose != "" {
+		return unauthorized(auth.ErrTokenPurpose)
+	}
+	// the token of an OAuth client is limited to the scopes the user consented to, like an API key
+	if claims.ClientId != "" && (scope == auth.SessionOnly || !auth.HasScope(claims.Scopes(), scope)) {
+		writeMissingScope(w, "application", scope)
+		return r, database.User{}, false
+	}
+	currentUserId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return unauthorized(err)
+	}
+	// access tokens stay valid until they expire, so a logged out session has to be checked on every request
+	if claims.SessionId != "" {
+		sessionId, err := uuid.Parse(claims.SessionId)
+		if err != nil {
+			return unauthorized(err)
+		}
+		active, err := cfg.dbQueries.IsSessionActive(r.Context(), sessionId)
+		if err != nil || !active {
+			return unauthorized(fmt.Errorf("the session %v is not active anymore: %v", sessionId, err))
+		}
+	}
+	currentUser, ok := cfg.activeUser(w, r, currentUserId)
+	return r.WithContext(context.WithValue(r.Context(), claimsContextKey{}, claims)), currentUser, ok
+}
+
+func writeMissingScope(w http.ResponseWriter, credential string, scope auth.Scope) {
+	w.WriteHeader(403)
+	if scope == auth.SessionOnly {
+		w.Write([]byte(fmt.Sprintf("An %v can't make this request, only a logged in user can", credential)))
+		return
+	}
+	w.Write([]byte(fmt.Sprintf("This %v is not allowed to make this request, it needs the '%v' scope", credential, scope)))
+}
+
+func writeUnauthorized(w http.ResponseWriter, err error) {
+	log.Printf("%v", err)
+	w.WriteHeader(401)
+	w.Write([]byte("This user is not authorized to make this request"))
+}
+
+// activeUser refuses the suspended users, the tokens and the API keys don't know about suspensions
+func (cfg *ApiConfig) activeUser(w http.ResponseWriter, r *http.Request, currentUserId uuid.UUID) (database.User, bool) {
+	currentUser, err := cfg.dbQueries.GetUserById(r.Context(), currentUserId)
+	if err != nil {
+		writeUnauthorized(w, fmt.Errorf("error when getting the authenticated user: %w", err))
+		return database.User{}, false
+	}
+	if currentUser.SuspendedAt.Valid {
+		w.WriteHeader(403)
+		w.Write([]byte("This account is suspended"))
+		return database.User{}, false
+	}
+	return currentUser, true
+}
+
+type claimsContextKey struct{}
+
+// sessionIdFromContext gives the session of the access token, tokens issued before sessions existed have none
+func sessionIdFromContext(ctx context.Context) uuid.NullUUID {
+	claims, ok := ctx.Value(claimsContextKey{}).(*auth.Claims)
+	if !ok {
+		return uuid.NullUUID{}
+	}
+	sessionId, err := uuid.Parse(claims.SessionId)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: sessionId, Valid: true}
+}
+
+// for public routes that show more information to logged in users, an invalid token is treated as an anonymous request.
+// An API key or the token of an OAuth client needs the chirps:read scope to be recognized
+func (cfg *ApiConfig) optionalUserId(r *http.Request) uuid.NullUUID {
+	if auth.IsApiKeyAuthorization(r.Header) {
+		apiKey, err := cfg.findApiKey(r.Context(), r.Header)
+		if err != nil || !auth.HasScope(apiKey.Scopes, auth.ScopeChirpsRead) {
+			return uuid.NullUUID{}
+		}
+		return uuid.NullUUID{UUID: apiKey.UserID, Valid: true}
+	}
+	receivedToken, err := auth.GetBearerToken(r.Header)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	claims, err := auth.ParseJWT(receivedToken, cfg.keyring)
+	if err != nil || claims.Purpose != "" || claims.ClientId != "" && !auth.HasScope(claims.Scopes(), auth.ScopeChirpsRead) {
+		return uuid.NullUUID{}
+	}
+	currentUserId, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return uuid.NullUUID{}
+	}
+	return uuid.NullUUID{UUID: currentUserId, Valid: true}
+}
+
+// newPasswordParams overrides the argon2id parameters of the password hashes with PASSWORD_HASH_MEMORY_KIB,
+// PASSWORD_HASH_ITERATIONS and PASSWORD_HASH_PARALLELISM. The hashes made with other parameters are upgraded at login
+func newPasswordParams() (auth.PasswordParams, error) {
+	params := auth.DefaultPasswordParams
+	values := []struct {
+		variable string
+		value    *uint32
+	}{
+		{variable: "PASSWORD_HASH_MEMORY_KIB", value: &params.Memory},
+		{variable: "PASSWORD_HASH_ITERATIONS", value: &params.Iterations},
+	}
+	for _, value := range values {
+		rawValue := os.Getenv(value.variable)
+		if rawValue == "" {
+			continue
+		}
+		parsedValue, err := strconv.ParseUint(rawValue, 10, 32)
+		if err != nil {
+			return auth.PasswordParams{}, fmt.Errorf("%v should be a positive number: %w", value.variable, err)
+		}
+		*value.value = uint32(parsedValue)
+	}
+	if rawParallelism := os.Getenv("PASSWORD_HASH_PARALLELISM"); rawParallelism != "" {
+		parallelism, err := strconv.ParseUint(rawParallelism, 10, 8)
+		if err != nil {
+			return auth.PasswordParams{}, fmt.Errorf("PASSWORD_HASH_PARALLELISM should be a number between 1 and 255: %w", err)
+		}
+		params.Parallelism = uint8(parallelism)
+	}
+	return params, nil
+}
+
+// newTokenPolicy overrides the default lifetimes with ACCESS_TOKEN_TTL, ACCESS_TOKEN_MAX_TTL, REFRESH_TOKEN_TTL and
+// REFRESH_TOKEN_MAX_TTL, TOKEN_CLIENT_TTLS sets the lifetimes of each kind of client like "web=15m/168h,mobile=1h/2160h"
+func newTokenPolicy() (auth.TokenPolicy, error) {
+	policy := auth.DefaultTokenPolicy
+	durations := []struct {
+		variable string
+		value    *time.Duration
+	}{
+		{variable: "ACCESS_TOKEN_TTL", value: &policy.Default.Access},
+		{variable: "ACCESS_TOKEN_MAX_TTL", value: &policy.Max.Access},
+		{variable: "REFRESH_TOKEN_TTL", value: &policy.Default.Refresh},
+		{variable: "REFRESH_TOKEN_MAX_TTL", value: &policy.Max.Refresh},
+	}
+	for _, duration := range durations {
+		rawDuration := os.Getenv(duration.variable)
+		if rawDuration == "" {
+			continue
+		}
+		value, err := time.ParseDuration(rawDuration)
+		if err != nil {
+			return auth.TokenPolicy{}, fmt.Errorf("%v should be a duration like '1h': %w", duration.variable, err)
+		}
+		*duration.value = value
+	}
+	clients, err := auth.ParseClientLifetimes(os.Getenv("TOKEN_CLIENT_TTLS"))
+	if err != nil {
+		return auth.TokenPolicy{}, fmt.Errorf("TOKEN_CLIENT_TTLS: %w", err)
+	}
+	policy.Clients = clients
+	err = policy.Validate()
+	if err != nil {
+		return auth.TokenPolicy{}, err
+	}
+	return policy, nil
+}
+
+// newMailer picks the mailer from MAILER: "smtp" sends through SMTP_HOST, "file" appends the emails to MAILER_FILE
+// and by default they are printed on stdout
+func newMailer() (mailer.Mailer, error) {
+	from := os.Getenv("MAIL_FROM")
+	if from == "" {
+		from = "Chirpy <no-reply@chirpy.local>"
+	}
+	switch os.Getenv("MAILER") {
+	case "smtp":
+		host := os.Getenv("SMTP_HOST")
+		if host == "" {
+			return nil, fmt.Errorf("SMTP_HOST is required by the smtp mailer")
+		}
+		port := os.Getenv("SMTP_PORT")
+		if port == "" {
+			port = "587"
+		}
+		return mailer.NewSMTPMailer(host, port, os.Getenv("SMTP_USERNAME"), os.Getenv("SMTP_PASSWORD"), from), nil
+	case "file":
+		return mailer.NewFileMailer(os.Getenv("MAILER_FILE"), from)
+	case "", "stdout":
+		return mailer.NewWriterMailer(os.Stdout, from), nil
+	}
+	return nil, fmt.Errorf("MAILER should be smtp, file or stdout, not '%v'", os.Getenv("MAILER"))
+}
+
 func main() {
 	port := "8080"
 	err := godotenv.Load()
 	if err != nil {
-		fmt.Errorf("server unable to read the environment variable")
-		os.Exit(1)
+		log.Fatal("server unable to read the environment variable")
 	}
 	dbUrl := os.Getenv("DB_URL")
 	db, err := sql.Open("postgres", dbUrl)
 	if err != nil {
-		fmt.Errorf("server unable to connect to database")
-		os.Exit(1)
+		log.Fatal("server unable to connect to database")
 	}
 	dbQueries := database.New(db)
+	if len(os.Args) > 1 && os.Args[1] == "bootstrap-admin" {
+		err = bootstrapAdmin(context.Background(), dbQueries, os.Args[2:])
+		if err != nil {
+			log.Fatalf("unable to bootstrap the admin: %v", err)
+		}
+		return
+	}
+	chirpEditWindow := 15 * time.Minute
+	if rawEditWindow := os.Getenv("CHIRP_EDIT_WINDOW"); rawEditWindow != "" {
+		chirpEditWindow, err = time.ParseDuration(rawEditWindow)
+		if err != nil {
+			log.Fatalf("CHIRP_EDIT_WINDOW should be a duration like '15m': %v", err)
+		}
+	}
+	polkaWebhookTolerance := defaultWebhookTolerance
+	if rawTolerance := os.Getenv("POLKA_WEBHOOK_TOLERANCE"); rawTolerance != "" {
+		polkaWebhookTolerance, err = time.ParseDuration(rawTolerance)
+		if err != nil {
+			log.Fatalf("POLKA_WEBHOOK_TOLERANCE should be a duration like '5m': %v", err)
+		}
+	}
+	polkaWebhookSecret := os.Getenv("POLKA_WEBHOOK_SECRET")
+	if polkaWebhookSecret == "" {
+		log.Printf("POLKA_WEBHOOK_SECRET is not set, the Polka webhooks are only checked with POLKA_KEY and can be replayed")
+	}
+	tokenPolicy, err := newTokenPolicy()
+	if err != nil {
+		log.Fatalf("server unable to read the token lifetimes: %v", err)
+	}
+	passwordParams, err := newPasswordParams()
+	if err == nil {
+		err = auth.SetPasswordParams(passwordParams)
+	}
+	if err != nil {
+		log.Fatalf("server unable to read the password hash parameters: %v", err)
+	}
+	appMailer, err := newMailer()
+	if err != nil {
+		log.Fatalf("server unable to set up the mailer: %v", err)
+	}
+	publicUrl := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	if publicUrl == "" {
+		publicUrl = "http://localhost:" + port
+	}
 	config := ApiConfig{
-		fileserverHits: atomic.Int32{},
-		dbQueries:      dbQueries,
-		secretKey:      os.Getenv("SECRET"),
-		polkaKey:       os.Getenv("POLKA_KEY"),
+		fileserverHits:        atomic.Int32{},
+		db:                    db,
+		dbQueries:             dbQueries,
+		polkaKey:              os.Getenv("POLKA_KEY"),
+		chirpEditWindow:       chirpEditWindow,
+		tokenPolicy:           tokenPolicy,
+		mailer:                appMailer,
+		publicUrl:             publicUrl,
+		requireVerifiedEmail:  os.Getenv("REQUIRE_VERIFIED_EMAIL") == "true",
+		polkaWebhookSecret:    polkaWebhookSecret,
+		polkaWebhookTolerance: polkaWebhookTolerance,
+	}
+	config.onLockout = config.emailLockoutNotice
+	config.moderation, err = newModerationChain(&config)
+	if err != nil {
+		log.Fatalf("server unable to load the moderation rules: %v", err)
+	}
+	config.keyring, err = newKeyring(&config)
+	if err != nil {
+		log.Fatalf("server unable to load the signing keys: %v", err)
 	}
+	go config.rotateSigningKeysPeriodically()
+	go config.expireSubscriptionsPeriodically()
+	go config.deleteStaleLoginThrottlesPeriodically()
+	go config.reloadModerationWordsPeriodically()
+	config.events = newEventBus(db, dbQueries)
+	config.events.subscribe("webhooks", queueWebhookDeliveries, slices.Collect(maps.Keys(webhookEventTypes))...)
+	config.events.subscribe("mailer", config.sendEventEmails, eventEmailVerificationRequested, eventPasswordResetRequested,
+		eventLoginLockedOut)
+	go config.events.run()
+	go config.deliverWebhooksPeriodically()
+	config.chirpStream = newChirpStream()
+	go config.listenToChirpEvents(dbUrl)
 
 	serveMux := http.NewServeMux()
 	serveMux.HandleFunc("/api/healthz", handleReadiness)
+	serveMux.HandleFunc("GET /.well-known/jwks.json", config.handleJWKS)
 	serveMux.HandleFunc("/api/metrics", config.handlerMetrics)
-	serveMux.HandleFunc("POST /api/chirps", config.middlewareCheckAuth(handlePostChirp))
+	serveMux.HandleFunc("POST /api/chirps", config.middlewareCheckAuth(auth.ScopeChirpsWrite, handlePostChirp))
 	serveMux.HandleFunc("GET /api/chirps", config.handleListChirps)
+	serveMux.HandleFunc("GET /api/chirps/search", config.handleSearchChirps)
+	serveMux.HandleFunc("GET /api/stream/chirps", config.handleChirpStream)
 	serveMux.HandleFunc("GET /api/chirps/{chirpId}", config.handleGetChirpById)
-	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}", config.middlewareCheckAuth(handlerDeleteChirp))
+	serveMux.HandleFunc("PUT /api/chirps/{chirpId}", config.middlewareCheckAuth(auth.ScopeChirpsWrite, handlerEditChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}", config.middlewareCheckAuth(auth.ScopeChirpsWrite, handlerDeleteChirp))
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}/revisions", config.handleListChirpRevisions)
+	serveMux.HandleFunc("GET /api/chirps/{chirpId}/thread", config.handleGetChirpThread)
+	serveMux.HandleFunc("POST /api/chirps/{chirpId}/like", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerLikeChirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}/like", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerUnlikeChirp))
+	serveMux.HandleFunc("POST /api/chirps/{chirpId}/report", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerReportChirp))
+	serveMux.HandleFunc("POST /api/chirps/{chirpId}/rechirp", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerRechirp))
+	serveMux.HandleFunc("DELETE /api/chirps/{chirpId}/rechirp", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerUndoRechirp))
 	serveMux.HandleFunc("POST /api/users", config.handleCreateUser)
-	serveMux.HandleFunc("PUT /api/users", config.middlewareCheckAuth(handlerEditUser))
+	serveMux.HandleFunc("PUT /api/users", config.middlewareCheckAuth(auth.SessionOnly, handlerEditUser))
+	serveMux.HandleFunc("POST /api/users/{userId}/follow", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerFollowUser))
+	serveMux.HandleFunc("DELETE /api/users/{userId}/follow", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerUnfollowUser))
+	serveMux.HandleFunc("POST /api/users/{userId}/report", config.middlewareCheckAuth(auth.ScopeSocialWrite, handlerReportUser))
+	serveMux.HandleFunc("GET /api/users/{userId}/followers", config.handleListFollowers)
+	serveMux.HandleFunc("GET /api/users/{userId}/following", config.handleListFollowing)
+	serveMux.HandleFunc("GET /api/timeline", config.middlewareCheckAuth(auth.ScopeChirpsRead, handlerTimeline))
 	serveMux.HandleFunc("POST /api/login", config.handleLogin)
+	serveMux.HandleFunc("POST /api/login/2fa", config.handleLoginTwoFactor)
+	serveMux.HandleFunc("POST /api/2fa/enroll", config.middlewareCheckAuth(auth.SessionOnly, handlerEnrollTotp))
+	serveMux.HandleFunc("POST /api/2fa/confirm", config.middlewareCheckAuth(auth.SessionOnly, handlerConfirmTotp))
+	serveMux.HandleFunc("POST /api/2fa/disable", config.middlewareCheckAuth(auth.SessionOnly, handlerDisableTotp))
+	serveMux.HandleFunc("POST /api/email/verify", config.handleVerifyEmail)
+	serveMux.HandleFunc("POST /api/email/verify/resend", config.middlewareCheckAuth(auth.SessionOnly, handlerResendEmailVerification))
+	serveMux.HandleFunc("POST /api/password/forgot", config.handleForgotPassword)
+	serveMux.HandleFunc("POST /api/password/reset", config.handleResetPassword)
+	serveMux.HandleFunc("GET /api/keys", config.middlewareCheckAuth(auth.SessionOnly, handlerListApiKeys))
+	serveMux.HandleFunc("POST /api/keys", config.middlewareCheckAuth(auth.SessionOnly, handlerCreateApiKey))
+	serveMux.HandleFunc("DELETE /api/keys/{keyId}", config.middlewareCheckAuth(auth.SessionOnly, handlerRevokeApiKey))
+	serveMux.HandleFunc("GET /api/oauth/clients", config.middlewareCheckAuth(auth.SessionOnly, handlerListOAuthClients))
+	serveMux.HandleFunc("POST /api/oauth/clients", config.middlewareCheckAuth(auth.SessionOnly, handlerCreateOAuthClient))
+	serveMux.HandleFunc("DELETE /api/oauth/clients/{clientId}", config.middlewareCheckAuth(auth.SessionOnly, handlerDeleteOAuthClient))
+	serveMux.HandleFunc("GET /oauth/authorize", config.handleOAuthAuthorize)
+	serveMux.HandleFunc("POST /oauth/authorize", config.handleOAuthConsent)
+	serveMux.HandleFunc("POST /oauth/token", config.handleOAuthToken)
+	serveMux.HandleFunc("POST /oauth/revoke", config.handleOAuthRevoke)
+	serveMux.HandleFunc("POST /oauth/introspect", config.handleOAuthIntrospect)
 	serveMux.HandleFunc("POST /api/refresh", config.handlerRefreshToken)
 	serveMux.HandleFunc("POST /api/revoke", config.handlerRevokeRefreshToken)
-	serveMux.HandleFunc("POST /api/polka/webhooks", config.handlerUpgradeUserToChirpRed)
-	serveMux.HandleFunc("/admin/reset", config.handlerReset) // adding a namespace "admin" (in backend server means a prefix to a path)
-	serveMux.HandleFunc("/admin/metrics", config.handlerAdminMetrics)
+	serveMux.HandleFunc("GET /api/sessions", config.middlewareCheckAuth(auth.SessionOnly, handlerListSessions))
+	serveMux.HandleFunc("DELETE /api/sessions/others", config.middlewareCheckAuth(auth.SessionOnly, handlerRevokeOtherSessions))
+	serveMux.HandleFunc("DELETE /api/sessions/{sessionId}", config.middlewareCheckAuth(auth.SessionOnly, handlerRevokeSession))
+	serveMux.HandleFunc("GET /api/subscription", config.middlewareCheckAuth(auth.SessionOnly, handlerGetSubscription))
+	serveMux.HandleFunc("POST /api/polka/webhooks", config.handlerPolkaWebhook)
+	serveMux.HandleFunc("/admin/reset", config.middlewareRequirePermission(auth.PermResetDatabase, handlerReset)) // adding a namespace "admin" (in backend server means a prefix to a path)
+	serveMux.HandleFunc("/admin/metrics", config.middlewareRequirePermission(auth.PermViewMetrics, handlerAdminMetrics))
+	serveMux.HandleFunc("GET /admin/lockouts", config.middlewareRequirePermission(auth.PermManageLockouts, handlerListLoginLockouts))
+	serveMux.HandleFunc("DELETE /admin/lockouts/{kind}/{subject}", config.middlewareRequirePermission(auth.PermManageLockouts, handlerClearLoginLockout))
+	serveMux.HandleFunc("GET /admin/webhooks/events", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerListWebhookEvents))
+	serveMux.HandleFunc("GET /admin/webhooks/events/{eventId}", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerGetWebhookEvent))
+	serveMux.HandleFunc("POST /admin/webhooks/events/{eventId}/reprocess", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerReprocessWebhookEvent))
+	serveMux.HandleFunc("POST /admin/webhooks/subscriptions", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerCreateWebhookSubscription))
+	serveMux.HandleFunc("GET /admin/webhooks/subscriptions", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerListWebhookSubscriptions))
+	serveMux.HandleFunc("GET /admin/webhooks/subscriptions/{subscriptionId}", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerGetWebhookSubscription))
+	serveMux.HandleFunc("DELETE /admin/webhooks/subscriptions/{subscriptionId}", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerDeleteWebhookSubscription))
+	serveMux.HandleFunc("GET /admin/webhooks/deliveries", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerListWebhookDeliveries))
+	serveMux.HandleFunc("GET /admin/webhooks/deliveries/{deliveryId}", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerGetWebhookDelivery))
+	serveMux.HandleFunc("POST /admin/webhooks/deliveries/{deliveryId}/retry", config.middlewareRequirePermission(auth.PermManageWebhooks, handlerRetryWebhookDelivery))
+	serveMux.HandleFunc("PUT /admin/users/{userId}/role", config.middlewareRequirePermission(auth.PermManageRoles, handlerSetUserRole))
+	serveMux.HandleFunc("GET /admin/moderation/words", config.middlewareRequirePermission(auth.PermManageWordLists, handlerListModerationWords))
+	serveMux.HandleFunc("PUT /admin/moderation/words/{word}", config.middlewareRequirePermission(auth.PermManageWordLists, handlerPutModerationWord))
+	serveMux.HandleFunc("DELETE /admin/moderation/words/{word}", config.middlewareRequirePermission(auth.PermManageWordLists, handlerDeleteModerationWord))
+	serveMux.HandleFunc("GET /admin/moderation/reports", config.middlewareRequirePermission(auth.PermModerate, handlerListReports))
+	serveMux.HandleFunc("GET /admin/moderation/held", config.middlewareRequirePermission(auth.PermModerate, handlerListHeldChirps))
+	serveMux.HandleFunc("GET /admin/moderation/actions", config.middlewareRequirePermission(auth.PermModerate, handlerListModerationActions))
+	serveMux.HandleFunc("POST /admin/moderation/actions", config.middlewareRequirePermission(auth.PermModerate, handlerModerationAction))
 	serveMux.HandleFunc("GET /healthz", handleReadiness)
 	serveMux.HandleFunc("GET /metrics", config.handlerMetrics)
-	serveMux.HandleFunc("POST /reset", config.handlerReset)
+	serveMux.HandleFunc("POST /reset", config.middlewareRequirePermission(auth.PermResetDatabase, handlerReset))
 	serveMux.Handle("/app/", config.middlewareMetricsInc(http.StripPrefix("/app", http.FileServer(http.Dir(".")))))
 	server := http.Server{
 		Addr:    ":" + port,
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultPageLimit = 20
+	maxPageLimit     = 100
+)
+
+type chirpCursor struct { // position of the last chirp sent, the next page starts right after it
+	CreatedAt time.Time `json:"created_at"`
+	Id        uuid.UUID `json:"id"`
+}
+
+type webhookDeliveryCursor struct {
+	CreatedAt time.Time `json:"created_at"`
+	Id        uuid.UUID `json:"id"`
+}
+
+type webhookEventCursor struct { // the event ids come from the senders, they are not UUIDs
+	ReceivedAt time.Time `json:"received_at"`
+	Id         string    `json:"id"`
+}
+
+// cursors are opaque for the clients: they are just base64 encoded JSON so we can change what's inside without breaking them
+func encodeCursor[T any](position T) (string, error) {
+	jsonPosition, err := json.Marshal(&position)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(jsonPosition), nil
+}
+
+func decodeCursor[T any](cursor string) (*T, error) {
+	if cursor == "" {
+		return nil, nil
+	}
+	jsonPosition, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	var position T
+	err = json.Unmarshal(jsonPosition, &position)
+	if err != nil {
+		return nil, fmt.Errorf("malformed cursor: %w", err)
+	}
+	return &position, nil
+}
+
+func parsePageLimit(query url.Values) (int, error) {
+	rawLimit := query.Get("limit")
+	if rawLimit == "" {
+		return defaultPageLimit, nil
+	}
+	limit, err := strconv.Atoi(rawLimit)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("limit should be a positive integer")
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	return limit, nil
+}
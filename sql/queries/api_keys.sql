@@ -0,0 +1,16 @@
+-- name: CreateApiKey :one
+INSERT INTO api_keys(id, user_id, name, key_hash, prefix, scopes, created_at, expires_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, NOW(), $6)
+RETURNING *;
+
+-- name: GetActiveApiKeyByHash :one
+SELECT * FROM api_keys WHERE key_hash= $1 AND revoked_at IS NULL AND expires_at > NOW() LIMIT 1;
+
+-- name: TouchApiKey :exec
+UPDATE api_keys SET last_used_at= NOW() WHERE id= $1;
+
+-- name: ListUserApiKeys :many
+SELECT * FROM api_keys WHERE user_id= $1 AND revoked_at IS NULL ORDER BY created_at DESC;
+
+-- name: RevokeApiKey :execrows
+UPDATE api_keys SET revoked_at= NOW() WHERE id= $1 AND user_id= $2 AND revoked_at IS NULL;
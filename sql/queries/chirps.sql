@@ -1,18 +1,97 @@
 -- name: CreateChirp :one
-INSERT INTO chirps (id, created_at, updated_at, body, user_id)
+INSERT INTO chirps (id, created_at, updated_at, body, user_id, in_reply_to, root_id, quote_of, status)
 VALUES (
-    gen_random_uuid(), NOW(), NOW(), $1, $2
+    gen_random_uuid(), NOW(), NOW(), $1, $2, $3, $4, $5, $6
 ) RETURNING *;
 
--- name: GetAllChirps :many
-SELECT * FROM chirps ORDER BY created_at ASC;
-
 -- name: GetChirpById :one
 SELECT * FROM chirps WHERE id= $1 LIMIT 1;
 
--- name: GetAllChirpsFromAuthor :many
-SELECT * FROM chirps WHERE user_id=$1 ORDER BY created_at ASC;
+-- name: GetChirpByIdForUpdate :one
+SELECT * FROM chirps WHERE id= $1 LIMIT 1 FOR UPDATE;
+
+-- name: UpdateChirpBody :one
+UPDATE chirps SET body= $2, status= $3, updated_at= NOW() WHERE id= $1 RETURNING *;
+
+-- name: GetChirpsByIds :many
+SELECT * FROM chirps WHERE id= ANY(sqlc.arg('ids')::uuid[]);
 
 -- name: DeleteChirpWithId :exec
 DELETE FROM chirps WHERE id=$1;
 
+-- name: ChirpHasReplies :one
+SELECT EXISTS(SELECT 1 FROM chirps WHERE in_reply_to= $1);
+
+-- name: TombstoneChirp :exec
+UPDATE chirps SET body= '', deleted_at= NOW(), updated_at= NOW() WHERE id= $1;
+
+-- name: ListChirpsAsc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL AND status= 'published'
+    AND (sqlc.narg('author_id')::uuid IS NULL OR user_id= sqlc.narg('author_id')::uuid)
+    AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ListChirpsDesc :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL AND status= 'published'
+    AND (sqlc.narg('author_id')::uuid IS NULL OR user_id= sqlc.narg('author_id')::uuid)
+    AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: SearchChirps :many
+SELECT sqlc.embed(chirps), ts_rank(chirps.search_vector, query)::real AS rank
+FROM chirps, to_tsquery('english', sqlc.arg('query')) AS query
+WHERE chirps.search_vector @@ query AND chirps.deleted_at IS NULL AND chirps.status= 'published'
+    AND (sqlc.narg('author_id')::uuid IS NULL OR chirps.user_id= sqlc.narg('author_id')::uuid)
+    AND (sqlc.narg('since')::timestamp IS NULL OR chirps.created_at >= sqlc.narg('since')::timestamp)
+    AND (sqlc.narg('until')::timestamp IS NULL OR chirps.created_at < sqlc.narg('until')::timestamp)
+    AND (sqlc.narg('after_rank')::real IS NULL OR (ts_rank(chirps.search_vector, query)::real, chirps.id) < (sqlc.narg('after_rank')::real, sqlc.narg('after_id')::uuid))
+ORDER BY rank DESC, chirps.id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: GetTimeline :many
+SELECT chirps.* FROM chirps JOIN follows ON follows.followee_id= chirps.user_id
+WHERE follows.follower_id= sqlc.arg('user_id') AND chirps.deleted_at IS NULL AND chirps.status= 'published'
+    AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (chirps.created_at, chirps.id) < (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY chirps.created_at DESC, chirps.id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: GetChirpAncestors :many
+WITH RECURSIVE ancestors AS (
+    SELECT parent.id, parent.in_reply_to, 1 AS depth
+    FROM chirps parent JOIN chirps child ON child.in_reply_to= parent.id
+    WHERE child.id= sqlc.arg('chirp_id')
+    UNION ALL
+    SELECT chirps.id, chirps.in_reply_to, ancestors.depth + 1
+    FROM chirps JOIN ancestors ON chirps.id= ancestors.in_reply_to
+)
+SELECT chirps.* FROM ancestors JOIN chirps ON chirps.id= ancestors.id
+ORDER BY ancestors.depth DESC;
+
+-- name: GetChirpDescendants :many
+-- the path orders the replies depth first (each level sorted by creation date), so a page is always a piece of the tree
+WITH RECURSIVE descendants AS (
+    SELECT id, 1 AS depth, to_char(created_at, 'YYYYMMDDHH24MISSUS') || id::text AS path
+    FROM chirps WHERE in_reply_to= sqlc.arg('chirp_id')
+    UNION ALL
+    SELECT chirps.id, descendants.depth + 1, descendants.path || '/' || to_char(chirps.created_at, 'YYYYMMDDHH24MISSUS') || chirps.id::text
+    FROM chirps JOIN descendants ON chirps.in_reply_to= descendants.id
+)
+SELECT sqlc.embed(chirps), descendants.depth::int AS depth, descendants.path::text AS path
+FROM descendants JOIN chirps ON chirps.id= descendants.id
+WHERE (sqlc.narg('after_path')::text IS NULL OR descendants.path COLLATE "C" > sqlc.narg('after_path')::text)
+ORDER BY descendants.path COLLATE "C"
+LIMIT sqlc.arg('row_limit');
+
+-- name: SetChirpStatus :execrows
+UPDATE chirps SET status= $2, updated_at= NOW() WHERE id= $1 AND deleted_at IS NULL;
+
+-- name: ListHeldChirps :many
+SELECT * FROM chirps
+WHERE deleted_at IS NULL AND status= 'held'
+    AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('row_limit');
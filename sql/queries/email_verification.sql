@@ -0,0 +1,17 @@
+-- name: CreateEmailVerificationToken :exec
+INSERT INTO email_verification_tokens(token_hash, user_id, email, created_at, expires_at)
+VALUES ($1, $2, $3, NOW(), $4);
+
+-- name: UseEmailVerificationToken :one
+UPDATE email_verification_tokens SET used_at= NOW()
+WHERE token_hash= $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id, email;
+
+-- name: InvalidateUserEmailVerificationTokens :exec
+-- only the latest requested address can be verified
+UPDATE email_verification_tokens SET used_at= NOW() WHERE user_id= $1 AND used_at IS NULL;
+
+-- name: GetPendingEmail :one
+SELECT email FROM email_verification_tokens
+WHERE user_id= $1 AND used_at IS NULL AND expires_at > NOW()
+ORDER BY created_at DESC LIMIT 1;
@@ -0,0 +1,25 @@
+-- name: FollowUser :exec
+INSERT INTO follows(follower_id, followee_id, created_at)
+VALUES ($1, $2, NOW()) ON CONFLICT DO NOTHING;
+
+-- name: UnfollowUser :exec
+DELETE FROM follows WHERE follower_id= $1 AND followee_id= $2;
+
+-- name: ListFollowers :many
+SELECT users.id, users.created_at, users.is_chirpy_red, follows.created_at AS followed_at
+FROM follows JOIN users ON users.id= follows.follower_id
+WHERE follows.followee_id= sqlc.arg('user_id')
+    AND (sqlc.narg('after_followed_at')::timestamp IS NULL OR (follows.created_at, follows.follower_id) < (sqlc.narg('after_followed_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY follows.created_at DESC, follows.follower_id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ListFollowing :many
+SELECT users.id, users.created_at, users.is_chirpy_red, follows.created_at AS followed_at
+FROM follows JOIN users ON users.id= follows.followee_id
+WHERE follows.follower_id= sqlc.arg('user_id')
+    AND (sqlc.narg('after_followed_at')::timestamp IS NULL OR (follows.created_at, follows.followee_id) < (sqlc.narg('after_followed_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY follows.created_at DESC, follows.followee_id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ListFolloweeIds :many
+SELECT followee_id FROM follows WHERE follower_id= $1;
@@ -0,0 +1,31 @@
+-- name: ReserveLoginAttempt :one
+-- the attempt counts as a failure until it is settled, the blocked_until returned is the one from before it. The
+-- failures are forgotten after a day without any
+INSERT INTO login_throttles(kind, subject, failures, last_failure_at, blocked_until)
+VALUES ($1, $2, 1, NOW(), NOW())
+ON CONFLICT (kind, subject) DO UPDATE SET
+    last_failure_at= CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 day' THEN NOW() ELSE login_throttles.last_failure_at END,
+    failures= CASE WHEN login_throttles.last_failure_at < NOW() - INTERVAL '1 day' THEN 1 ELSE login_throttles.failures + 1 END
+RETURNING failures, blocked_until;
+
+-- name: RecordLoginFailure :exec
+UPDATE login_throttles SET last_failure_at= NOW() WHERE kind= $1 AND subject= $2;
+
+-- name: RefundLoginAttempt :exec
+-- the block the attempt caused is lifted, unless another attempt blocked the subject again since
+UPDATE login_throttles SET failures= GREATEST(failures - 1, 0),
+    blocked_until= CASE WHEN blocked_until = sqlc.arg(reserved_block) THEN sqlc.arg(previous_block)::timestamp ELSE blocked_until END
+WHERE kind= sqlc.arg(kind) AND subject= sqlc.arg(subject);
+
+-- name: BlockLogin :one
+UPDATE login_throttles SET blocked_until= $3 WHERE kind= $1 AND subject= $2 RETURNING blocked_until;
+
+-- name: ClearLoginThrottle :execrows
+DELETE FROM login_throttles WHERE kind= $1 AND subject= $2;
+
+-- name: ListBlockedLogins :many
+SELECT * FROM login_throttles WHERE blocked_until > NOW() ORDER BY blocked_until DESC;
+
+-- name: DeleteStaleLoginThrottles :execrows
+-- the subjects that aren't blocked anymore and whose failures were given back or forgotten
+DELETE FROM login_throttles WHERE blocked_until < NOW() AND (failures = 0 OR last_failure_at < NOW() - INTERVAL '1 day');
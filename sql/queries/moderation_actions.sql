@@ -0,0 +1,10 @@
+-- name: CreateModerationAction :one
+INSERT INTO moderation_actions(id, created_at, moderator_id, action, chirp_id, user_id, notes)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5)
+RETURNING *;
+
+-- name: ListModerationActions :many
+SELECT * FROM moderation_actions
+WHERE (sqlc.narg('before_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('before_created_at')::timestamp, sqlc.narg('before_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
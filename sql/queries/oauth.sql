@@ -0,0 +1,23 @@
+-- name: CreateOAuthClient :one
+INSERT INTO oauth_clients(id, owner_id, name, secret_hash, redirect_uris, scopes, created_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, NOW())
+RETURNING *;
+
+-- name: GetOAuthClient :one
+SELECT * FROM oauth_clients WHERE id= $1 LIMIT 1;
+
+-- name: ListUserOAuthClients :many
+SELECT * FROM oauth_clients WHERE owner_id= $1 ORDER BY created_at DESC;
+
+-- name: DeleteOAuthClient :execrows
+DELETE FROM oauth_clients WHERE id= $1 AND owner_id= $2;
+
+-- name: CreateOAuthAuthorizationCode :exec
+INSERT INTO oauth_authorization_codes(code_hash, client_id, user_id, redirect_uri, scopes, code_challenge, created_at, expires_at, family_id)
+VALUES ($1, $2, $3, $4, $5, $6, NOW(), $7, $8);
+
+-- name: GetOAuthAuthorizationCodeForUpdate :one
+SELECT * FROM oauth_authorization_codes WHERE code_hash= $1 LIMIT 1 FOR UPDATE;
+
+-- name: UseOAuthAuthorizationCode :exec
+UPDATE oauth_authorization_codes SET used_at= NOW() WHERE code_hash= $1;
@@ -0,0 +1,50 @@
+-- name: CreateOutboxEvent :exec
+INSERT INTO outbox_events(id, event_type, payload, created_at, next_attempt_at) VALUES ($1, $2, $3, NOW(), NOW());
+
+-- name: ClaimDueOutboxEvents :many
+-- the claimed events are due again after the lease, another instance doesn't dispatch them meanwhile
+UPDATE outbox_events SET next_attempt_at= sqlc.arg('leased_until')
+WHERE id IN (
+    SELECT id FROM outbox_events WHERE dispatched_at IS NULL AND next_attempt_at <= NOW()
+    ORDER BY created_at LIMIT sqlc.arg('row_limit') FOR UPDATE SKIP LOCKED
+)
+RETURNING *;
+
+-- name: MarkOutboxEventHandled :execrows
+-- nothing is inserted when the subscriber already handled the event
+INSERT INTO outbox_handled_events(event_id, subscriber, handled_at) VALUES ($1, $2, NOW())
+ON CONFLICT (event_id, subscriber) DO NOTHING;
+
+-- name: MarkOutboxEventDispatched :exec
+UPDATE outbox_events SET dispatched_at= NOW(), attempts= attempts + 1, last_error= NULL WHERE id= $1;
+
+-- name: RetryOutboxEventLater :exec
+UPDATE outbox_events SET attempts= attempts + 1, next_attempt_at= $2, last_error= $3 WHERE id= $1;
+
+-- name: DeleteDispatchedOutboxEvents :execrows
+-- the stream replays from the oldest position left, the chirp events after one that is
+-- still kept are kept too, so what is left always follows the positions without a gap
+DELETE FROM outbox_events WHERE dispatched_at < $1
+AND (position IS NULL OR position < (
+    SELECT COALESCE(MIN(position), 9223372036854775807) FROM outbox_events WHERE position IS NOT NULL AND (dispatched_at IS NULL OR dispatched_at >= $1)
+));
+
+-- name: GetChirpStreamEvent :one
+-- the position of a chirp event is set once its transaction commits, the stream only reads the committed ones
+SELECT position::bigint AS position, event_type, payload FROM outbox_events
+WHERE position= sqlc.arg('position')::bigint AND event_type IN ('chirp.created', 'chirp.updated', 'chirp.published', 'chirp.deleted') LIMIT 1;
+
+-- name: ListChirpStreamEvents :many
+-- the events a stream missed, to replay them in order
+SELECT position::bigint AS position, event_type, payload FROM outbox_events
+WHERE position > sqlc.arg('position')::bigint AND event_type IN ('chirp.created', 'chirp.updated', 'chirp.published', 'chirp.deleted')
+ORDER BY position
+LIMIT sqlc.arg('row_limit');
+
+-- name: GetLastOutboxPosition :one
+SELECT COALESCE(MAX(position), 0)::bigint FROM outbox_events;
+
+-- name: GetOldestChirpStreamPosition :one
+-- the chirp events before it were pruned, 0 when none is left
+SELECT COALESCE(MIN(position), 0)::bigint FROM outbox_events
+WHERE event_type IN ('chirp.created', 'chirp.updated', 'chirp.published', 'chirp.deleted');
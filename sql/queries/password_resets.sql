@@ -0,0 +1,12 @@
+-- name: CreatePasswordResetToken :exec
+INSERT INTO password_reset_tokens(token_hash, user_id, created_at, expires_at)
+VALUES ($1, $2, NOW(), $3);
+
+-- name: UsePasswordResetToken :one
+-- a token can only be used once, the returned user id is the proof it was valid
+UPDATE password_reset_tokens SET used_at= NOW()
+WHERE token_hash= $1 AND used_at IS NULL AND expires_at > NOW()
+RETURNING user_id;
+
+-- name: InvalidateUserPasswordResetTokens :exec
+UPDATE password_reset_tokens SET used_at= NOW() WHERE user_id= $1 AND used_at IS NULL;
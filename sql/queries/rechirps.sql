@@ -0,0 +1,9 @@
+-- name: Rechirp :exec
+INSERT INTO rechirps(user_id, chirp_id, created_at)
+VALUES ($1, $2, NOW()) ON CONFLICT DO NOTHING;
+
+-- name: UndoRechirp :exec
+DELETE FROM rechirps WHERE user_id= $1 AND chirp_id= $2;
+
+-- name: GetRechirpedChirpIds :many
+SELECT chirp_id FROM rechirps WHERE user_id= sqlc.arg('user_id') AND chirp_id= ANY(sqlc.arg('chirp_ids')::uuid[]);
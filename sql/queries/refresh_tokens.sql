@@ -1,9 +1,42 @@
 -- name: CreateRefreshToken :one
-INSERT INTO refresh_tokens(token, created_at, updated_at, user_id, expires_at )
-VALUES ($1, NOW(), NOW(), $2, $3) RETURNING *;
+INSERT INTO refresh_tokens(token_hash, created_at, updated_at, user_id, expires_at, family_id, device_label, user_agent, ip_address, last_used_at,
+    client, access_token_ttl_seconds, oauth_client_id, scopes)
+VALUES ($1, NOW(), NOW(), $2, $3, $4, $5, $6, $7, NOW(), $8, $9, $10, $11) RETURNING *;
 
--- name: GetRefreshTokenById :one
-SELECT * FROM refresh_tokens WHERE token= $1 LIMIT 1;
+-- name: GetRefreshTokenByHashForUpdate :one
+SELECT * FROM refresh_tokens WHERE token_hash= $1 LIMIT 1 FOR UPDATE;
+
+-- name: GetRefreshTokenByHash :one
+SELECT * FROM refresh_tokens WHERE token_hash= $1 LIMIT 1;
+
+-- name: RotateRefreshToken :exec
+UPDATE refresh_tokens SET rotated_at= NOW(), updated_at= NOW() WHERE token_hash= $1;
 
 -- name: RevokeToken :exec
-UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE token= $1;
+-- revoking a token ends its whole family, the tokens it was rotated from included
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW()
+WHERE family_id= (SELECT family_id FROM refresh_tokens WHERE token_hash= $1) AND revoked_at IS NULL;
+
+-- name: RevokeRefreshTokenFamily :exec
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE family_id= $1 AND revoked_at IS NULL;
+
+-- name: RevokeUserRefreshTokens :exec
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND revoked_at IS NULL;
+
+-- name: ListUserSessions :many
+-- the active token of each family stands for the session, the first token of the family tells when it started
+SELECT refresh_tokens.family_id, refresh_tokens.device_label, refresh_tokens.user_agent, refresh_tokens.ip_address,
+    refresh_tokens.last_used_at, refresh_tokens.expires_at,
+    (SELECT MIN(family.created_at) FROM refresh_tokens AS family WHERE family.family_id= refresh_tokens.family_id)::timestamp AS started_at
+FROM refresh_tokens
+WHERE refresh_tokens.user_id= $1 AND refresh_tokens.rotated_at IS NULL AND refresh_tokens.revoked_at IS NULL AND refresh_tokens.expires_at > NOW()
+ORDER BY refresh_tokens.last_used_at DESC;
+
+-- name: IsSessionActive :one
+SELECT EXISTS(SELECT 1 FROM refresh_tokens WHERE family_id= $1 AND revoked_at IS NULL AND expires_at > NOW());
+
+-- name: RevokeUserSession :execrows
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND family_id= $2 AND revoked_at IS NULL;
+
+-- name: RevokeOtherUserSessions :execrows
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE user_id= $1 AND family_id <> $2 AND revoked_at IS NULL;
@@ -0,0 +1,19 @@
+-- name: CreateReport :one
+-- nothing is returned when the reporter already has an open report about the same chirp or user
+INSERT INTO reports(id, created_at, reporter_id, chirp_id, reported_user_id, reason, details)
+VALUES (gen_random_uuid(), NOW(), $1, $2, $3, $4, $5)
+ON CONFLICT DO NOTHING
+RETURNING *;
+
+-- name: ListReports :many
+SELECT * FROM reports
+WHERE (resolved_at IS NOT NULL)= sqlc.arg('resolved')::boolean
+    AND (sqlc.narg('after_created_at')::timestamp IS NULL OR (created_at, id) > (sqlc.narg('after_created_at')::timestamp, sqlc.narg('after_id')::uuid))
+ORDER BY created_at ASC, id ASC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ResolveReports :execrows
+-- resolves the listed reports, and every open report about the chirp or the user the action was taken on
+UPDATE reports SET resolved_at= NOW(), action_id= sqlc.arg('action_id')
+WHERE resolved_at IS NULL
+    AND (id= ANY(sqlc.arg('ids')::uuid[]) OR chirp_id= sqlc.narg('chirp_id')::uuid OR reported_user_id= sqlc.narg('reported_user_id')::uuid);
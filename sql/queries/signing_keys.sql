@@ -0,0 +1,9 @@
+-- name: ListSigningKeys :many
+SELECT * FROM signing_keys WHERE valid_until > NOW() ORDER BY signs_from DESC;
+
+-- name: CreateSigningKey :exec
+INSERT INTO signing_keys(id, algorithm, private_key, created_at, signs_from, signs_until, valid_until)
+VALUES ($1, $2, $3, NOW(), $4, $5, $6);
+
+-- name: DeleteExpiredSigningKeys :exec
+DELETE FROM signing_keys WHERE valid_until <= NOW();
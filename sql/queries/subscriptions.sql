@@ -0,0 +1,29 @@
+-- name: GetSubscription :one
+SELECT * FROM subscriptions WHERE user_id= $1 LIMIT 1;
+
+-- name: UpsertSubscription :one
+-- an upgrade or a renewal starts a new paid period, it also undoes a cancellation
+INSERT INTO subscriptions(user_id, plan, status, current_period_end, cancel_at_period_end, created_at, updated_at)
+VALUES ($1, $2, 'active', $3, FALSE, NOW(), NOW())
+ON CONFLICT (user_id) DO UPDATE SET plan= EXCLUDED.plan, status= 'active', current_period_end= EXCLUDED.current_period_end,
+    cancel_at_period_end= FALSE, updated_at= NOW()
+RETURNING *;
+
+-- name: CancelSubscriptionAtPeriodEnd :execrows
+UPDATE subscriptions SET cancel_at_period_end= TRUE, updated_at= NOW() WHERE user_id= $1 AND status IN ('active', 'past_due');
+
+-- name: MarkSubscriptionPastDue :execrows
+UPDATE subscriptions SET status= 'past_due', updated_at= NOW() WHERE user_id= $1 AND status= 'active';
+
+-- name: RefundSubscription :execrows
+UPDATE subscriptions SET status= 'refunded', current_period_end= NOW(), updated_at= NOW() WHERE user_id= $1;
+
+-- name: ExpireSubscriptions :many
+-- the lapsed subscriptions lose the entitlement in the same statement, a cancelled one ends as canceled
+WITH expired AS (
+    UPDATE subscriptions SET status= CASE WHEN cancel_at_period_end THEN 'canceled' ELSE 'expired' END, updated_at= NOW()
+    WHERE status IN ('active', 'past_due') AND current_period_end < NOW()
+    RETURNING user_id
+)
+UPDATE users SET is_chirpy_red= FALSE, updated_at= NOW() WHERE id IN (SELECT user_id FROM expired)
+RETURNING id;
@@ -0,0 +1,33 @@
+-- name: StartTotpEnrolment :one
+-- a new enrolment replaces an unconfirmed one, nothing is returned when 2FA is already enabled
+INSERT INTO user_totp(user_id, secret, created_at)
+VALUES ($1, $2, NOW())
+ON CONFLICT (user_id) DO UPDATE SET secret= EXCLUDED.secret, created_at= NOW(), last_used_step= 0
+WHERE user_totp.confirmed_at IS NULL
+RETURNING *;
+
+-- name: GetUserTotp :one
+SELECT * FROM user_totp WHERE user_id= $1 LIMIT 1;
+
+-- name: ConfirmUserTotp :execrows
+UPDATE user_totp SET confirmed_at= NOW(), last_used_step= $2 WHERE user_id= $1 AND confirmed_at IS NULL;
+
+-- name: UseTotpStep :execrows
+-- a code can only be used once, so the step has to be newer than the last one used
+UPDATE user_totp SET last_used_step= $2 WHERE user_id= $1 AND last_used_step < $2;
+
+-- name: DeleteUserTotp :exec
+DELETE FROM user_totp WHERE user_id= $1;
+
+-- name: CreateRecoveryCode :exec
+INSERT INTO recovery_codes(id, user_id, code_hash, created_at)
+VALUES (gen_random_uuid(), $1, $2, NOW());
+
+-- name: ListUnusedRecoveryCodes :many
+SELECT * FROM recovery_codes WHERE user_id= $1 AND used_at IS NULL;
+
+-- name: UseRecoveryCode :execrows
+UPDATE recovery_codes SET used_at= NOW() WHERE id= $1 AND used_at IS NULL;
+
+-- name: DeleteRecoveryCodes :exec
+DELETE FROM recovery_codes WHERE user_id= $1;
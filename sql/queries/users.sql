@@ -12,7 +12,32 @@ SELECT * FROM users WHERE email= $1 LIMIT 1;
 SELECT * FROM users WHERE id= $1 LIMIT 1;
 
 -- name: UpdateUser :one
-UPDATE users SET updated_at=NOW(), email=$2, hashed_password=$3 WHERE id=$1 RETURNING * ;
+-- the email only changes once the new address is verified, see VerifyUserEmail, and the password with UpdateUserPassword
+UPDATE users SET updated_at=NOW() WHERE id=$1 RETURNING * ;
 
 -- name: UpgradeToChirpyRed :exec
 UPDATE users SET is_chirpy_red= TRUE WHERE id=$1;
+
+-- name: DowngradeFromChirpyRed :exec
+UPDATE users SET is_chirpy_red= FALSE WHERE id= $1;
+
+-- name: SuspendUser :execrows
+UPDATE users SET suspended_at= NOW(), updated_at= NOW() WHERE id= $1 AND suspended_at IS NULL;
+
+-- name: UnsuspendUser :execrows
+UPDATE users SET suspended_at= NULL, updated_at= NOW() WHERE id= $1 AND suspended_at IS NOT NULL;
+
+-- name: SetUserRole :one
+UPDATE users SET role= $2, updated_at= NOW() WHERE id= $1 RETURNING *;
+
+-- name: CountUsersWithRole :one
+SELECT COUNT(*) FROM users WHERE role= $1;
+
+-- name: UpdateUserPassword :exec
+UPDATE users SET hashed_password= $2, updated_at= NOW() WHERE id= $1;
+
+-- name: VerifyUserEmail :one
+-- nothing is returned when another account took the address since it was requested
+UPDATE users SET email= $2, email_verified_at= NOW(), updated_at= NOW()
+WHERE id= $1 AND NOT EXISTS (SELECT 1 FROM users AS other WHERE other.email= $2 AND other.id <> $1)
+RETURNING *;
@@ -0,0 +1,22 @@
+-- name: CreateWebhookEvent :exec
+INSERT INTO webhook_events(id, event_type, payload, received_at) VALUES ($1, $2, $3, NOW())
+ON CONFLICT (id) DO NOTHING;
+
+-- name: GetWebhookEvent :one
+SELECT * FROM webhook_events WHERE id= $1 LIMIT 1;
+
+-- name: GetWebhookEventForUpdate :one
+SELECT * FROM webhook_events WHERE id= $1 LIMIT 1 FOR UPDATE;
+
+-- name: MarkWebhookEventProcessed :exec
+UPDATE webhook_events SET processed_at= NOW(), attempts= attempts + 1, last_error= NULL WHERE id= $1;
+
+-- name: RecordWebhookEventFailure :exec
+UPDATE webhook_events SET attempts= attempts + 1, last_error= $2 WHERE id= $1;
+
+-- name: ListWebhookEvents :many
+SELECT * FROM webhook_events
+WHERE (NOT sqlc.arg('only_unprocessed')::boolean OR processed_at IS NULL)
+    AND (sqlc.narg('before_received_at')::timestamp IS NULL OR (received_at, id) < (sqlc.narg('before_received_at')::timestamp, sqlc.narg('before_id')::text))
+ORDER BY received_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
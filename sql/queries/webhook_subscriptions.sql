@@ -0,0 +1,60 @@
+-- name: CreateWebhookSubscription :one
+INSERT INTO webhook_subscriptions(id, url, secret, event_types, created_by, created_at)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, NOW())
+RETURNING *;
+
+-- name: GetWebhookSubscription :one
+SELECT * FROM webhook_subscriptions WHERE id= $1 LIMIT 1;
+
+-- name: ListWebhookSubscriptions :many
+SELECT * FROM webhook_subscriptions ORDER BY created_at DESC;
+
+-- name: DeleteWebhookSubscription :execrows
+DELETE FROM webhook_subscriptions WHERE id= $1;
+
+-- name: EnqueueWebhookDeliveries :exec
+-- one delivery per subscription wanting the event, inside the transaction of the change when there is one
+INSERT INTO webhook_deliveries(id, subscription_id, event_id, event_type, payload, next_attempt_at, created_at)
+SELECT gen_random_uuid(), id, $1, $2, $3, NOW(), NOW() FROM webhook_subscriptions
+WHERE cardinality(event_types) = 0 OR $2 = ANY(event_types);
+
+-- name: ClaimDueWebhookDeliveries :many
+-- the claimed deliveries are due again after the lease, another instance doesn't send them meanwhile
+UPDATE webhook_deliveries AS d SET next_attempt_at= sqlc.arg('leased_until')
+FROM webhook_subscriptions AS s
+WHERE d.subscription_id= s.id AND d.id IN (
+    SELECT id FROM webhook_deliveries WHERE status= 'pending' AND next_attempt_at <= NOW()
+    ORDER BY next_attempt_at LIMIT sqlc.arg('row_limit') FOR UPDATE SKIP LOCKED
+)
+RETURNING d.id, d.event_type, d.payload, d.attempts, s.url, s.secret;
+
+-- name: CreateWebhookDeliveryAttempt :exec
+INSERT INTO webhook_delivery_attempts(id, delivery_id, attempted_at, status_code, response_body, error, duration_ms)
+VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6);
+
+-- name: MarkWebhookDeliveryDelivered :exec
+UPDATE webhook_deliveries SET status= 'delivered', attempts= attempts + 1, delivered_at= NOW(), last_error= NULL WHERE id= $1;
+
+-- name: RetryWebhookDeliveryLater :exec
+UPDATE webhook_deliveries SET attempts= attempts + 1, next_attempt_at= $2, last_error= $3 WHERE id= $1;
+
+-- name: DeadLetterWebhookDelivery :exec
+UPDATE webhook_deliveries SET status= 'dead', attempts= attempts + 1, last_error= $2 WHERE id= $1;
+
+-- name: RequeueWebhookDelivery :execrows
+-- a dead delivery gets all its attempts again, the ones already made stay in its log
+UPDATE webhook_deliveries SET status= 'pending', attempts= 0, next_attempt_at= NOW() WHERE id= $1 AND status= 'dead';
+
+-- name: GetWebhookDelivery :one
+SELECT * FROM webhook_deliveries WHERE id= $1 LIMIT 1;
+
+-- name: ListWebhookDeliveries :many
+SELECT * FROM webhook_deliveries
+WHERE (sqlc.narg('subscription_id')::uuid IS NULL OR subscription_id= sqlc.narg('subscription_id'))
+    AND (sqlc.narg('status')::text IS NULL OR status= sqlc.narg('status'))
+    AND (sqlc.narg('before_created_at')::timestamp IS NULL OR (created_at, id) < (sqlc.narg('before_created_at')::timestamp, sqlc.narg('before_id')::uuid))
+ORDER BY created_at DESC, id DESC
+LIMIT sqlc.arg('row_limit');
+
+-- name: ListWebhookDeliveryAttempts :many
+SELECT * FROM webhook_delivery_attempts WHERE delivery_id= $1 ORDER BY attempted_at;
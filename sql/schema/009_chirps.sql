@@ -0,0 +1,11 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN in_reply_to UUID REFERENCES chirps(id) ON DELETE SET NULL;
+ALTER TABLE chirps ADD COLUMN root_id UUID REFERENCES chirps(id) ON DELETE SET NULL;
+ALTER TABLE chirps ADD COLUMN deleted_at TIMESTAMP DEFAULT NULL;
+CREATE INDEX chirps_in_reply_to_idx ON chirps(in_reply_to);
+CREATE INDEX chirps_root_id_idx ON chirps(root_id);
+
+-- +goose Down
+ALTER TABLE chirps DROP COLUMN deleted_at;
+ALTER TABLE chirps DROP COLUMN root_id;
+ALTER TABLE chirps DROP COLUMN in_reply_to;
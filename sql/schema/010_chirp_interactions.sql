@@ -0,0 +1,70 @@
+-- +goose Up
+ALTER TABLE chirps ADD COLUMN quote_of UUID REFERENCES chirps(id) ON DELETE SET NULL;
+ALTER TABLE chirps ADD COLUMN like_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN rechirp_count INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN reply_count INTEGER NOT NULL DEFAULT 0;
+UPDATE chirps SET reply_count= (SELECT COUNT(*) FROM chirps replies WHERE replies.in_reply_to= chirps.id);
+
+CREATE TABLE chirp_likes(user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL, PRIMARY KEY (user_id, chirp_id));
+CREATE INDEX chirp_likes_chirp_id_idx ON chirp_likes(chirp_id);
+CREATE TABLE rechirps(user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, chirp_id UUID NOT NULL REFERENCES chirps(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL, PRIMARY KEY (user_id, chirp_id));
+CREATE INDEX rechirps_chirp_id_idx ON rechirps(chirp_id);
+
+-- the counters are maintained by triggers: the increment happens in the same transaction as the insert/delete and
+-- the row lock on the chirp serializes concurrent likes, so they can't drift and we never need a COUNT(*) when listing
+-- +goose StatementBegin
+CREATE FUNCTION count_chirp_likes() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET like_count= like_count + 1 WHERE id= NEW.chirp_id;
+    ELSE
+        UPDATE chirps SET like_count= like_count - 1 WHERE id= OLD.chirp_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION count_rechirps() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET rechirp_count= rechirp_count + 1 WHERE id= NEW.chirp_id;
+    ELSE
+        UPDATE chirps SET rechirp_count= rechirp_count - 1 WHERE id= OLD.chirp_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+-- +goose StatementBegin
+CREATE FUNCTION count_chirp_replies() RETURNS TRIGGER AS $$
+BEGIN
+    IF TG_OP = 'INSERT' THEN
+        UPDATE chirps SET reply_count= reply_count + 1 WHERE id= NEW.in_reply_to;
+    ELSE
+        UPDATE chirps SET reply_count= reply_count - 1 WHERE id= OLD.in_reply_to;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER chirp_likes_count AFTER INSERT OR DELETE ON chirp_likes FOR EACH ROW EXECUTE FUNCTION count_chirp_likes();
+CREATE TRIGGER rechirps_count AFTER INSERT OR DELETE ON rechirps FOR EACH ROW EXECUTE FUNCTION count_rechirps();
+CREATE TRIGGER chirp_replies_count AFTER INSERT OR DELETE ON chirps FOR EACH ROW EXECUTE FUNCTION count_chirp_replies();
+
+-- +goose Down
+DROP TRIGGER chirp_replies_count ON chirps;
+DROP TABLE rechirps;
+DROP TABLE chirp_likes;
+DROP FUNCTION count_chirp_replies;
+DROP FUNCTION count_rechirps;
+DROP FUNCTION count_chirp_likes;
+ALTER TABLE chirps DROP COLUMN reply_count;
+ALTER TABLE chirps DROP COLUMN rechirp_count;
+ALTER TABLE chirps DROP COLUMN like_count;
+ALTER TABLE chirps DROP COLUMN quote_of;
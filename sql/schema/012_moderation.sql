@@ -0,0 +1,13 @@
+-- +goose Up
+CREATE TABLE moderation_words(word TEXT PRIMARY KEY, action TEXT NOT NULL CHECK (action IN ('allow', 'mask', 'hold', 'reject')),
+    created_at TIMESTAMP NOT NULL, updated_at TIMESTAMP NOT NULL);
+INSERT INTO moderation_words(word, action, created_at, updated_at)
+VALUES ('kerfuffle', 'mask', NOW(), NOW()), ('sharbert', 'mask', NOW(), NOW()), ('fornax', 'mask', NOW(), NOW());
+
+ALTER TABLE chirps ADD COLUMN status TEXT NOT NULL DEFAULT 'published' CHECK (status IN ('published', 'held', 'hidden'));
+CREATE INDEX chirps_not_published_idx ON chirps(status, created_at) WHERE status <> 'published';
+
+-- +goose Down
+DROP INDEX chirps_not_published_idx;
+ALTER TABLE chirps DROP COLUMN status;
+DROP TABLE moderation_words;
@@ -0,0 +1,25 @@
+-- +goose Up
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP;
+
+CREATE TABLE moderation_actions(id UUID PRIMARY KEY, created_at TIMESTAMP NOT NULL, moderator_id UUID REFERENCES users(id) ON DELETE SET NULL,
+    action TEXT NOT NULL CHECK (action IN ('dismiss', 'publish_chirp', 'hide_chirp', 'delete_chirp', 'suspend_user', 'unsuspend_user')),
+    chirp_id UUID REFERENCES chirps(id) ON DELETE SET NULL, user_id UUID REFERENCES users(id) ON DELETE SET NULL, notes TEXT NOT NULL DEFAULT '');
+CREATE INDEX moderation_actions_created_at_idx ON moderation_actions(created_at, id);
+
+CREATE TABLE reports(id UUID PRIMARY KEY, created_at TIMESTAMP NOT NULL, reporter_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    chirp_id UUID REFERENCES chirps(id) ON DELETE CASCADE, reported_user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    reason TEXT NOT NULL CHECK (reason IN ('spam', 'harassment', 'hate_speech', 'violence', 'sexual_content', 'misinformation', 'self_harm', 'impersonation', 'other')),
+    details TEXT NOT NULL DEFAULT '', resolved_at TIMESTAMP, action_id UUID REFERENCES moderation_actions(id) ON DELETE SET NULL,
+    CHECK (reporter_id <> reported_user_id));
+CREATE INDEX reports_open_idx ON reports(created_at, id) WHERE resolved_at IS NULL;
+CREATE INDEX reports_resolved_idx ON reports(created_at, id) WHERE resolved_at IS NOT NULL;
+CREATE INDEX reports_chirp_id_idx ON reports(chirp_id) WHERE resolved_at IS NULL;
+CREATE INDEX reports_reported_user_id_idx ON reports(reported_user_id) WHERE resolved_at IS NULL;
+-- a user can only have one open report about the same chirp, or about the same user when no chirp is involved
+CREATE UNIQUE INDEX reports_open_chirp_unique_idx ON reports(reporter_id, chirp_id) WHERE resolved_at IS NULL AND chirp_id IS NOT NULL;
+CREATE UNIQUE INDEX reports_open_user_unique_idx ON reports(reporter_id, reported_user_id) WHERE resolved_at IS NULL AND chirp_id IS NULL;
+
+-- +goose Down
+DROP TABLE reports;
+DROP TABLE moderation_actions;
+ALTER TABLE users DROP COLUMN suspended_at;
@@ -0,0 +1,19 @@
+-- +goose Up
+-- only the SHA-256 of the tokens is kept, the existing tokens are hashed in place so the sessions survive the migration
+UPDATE refresh_tokens SET token= encode(sha256(convert_to(token, 'UTF8')), 'hex');
+ALTER TABLE refresh_tokens RENAME COLUMN token TO token_hash;
+-- every login starts a family, each refresh rotates the token inside it
+ALTER TABLE refresh_tokens ADD COLUMN family_id UUID NOT NULL DEFAULT gen_random_uuid();
+ALTER TABLE refresh_tokens ALTER COLUMN family_id DROP DEFAULT;
+ALTER TABLE refresh_tokens ADD COLUMN rotated_at TIMESTAMP;
+CREATE INDEX refresh_tokens_family_id_idx ON refresh_tokens(family_id);
+CREATE INDEX refresh_tokens_user_id_idx ON refresh_tokens(user_id);
+
+-- +goose Down
+DROP INDEX refresh_tokens_user_id_idx;
+DROP INDEX refresh_tokens_family_id_idx;
+ALTER TABLE refresh_tokens DROP COLUMN rotated_at;
+ALTER TABLE refresh_tokens DROP COLUMN family_id;
+ALTER TABLE refresh_tokens RENAME COLUMN token_hash TO token;
+-- the hashes can't be turned back into tokens
+UPDATE refresh_tokens SET revoked_at= NOW(), updated_at= NOW() WHERE revoked_at IS NULL;
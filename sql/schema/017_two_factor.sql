@@ -0,0 +1,11 @@
+-- +goose Up
+-- confirmed_at stays NULL until the user proved their authenticator works, until then 2FA is not enforced
+CREATE TABLE user_totp(user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE, secret TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL, confirmed_at TIMESTAMP, last_used_step BIGINT NOT NULL DEFAULT 0);
+CREATE TABLE recovery_codes(id UUID PRIMARY KEY, user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, code_hash TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL, used_at TIMESTAMP);
+CREATE INDEX recovery_codes_user_id_idx ON recovery_codes(user_id) WHERE used_at IS NULL;
+
+-- +goose Down
+DROP TABLE recovery_codes;
+DROP TABLE user_totp;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- a key signs between signs_from and signs_until, then only validates the tokens it signed until valid_until
+CREATE TABLE signing_keys(id TEXT PRIMARY KEY, algorithm TEXT NOT NULL CHECK (algorithm IN ('EdDSA', 'RS256')),
+    private_key TEXT NOT NULL, created_at TIMESTAMP NOT NULL, signs_from TIMESTAMP NOT NULL, signs_until TIMESTAMP NOT NULL,
+    valid_until TIMESTAMP NOT NULL);
+
+-- +goose Down
+DROP TABLE signing_keys;
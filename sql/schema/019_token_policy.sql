@@ -0,0 +1,9 @@
+-- +goose Up
+-- the kind of client and the access token lifetime it asked for at login, the refreshes of the session keep them.
+-- 0 means the default lifetime of the client
+ALTER TABLE refresh_tokens ADD COLUMN client TEXT NOT NULL DEFAULT '';
+ALTER TABLE refresh_tokens ADD COLUMN access_token_ttl_seconds INTEGER NOT NULL DEFAULT 0;
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN access_token_ttl_seconds;
+ALTER TABLE refresh_tokens DROP COLUMN client;
@@ -0,0 +1,8 @@
+-- +goose Up
+-- like the refresh tokens, only the hash of a reset token is stored
+CREATE TABLE password_reset_tokens(token_hash TEXT PRIMARY KEY, user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, used_at TIMESTAMP);
+CREATE INDEX password_reset_tokens_user_id_idx ON password_reset_tokens(user_id) WHERE used_at IS NULL;
+
+-- +goose Down
+DROP TABLE password_reset_tokens;
@@ -0,0 +1,12 @@
+-- +goose Up
+-- the accounts created before verification existed are trusted with the address they have
+ALTER TABLE users ADD COLUMN email_verified_at TIMESTAMP;
+UPDATE users SET email_verified_at= created_at;
+-- a token verifies one address: the one of the account at signup, or the new one of a pending email change
+CREATE TABLE email_verification_tokens(token_hash TEXT PRIMARY KEY, user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email TEXT NOT NULL, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, used_at TIMESTAMP);
+CREATE INDEX email_verification_tokens_user_id_idx ON email_verification_tokens(user_id) WHERE used_at IS NULL;
+
+-- +goose Down
+DROP TABLE email_verification_tokens;
+ALTER TABLE users DROP COLUMN email_verified_at;
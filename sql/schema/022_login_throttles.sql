@@ -0,0 +1,8 @@
+-- +goose Up
+-- the failed logins of an account (by email, whether it exists or not) and of an IP address
+CREATE TABLE login_throttles(kind TEXT NOT NULL CHECK (kind IN ('account', 'ip')), subject TEXT NOT NULL,
+    failures INTEGER NOT NULL, last_failure_at TIMESTAMP NOT NULL, blocked_until TIMESTAMP NOT NULL, PRIMARY KEY (kind, subject));
+CREATE INDEX login_throttles_blocked_until_idx ON login_throttles(blocked_until);
+
+-- +goose Down
+DROP TABLE login_throttles;
@@ -0,0 +1,9 @@
+-- +goose Up
+-- only the hash of a key is stored, the prefix is kept to recognize it in the lists
+CREATE TABLE api_keys(id UUID PRIMARY KEY, user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, name TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE, prefix TEXT NOT NULL, scopes TEXT[] NOT NULL, created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL, last_used_at TIMESTAMP, revoked_at TIMESTAMP);
+CREATE INDEX api_keys_user_id_idx ON api_keys(user_id);
+
+-- +goose Down
+DROP TABLE api_keys;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- a client without a secret is public (a single page or mobile app), PKCE is what protects its codes
+CREATE TABLE oauth_clients(id UUID PRIMARY KEY, owner_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, name TEXT NOT NULL,
+    secret_hash TEXT, redirect_uris TEXT[] NOT NULL, scopes TEXT[] NOT NULL, created_at TIMESTAMP NOT NULL);
+CREATE INDEX oauth_clients_owner_id_idx ON oauth_clients(owner_id);
+
+-- the family of the refresh tokens the code was exchanged for, a code used twice revokes it
+CREATE TABLE oauth_authorization_codes(code_hash TEXT PRIMARY KEY, client_id UUID NOT NULL REFERENCES oauth_clients(id) ON DELETE CASCADE,
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE, redirect_uri TEXT NOT NULL, scopes TEXT[] NOT NULL,
+    code_challenge TEXT NOT NULL, created_at TIMESTAMP NOT NULL, expires_at TIMESTAMP NOT NULL, used_at TIMESTAMP, family_id UUID NOT NULL);
+
+-- the refresh tokens of a client only give access tokens with the scopes the user consented to
+ALTER TABLE refresh_tokens ADD COLUMN oauth_client_id UUID REFERENCES oauth_clients(id) ON DELETE CASCADE;
+ALTER TABLE refresh_tokens ADD COLUMN scopes TEXT[] NOT NULL DEFAULT '{}';
+
+-- +goose Down
+ALTER TABLE refresh_tokens DROP COLUMN scopes;
+ALTER TABLE refresh_tokens DROP COLUMN oauth_client_id;
+DROP TABLE oauth_authorization_codes;
+DROP TABLE oauth_clients;
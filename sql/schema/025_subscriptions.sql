@@ -0,0 +1,13 @@
+-- +goose Up
+-- is_chirpy_red stays the entitlement the rest of the app reads, the subscription tells until when it is paid for
+CREATE TABLE subscriptions(user_id UUID PRIMARY KEY REFERENCES users(id) ON DELETE CASCADE, plan TEXT NOT NULL, status TEXT NOT NULL,
+    current_period_end TIMESTAMP NOT NULL, cancel_at_period_end BOOLEAN NOT NULL DEFAULT FALSE, created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL);
+CREATE INDEX subscriptions_current_period_end_idx ON subscriptions(current_period_end) WHERE status IN ('active', 'past_due');
+
+-- the users upgraded before get a period of 30 days, the next renewal from Polka extends it
+INSERT INTO subscriptions(user_id, plan, status, current_period_end, created_at, updated_at)
+SELECT id, 'chirpy_red', 'active', NOW() + INTERVAL '30 days', NOW(), NOW() FROM users WHERE is_chirpy_red;
+
+-- +goose Down
+DROP TABLE subscriptions;
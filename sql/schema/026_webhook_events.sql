@@ -0,0 +1,8 @@
+-- +goose Up
+-- every verified delivery is kept as it was received, the id of the event makes a redelivery a no-op once processed
+CREATE TABLE webhook_events(id TEXT PRIMARY KEY, event_type TEXT NOT NULL, payload BYTEA NOT NULL, received_at TIMESTAMP NOT NULL,
+    processed_at TIMESTAMP, attempts INTEGER NOT NULL DEFAULT 0, last_error TEXT);
+CREATE INDEX webhook_events_received_at_idx ON webhook_events(received_at DESC, id DESC);
+
+-- +goose Down
+DROP TABLE webhook_events;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- the secret signs the deliveries, so it is kept as is. No event type means every event
+CREATE TABLE webhook_subscriptions(id UUID PRIMARY KEY, url TEXT NOT NULL, secret TEXT NOT NULL, event_types TEXT[] NOT NULL DEFAULT '{}',
+    created_by UUID REFERENCES users(id) ON DELETE SET NULL, created_at TIMESTAMP NOT NULL);
+
+-- a delivery stays pending until the subscriber accepts it (delivered) or it runs out of attempts (dead)
+CREATE TABLE webhook_deliveries(id UUID PRIMARY KEY, subscription_id UUID NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id UUID NOT NULL, event_type TEXT NOT NULL, payload BYTEA NOT NULL, status TEXT NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0, next_attempt_at TIMESTAMP NOT NULL, created_at TIMESTAMP NOT NULL, delivered_at TIMESTAMP, last_error TEXT);
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries(next_attempt_at) WHERE status= 'pending';
+CREATE INDEX webhook_deliveries_created_at_idx ON webhook_deliveries(created_at DESC, id DESC);
+
+CREATE TABLE webhook_delivery_attempts(id UUID PRIMARY KEY, delivery_id UUID NOT NULL REFERENCES webhook_deliveries(id) ON DELETE CASCADE,
+    attempted_at TIMESTAMP NOT NULL, status_code INTEGER, response_body TEXT, error TEXT, duration_ms INTEGER NOT NULL);
+CREATE INDEX webhook_delivery_attempts_delivery_id_idx ON webhook_delivery_attempts(delivery_id, attempted_at);
+
+-- +goose Down
+DROP TABLE webhook_delivery_attempts;
+DROP TABLE webhook_deliveries;
+DROP TABLE webhook_subscriptions;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- the events are written in the transaction of the change they tell about, the dispatcher hands them to the subscribers
+CREATE TABLE outbox_events(id UUID PRIMARY KEY, event_type TEXT NOT NULL, payload BYTEA NOT NULL, created_at TIMESTAMP NOT NULL,
+    dispatched_at TIMESTAMP, attempts INTEGER NOT NULL DEFAULT 0, next_attempt_at TIMESTAMP NOT NULL, last_error TEXT);
+CREATE INDEX outbox_events_due_idx ON outbox_events(next_attempt_at) WHERE dispatched_at IS NULL;
+
+-- a subscriber commits its side effects with the row saying it handled the event, so it never handles it twice
+CREATE TABLE outbox_handled_events(event_id UUID NOT NULL REFERENCES outbox_events(id) ON DELETE CASCADE, subscriber TEXT NOT NULL,
+    handled_at TIMESTAMP NOT NULL, PRIMARY KEY (event_id, subscriber));
+
+-- +goose Down
+DROP TABLE outbox_handled_events;
+DROP TABLE outbox_events;
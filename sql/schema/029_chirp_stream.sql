@@ -0,0 +1,23 @@
+-- +goose Up
+-- the position orders the events of the stream, a client reconnecting with Last-Event-ID resumes after it
+ALTER TABLE outbox_events ADD COLUMN position BIGSERIAL;
+CREATE UNIQUE INDEX outbox_events_position_idx ON outbox_events(position);
+
+-- the chirp events are announced to every instance when their transaction commits, the payload is the position
+-- +goose StatementBegin
+CREATE FUNCTION notify_chirp_event() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('chirp_events', NEW.position::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER outbox_events_notify_chirp_event AFTER INSERT ON outbox_events
+FOR EACH ROW WHEN (NEW.event_type IN ('chirp.created', 'chirp.deleted')) EXECUTE FUNCTION notify_chirp_event();
+
+-- +goose Down
+DROP TRIGGER outbox_events_notify_chirp_event ON outbox_events;
+DROP FUNCTION notify_chirp_event;
+DROP INDEX outbox_events_position_idx;
+ALTER TABLE outbox_events DROP COLUMN position;
@@ -0,0 +1,45 @@
+-- +goose Up
+-- a position taken at the insert can commit after a greater one, a stream already past it would never get the event.
+-- The chirp events take theirs when their transaction commits instead, one transaction at a time, so the positions
+-- follow the commits. The other events don't need one anymore
+ALTER TABLE outbox_events ALTER COLUMN position DROP DEFAULT, ALTER COLUMN position DROP NOT NULL;
+DROP TRIGGER outbox_events_notify_chirp_event ON outbox_events;
+DROP FUNCTION notify_chirp_event;
+
+-- +goose StatementBegin
+CREATE FUNCTION assign_chirp_event_position() RETURNS TRIGGER AS $$
+DECLARE
+    event_position BIGINT;
+BEGIN
+    -- held until the commit, the next transaction only takes a position once this one is visible
+    PERFORM pg_advisory_xact_lock(hashtext('outbox_events_position'));
+    event_position := nextval('outbox_events_position_seq');
+    UPDATE outbox_events SET position= event_position WHERE id= NEW.id;
+    PERFORM pg_notify('chirp_events', event_position::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE CONSTRAINT TRIGGER outbox_events_assign_chirp_event_position AFTER INSERT ON outbox_events
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW WHEN (NEW.event_type IN ('chirp.created', 'chirp.published', 'chirp.deleted'))
+EXECUTE FUNCTION assign_chirp_event_position();
+
+-- +goose Down
+DROP TRIGGER outbox_events_assign_chirp_event_position ON outbox_events;
+DROP FUNCTION assign_chirp_event_position;
+UPDATE outbox_events SET position= nextval('outbox_events_position_seq') WHERE position IS NULL;
+ALTER TABLE outbox_events ALTER COLUMN position SET DEFAULT nextval('outbox_events_position_seq'), ALTER COLUMN position SET NOT NULL;
+
+-- +goose StatementBegin
+CREATE FUNCTION notify_chirp_event() RETURNS TRIGGER AS $$
+BEGIN
+    PERFORM pg_notify('chirp_events', NEW.position::text);
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+-- +goose StatementEnd
+
+CREATE TRIGGER outbox_events_notify_chirp_event AFTER INSERT ON outbox_events
+FOR EACH ROW WHEN (NEW.event_type IN ('chirp.created', 'chirp.deleted')) EXECUTE FUNCTION notify_chirp_event();
@@ -0,0 +1,15 @@
+-- +goose Up
+-- the users upgraded before the subscriptions were tracked were given 30 days in 025, then lost Chirpy Red when they
+-- lapsed. Their subscription has no end until Polka tells about it instead. The backfilled rows are the ones whose
+-- period still ends 30 days after their creation, a renewal from Polka would have moved it
+ALTER TABLE subscriptions ALTER COLUMN current_period_end DROP NOT NULL;
+WITH grandfathered AS (
+    UPDATE subscriptions SET current_period_end= NULL, status= 'active', updated_at= NOW()
+    WHERE current_period_end = created_at + INTERVAL '30 days' AND status IN ('active', 'expired') AND NOT cancel_at_period_end
+    RETURNING user_id
+)
+UPDATE users SET is_chirpy_red= TRUE, updated_at= NOW() WHERE id IN (SELECT user_id FROM grandfathered);
+
+-- +goose Down
+UPDATE subscriptions SET current_period_end= created_at + INTERVAL '30 days' WHERE current_period_end IS NULL;
+ALTER TABLE subscriptions ALTER COLUMN current_period_end SET NOT NULL;
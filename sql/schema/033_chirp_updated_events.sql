@@ -0,0 +1,14 @@
+-- +goose Up
+-- the edits are streamed too, so they take a position like the other chirp events
+DROP TRIGGER outbox_events_assign_chirp_event_position ON outbox_events;
+CREATE CONSTRAINT TRIGGER outbox_events_assign_chirp_event_position AFTER INSERT ON outbox_events
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW WHEN (NEW.event_type IN ('chirp.created', 'chirp.updated', 'chirp.published', 'chirp.deleted'))
+EXECUTE FUNCTION assign_chirp_event_position();
+
+-- +goose Down
+DROP TRIGGER outbox_events_assign_chirp_event_position ON outbox_events;
+CREATE CONSTRAINT TRIGGER outbox_events_assign_chirp_event_position AFTER INSERT ON outbox_events
+DEFERRABLE INITIALLY DEFERRED
+FOR EACH ROW WHEN (NEW.event_type IN ('chirp.created', 'chirp.published', 'chirp.deleted'))
+EXECUTE FUNCTION assign_chirp_event_position();